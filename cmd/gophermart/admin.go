@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/thalq/gopher_mart/internal/admin"
+	"github.com/thalq/gopher_mart/internal/repository/postgres"
+	"github.com/thalq/gopher_mart/pkg/config"
+	"github.com/thalq/gopher_mart/pkg/storage"
+)
+
+const grantAdminUsage = "usage: gophermart [flags] grant-admin <user-id>"
+
+// runGrantAdmin implements the `gophermart grant-admin` subcommand. It is
+// the only way to get the first admin; later ones are appointed through
+// the admin API.
+func runGrantAdmin(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New(grantAdminUsage)
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || userID < 1 {
+		return fmt.Errorf("invalid user id %q: %s", args[0], grantAdminUsage)
+	}
+
+	storage.Connect(cfg.DatabaseURI)
+	db := storage.GetDB()
+	defer db.Close()
+
+	service := admin.NewAdminService(postgres.New(db), nil, admin.Options{})
+	if err := service.BootstrapAdmin(context.Background(), userID); err != nil {
+		return err
+	}
+	fmt.Printf("User %d is an admin\n", userID)
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/accrual"
+	"github.com/thalq/gopher_mart/internal/accrual/fake"
+	"github.com/thalq/gopher_mart/internal/health"
+	"github.com/thalq/gopher_mart/internal/metrics"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/orders"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/repository/memory"
+	"github.com/thalq/gopher_mart/internal/repository/postgres"
+	"github.com/thalq/gopher_mart/internal/tokens"
+	"github.com/thalq/gopher_mart/internal/tracing"
+	"github.com/thalq/gopher_mart/pkg/config"
+	router "github.com/thalq/gopher_mart/pkg/http"
+	"github.com/thalq/gopher_mart/pkg/storage"
+)
+
+// app owns every long-living component of gophermart. Components are
+// stopped in the reverse order of their dependencies: the HTTP server is
+// drained first, then background workers, then the accrual fake and the
+// database pool.
+type app struct {
+	cfg           *config.Config
+	server        *http.Server
+	accrualWorker *orders.AccrualWorker
+	expiryWorker  *orders.ExpiryWorker
+	checker       *health.Checker
+	fakeAccrual   *fake.Server
+	usesDB        bool
+
+	shutdownTracing func(context.Context) error
+}
+
+func newApp(cfg *config.Config) (*app, error) {
+	a := &app{cfg: cfg}
+
+	keys, err := tokens.LoadKeySet(tokens.KeyConfig{
+		Secret:           cfg.JWTSecret,
+		SigningKeyFile:   cfg.JWTSigningKeyFile,
+		KeyID:            cfg.JWTKeyID,
+		VerificationKeys: cfg.JWTVerificationKeys,
+		PreviousSecrets:  cfg.JWTPreviousSecrets,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.TracingExporter, cfg.TracingFile)
+	if err != nil {
+		return nil, err
+	}
+	a.shutdownTracing = shutdownTracing
+
+	var store repository.Store
+	if cfg.MemoryStorage {
+		store = memory.New()
+		logger.Sugar.Info("Using in-memory storage")
+	} else {
+		storage.InitDB(cfg.DatabaseURI)
+		a.usesDB = true
+		metrics.RegisterDBStats(storage.GetDB())
+		pgStore := postgres.New(storage.GetDB())
+		ctx := context.Background()
+		err := storage.WithMigrationLock(ctx, storage.GetDB(), func() error {
+			return pgStore.BackfillLedger(ctx)
+		})
+		if err != nil {
+			storage.Close()
+			return nil, err
+		}
+		store = pgStore
+	}
+
+	if cfg.FakeAccrual {
+		a.fakeAccrual = fake.NewServer()
+		a.fakeAccrual.SetDefault(fake.Response{Status: models.OrderStatusProcessed, Accrual: 500 * models.MoneyScale})
+		cfg.AccrualSystemAddress = a.fakeAccrual.URL()
+		logger.Sugar.Infof("Fake accrual system started on %s", cfg.AccrualSystemAddress)
+	}
+	accrualClient := accrual.NewClient(cfg.AccrualSystemAddress)
+
+	tiers, err := orders.ParseTiers(cfg.LoyaltyTiers)
+	if err != nil {
+		return nil, err
+	}
+	orderService := orders.NewOrderService(store, accrualClient, orders.Options{
+		HoldTTL:            cfg.HoldTTL,
+		PointsExpireMonths: cfg.PointsExpireMonths,
+		ExpiryNotice:       cfg.ExpiryNotice,
+		Tiers:              tiers,
+	})
+	a.accrualWorker = orders.NewAccrualWorker(orderService, cfg.AccrualWorkers, cfg.AccrualPollInterval)
+	a.expiryWorker = orders.NewExpiryWorker(orderService, cfg.ExpiryInterval)
+
+	a.checker = health.NewChecker()
+	storageName := "postgres"
+	if !a.usesDB {
+		storageName = "memory"
+	}
+	a.checker.AddReadiness(storageName, store.Ping)
+	a.checker.AddReadiness("accrual", accrualClient.Ping)
+	a.checker.AddLiveness("accrual_worker", a.accrualWorker.Healthy)
+
+	handler, err := router.NewRouter(cfg, store, orderService, a.checker, keys)
+	if err != nil {
+		return nil, err
+	}
+	a.server = &http.Server{
+		Addr:    cfg.RunAdress,
+		Handler: handler,
+	}
+	return a, nil
+}
+
+// run serves until ctx is cancelled or the server fails, then shuts
+// everything down.
+func (a *app) run(ctx context.Context) error {
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			a.accrualWorker.Run(workerCtx)
+		}()
+		go func() {
+			defer wg.Done()
+			a.expiryWorker.Run(workerCtx)
+		}()
+		wg.Wait()
+	}()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Sugar.Infof("Starting server on %s", a.cfg.RunAdress)
+		if err := a.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		logger.Sugar.Info("Shutdown signal received")
+	case runErr = <-serverErr:
+		logger.Sugar.Errorf("Error run server: %s", runErr)
+	}
+
+	return errors.Join(runErr, a.shutdown(stopWorker, workerDone))
+}
+
+func (a *app) shutdown(stopWorker context.CancelFunc, workerDone <-chan struct{}) error {
+	start := time.Now()
+	// Report not ready first and keep serving for a while, so load
+	// balancers stop routing new requests before the listener is closed.
+	a.checker.SetReady(false)
+	if a.cfg.ShutdownDelay > 0 {
+		logger.Sugar.Infof("Waiting %s before draining http server", a.cfg.ShutdownDelay)
+		time.Sleep(a.cfg.ShutdownDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := a.server.Shutdown(ctx); err != nil {
+		logger.Sugar.Errorf("Error drain http server: %s", err)
+		errs = append(errs, err)
+	}
+	logger.Sugar.Info("HTTP server stopped")
+
+	stopWorker()
+	select {
+	case <-workerDone:
+	case <-ctx.Done():
+		logger.Sugar.Errorf("Background workers did not stop in %s", a.cfg.ShutdownTimeout)
+		errs = append(errs, ctx.Err())
+	}
+
+	if a.fakeAccrual != nil {
+		a.fakeAccrual.Close()
+	}
+	if err := a.shutdownTracing(ctx); err != nil {
+		logger.Sugar.Errorf("Error flush traces: %s", err)
+		errs = append(errs, err)
+	}
+	if a.usesDB {
+		if err := storage.Close(); err != nil {
+			logger.Sugar.Errorf("Error close db: %s", err)
+			errs = append(errs, err)
+		}
+		logger.Sugar.Info("DB closed")
+	}
+	logger.Sugar.Infof("Shutdown finished in %s", time.Since(start))
+	return errors.Join(errs...)
+}
@@ -1,33 +1,47 @@
 package main
 
 import (
-	"net/http"
-	// "os/exec"
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
 
 	logger "github.com/thalq/gopher_mart/internal/middleware"
 	"github.com/thalq/gopher_mart/pkg/config"
-	router "github.com/thalq/gopher_mart/pkg/http"
-	"github.com/thalq/gopher_mart/pkg/storage"
 )
 
 func main() {
 	logger.InitLogger()
+	defer logger.SyncLogger()
 	cfg := config.NewConfig()
 
-	storage.InitDB(cfg.DatabaseURI)
-	router := router.NewRouter(cfg)
-
-	// cmd := exec.Command("./accrual_darwin_arm64", cfg.AccrualSystemAddress)
-	// output, err := cmd.CombinedOutput()
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			if err := runMigrate(cfg, args[1:]); err != nil {
+				logger.Sugar.Fatalf("Error migrate: %s", err)
+			}
+		case "grant-admin":
+			if err := runGrantAdmin(cfg, args[1:]); err != nil {
+				logger.Sugar.Fatalf("Error grant-admin: %s", err)
+			}
+		default:
+			logger.Sugar.Fatalf("Unknown command %s", args[0])
+		}
+		return
+	}
 
-	// if err != nil {
-	// 	logger.Sugar.Fatalf("Ошибка запуска исполняемого файла: %s", err)
-	// 	return
-	// }
-	// logger.Sugar.Infof("Исполняемы файл запущен %s", string(output))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	logger.Sugar.Infof("Starting server on %s", cfg.RunAdress)
-	if err := http.ListenAndServe(cfg.RunAdress, router); err != nil {
-		logger.Sugar.Fatalf("Error run server: %s", err)
+	app, err := newApp(cfg)
+	if err != nil {
+		logger.Sugar.Fatalf("Error start app: %s", err)
+	}
+	if err := app.run(ctx); err != nil {
+		logger.Sugar.Errorf("Error run app: %s", err)
+		logger.SyncLogger()
+		os.Exit(1)
 	}
 }
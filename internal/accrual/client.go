@@ -0,0 +1,168 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/errors"
+	"github.com/thalq/gopher_mart/internal/metrics"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const defaultRetryAfter = 60 * time.Second
+
+// Client talks to the accrual system. A single Client is shared by the whole
+// process: once the accrual system answers 429 every caller is paused until
+// the Retry-After window is over.
+type Client struct {
+	AccrualSystemAddress string
+	httpClient           *http.Client
+
+	mu          sync.RWMutex
+	pausedUntil time.Time
+}
+
+func NewClient(AccrualSystemAddress string) *Client {
+	return &Client{
+		AccrualSystemAddress: AccrualSystemAddress,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+			// Propagates W3C trace context to the accrual system.
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+	}
+}
+
+// Throttled reports whether outgoing calls are paused by a 429 response.
+func (c *Client) Throttled() bool {
+	return c.RetryAfter() > 0
+}
+
+// RetryAfter returns how long outgoing calls stay paused.
+func (c *Client) RetryAfter() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if wait := time.Until(c.pausedUntil); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Wait blocks until the pause is over or ctx is done.
+func (c *Client) Wait(ctx context.Context) error {
+	for {
+		wait := c.RetryAfter()
+		if wait == 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) pause(wait time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if until := time.Now().Add(wait); until.After(c.pausedUntil) {
+		c.pausedUntil = until
+	}
+}
+
+func (c *Client) FetchAccrualInfo(ctx context.Context, orderNumber string) (models.AccrualInfo, error) {
+	if c.Throttled() {
+		return models.AccrualInfo{}, errors.ErrTooManyRequests
+	}
+
+	url := c.AccrualSystemAddress + "/api/orders/" + orderNumber
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return models.AccrualInfo{}, err
+	}
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		observe("error", start)
+		logger.Sugar.Errorf("Failed to send request to accrual system: %v", err)
+		return models.AccrualInfo{}, err
+	}
+	defer resp.Body.Close()
+	observe(strconv.Itoa(resp.StatusCode), start)
+
+	var accrualInfo models.AccrualInfo
+	switch resp.StatusCode {
+	case http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			logger.Sugar.Errorf("Failed to read response body: %v", err)
+			return models.AccrualInfo{}, err
+		}
+		if err := json.Unmarshal(body, &accrualInfo); err != nil {
+			logger.Sugar.Errorf("Failed to unmarshal response %q: %v", body, err)
+			return models.AccrualInfo{}, fmt.Errorf("%w: %v", errors.ErrInvalidAccrual, err)
+		}
+		logger.Sugar.Infof("Got accrual info: %v", accrualInfo)
+	case http.StatusNoContent:
+		accrualInfo.SetDefaults(orderNumber)
+		logger.Sugar.Infof("Order %s not found", orderNumber)
+	case http.StatusTooManyRequests:
+		wait := parseRetryAfter(resp.Header.Get("Retry-After"))
+		c.pause(wait)
+		metrics.AccrualThrottled.Inc()
+		logger.Sugar.Infof("Too many requests to accrual system, pausing for %s", wait)
+		return models.AccrualInfo{}, errors.ErrTooManyRequests
+	default:
+		logger.Sugar.Infof("Accrual system answered %d", resp.StatusCode)
+		return models.AccrualInfo{}, errors.ErrInternalServer
+	}
+	return accrualInfo, nil
+}
+
+func observe(outcome string, start time.Time) {
+	metrics.AccrualRequests.WithLabelValues(outcome).Inc()
+	metrics.AccrualRequestDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+}
+
+// Ping checks that the accrual system answers HTTP requests at all; any
+// status code counts as reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.AccrualSystemAddress, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// parseRetryAfter accepts both delay-seconds and HTTP-date forms.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return defaultRetryAfter
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
@@ -0,0 +1,134 @@
+// Package fake provides a scriptable in-process accrual system for tests and
+// local development.
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+// Response describes a single answer of the fake accrual system.
+// StatusCode defaults to 200 when Status is set and to 204 otherwise.
+type Response struct {
+	StatusCode int
+	Status     string
+	Accrual    models.Money
+	Delay      time.Duration
+	RetryAfter time.Duration
+}
+
+// Server serves GET /api/orders/{number}. Every order has a script of
+// responses that are returned one by one, the last one is repeated.
+type Server struct {
+	mu              sync.Mutex
+	scripts         map[string][]Response
+	calls           map[string]int
+	defaultResponse Response
+
+	httpServer *httptest.Server
+}
+
+// NewServer starts a fake accrual system on a random local port.
+func NewServer() *Server {
+	s := &Server{
+		scripts: make(map[string][]Response),
+		calls:   make(map[string]int),
+	}
+	s.httpServer = httptest.NewServer(s)
+	return s
+}
+
+// URL is the address to pass as ACCRUAL_SYSTEM_ADDRESS.
+func (s *Server) URL() string {
+	return s.httpServer.URL
+}
+
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// Script sets responses returned for the order number.
+func (s *Server) Script(orderNumber string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[orderNumber] = responses
+}
+
+// SetDefault sets the response for orders without a script.
+func (s *Server) SetDefault(response Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultResponse = response
+}
+
+// Calls returns how many times the order was requested.
+func (s *Server) Calls(orderNumber string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[orderNumber]
+}
+
+func (s *Server) next(orderNumber string) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	call := s.calls[orderNumber]
+	s.calls[orderNumber]++
+
+	script, ok := s.scripts[orderNumber]
+	if !ok || len(script) == 0 {
+		return s.defaultResponse
+	}
+	if call >= len(script) {
+		call = len(script) - 1
+	}
+	return script[call]
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	orderNumber, ok := strings.CutPrefix(r.URL.Path, "/api/orders/")
+	if r.Method != http.MethodGet || !ok || orderNumber == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	response := s.next(orderNumber)
+	if response.Delay > 0 {
+		select {
+		case <-time.After(response.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	statusCode := response.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusNoContent
+		if response.Status != "" {
+			statusCode = http.StatusOK
+		}
+	}
+	switch statusCode {
+	case http.StatusOK:
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.AccrualInfo{
+			OrderID: orderNumber,
+			Status:  response.Status,
+			Accrual: response.Accrual,
+		})
+	case http.StatusTooManyRequests:
+		if response.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(response.RetryAfter.Seconds())))
+		}
+		http.Error(w, "No more than N requests per minute allowed", http.StatusTooManyRequests)
+	default:
+		w.WriteHeader(statusCode)
+	}
+}
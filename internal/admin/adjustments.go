@@ -0,0 +1,221 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tracing"
+)
+
+var (
+	ErrInvalidAdjustment = errors.New("invalid adjustment")
+	ErrAdjustmentDecided = errors.New("adjustment is already decided")
+	ErrSelfApproval      = errors.New("adjustment must be approved by another admin")
+	ErrNotEnoughPoints   = errors.New("not enough points for the debit")
+)
+
+const (
+	AuditAdjustmentProposed = "admin.adjustment.propose"
+	AuditAdjustmentApproved = "admin.adjustment.approve"
+	AuditAdjustmentRejected = "admin.adjustment.reject"
+	AuditAdjustmentsView    = "admin.adjustment.view"
+)
+
+// ProposeAdjustment records a pending credit (positive amount) or debit
+// (negative amount). Nothing changes until another admin approves it.
+func (s *AdminService) ProposeAdjustment(
+	ctx context.Context,
+	actor Actor,
+	userID int64,
+	amount models.Money,
+	reasonCode string,
+	comment string,
+) (models.Adjustment, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.ProposeAdjustment")
+	defer span.End()
+
+	if amount == 0 || !models.ValidReasonCode(reasonCode) {
+		return models.Adjustment{}, ErrInvalidAdjustment
+	}
+	adjustment := models.Adjustment{
+		UserID:     userID,
+		Amount:     amount,
+		ReasonCode: reasonCode,
+		Comment:    comment,
+		Status:     models.AdjustmentPending,
+		ProposedBy: actor.ID,
+		ProposedAt: time.Now(),
+	}
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		if _, err := tx.Users().GetByID(ctx, userID); err != nil {
+			return err
+		}
+		var err error
+		if adjustment.ID, err = tx.Adjustments().Create(ctx, adjustment); err != nil {
+			return err
+		}
+		return s.record(ctx, tx, actor, AuditAdjustmentProposed, userSubject(userID), adjustmentDetails(adjustment))
+	})
+	if err != nil {
+		tracing.Error(span, err)
+		return models.Adjustment{}, err
+	}
+	return adjustment, nil
+}
+
+// ApproveAdjustment applies a pending adjustment proposed by someone else.
+// The ledger entry, the balance and the approval change in one
+// transaction. A debit takes the balance below zero only when debt is
+// allowed.
+func (s *AdminService) ApproveAdjustment(ctx context.Context, actor Actor, adjustmentID int64) (models.Adjustment, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.ApproveAdjustment")
+	defer span.End()
+
+	var adjustment models.Adjustment
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		var err error
+		if adjustment, err = s.pendingAdjustment(ctx, tx, adjustmentID); err != nil {
+			return err
+		}
+		if adjustment.ProposedBy == actor.ID {
+			return ErrSelfApproval
+		}
+		balance, err := tx.Balances().CurrentForUpdate(ctx, adjustment.UserID)
+		if err != nil {
+			return err
+		}
+		if balance+adjustment.Amount < 0 && adjustment.Amount < 0 && !s.opts.AllowDebt {
+			return ErrNotEnoughPoints
+		}
+		entryID, err := tx.Balances().Post(ctx, ledger.Adjustment(
+			adjustment.UserID,
+			adjustmentReference(adjustment.ID),
+			adjustmentDescription(adjustment),
+			adjustment.Amount,
+		))
+		if err != nil {
+			return err
+		}
+		if adjustment.Amount < 0 {
+			if err := tx.Lots().Consume(ctx, adjustment.UserID, "", -adjustment.Amount); err != nil {
+				return err
+			}
+		}
+		if err := s.decide(ctx, tx, &adjustment, actor, models.AdjustmentApproved, entryID); err != nil {
+			return err
+		}
+		return s.record(ctx, tx, actor, AuditAdjustmentApproved, userSubject(adjustment.UserID), adjustmentDetails(adjustment))
+	})
+	if err != nil {
+		tracing.Error(span, err)
+		return models.Adjustment{}, err
+	}
+	logger.Sugar.Infof("Adjustment %d of %s for user %d approved by %d", adjustment.ID, adjustment.Amount, adjustment.UserID, actor.ID)
+	return adjustment, nil
+}
+
+// RejectAdjustment closes a pending adjustment without applying it. The
+// proposer may reject their own adjustment to withdraw it.
+func (s *AdminService) RejectAdjustment(ctx context.Context, actor Actor, adjustmentID int64) (models.Adjustment, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.RejectAdjustment")
+	defer span.End()
+
+	var adjustment models.Adjustment
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		var err error
+		if adjustment, err = s.pendingAdjustment(ctx, tx, adjustmentID); err != nil {
+			return err
+		}
+		if err := s.decide(ctx, tx, &adjustment, actor, models.AdjustmentRejected, 0); err != nil {
+			return err
+		}
+		return s.record(ctx, tx, actor, AuditAdjustmentRejected, userSubject(adjustment.UserID), adjustmentDetails(adjustment))
+	})
+	if err != nil {
+		tracing.Error(span, err)
+		return models.Adjustment{}, err
+	}
+	return adjustment, nil
+}
+
+func (s *AdminService) GetAdjustment(ctx context.Context, actor Actor, adjustmentID int64) (models.Adjustment, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.GetAdjustment")
+	defer span.End()
+
+	adjustment, err := s.store.Adjustments().Get(ctx, adjustmentID)
+	if err != nil {
+		return models.Adjustment{}, err
+	}
+	if err := s.record(ctx, s.store, actor, AuditAdjustmentsView, adjustmentReference(adjustmentID), ""); err != nil {
+		return models.Adjustment{}, err
+	}
+	return adjustment, nil
+}
+
+func (s *AdminService) ListAdjustments(ctx context.Context, actor Actor, status string) ([]models.Adjustment, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.ListAdjustments")
+	defer span.End()
+
+	if err := s.record(ctx, s.store, actor, AuditAdjustmentsView, "", "status="+status); err != nil {
+		return nil, err
+	}
+	return s.store.Adjustments().List(ctx, status)
+}
+
+func (s *AdminService) pendingAdjustment(ctx context.Context, tx repository.Store, adjustmentID int64) (models.Adjustment, error) {
+	adjustment, err := tx.Adjustments().Get(ctx, adjustmentID)
+	if err != nil {
+		return models.Adjustment{}, err
+	}
+	if adjustment.Status != models.AdjustmentPending {
+		return models.Adjustment{}, ErrAdjustmentDecided
+	}
+	return adjustment, nil
+}
+
+// decide relies on the repository to refuse an adjustment decided by a
+// concurrent transaction, so it is never applied twice.
+func (s *AdminService) decide(
+	ctx context.Context,
+	tx repository.Store,
+	adjustment *models.Adjustment,
+	actor Actor,
+	status string,
+	entryID int64,
+) error {
+	now := time.Now()
+	err := tx.Adjustments().Decide(ctx, adjustment.ID, status, actor.ID, now, entryID)
+	if errors.Is(err, repository.ErrConflict) {
+		return ErrAdjustmentDecided
+	} else if err != nil {
+		return err
+	}
+	adjustment.Status = status
+	adjustment.DecidedBy = actor.ID
+	adjustment.DecidedAt = &now
+	adjustment.EntryID = entryID
+	return nil
+}
+
+func adjustmentReference(adjustmentID int64) string {
+	return "adjustment:" + strconv.FormatInt(adjustmentID, 10)
+}
+
+// adjustmentDescription is what the user sees in their history.
+func adjustmentDescription(adjustment models.Adjustment) string {
+	if adjustment.Comment == "" {
+		return adjustment.ReasonCode
+	}
+	return adjustment.ReasonCode + ": " + adjustment.Comment
+}
+
+func adjustmentDetails(adjustment models.Adjustment) string {
+	return fmt.Sprintf("%s %s %s", adjustmentReference(adjustment.ID), adjustment.Amount, adjustment.ReasonCode)
+}
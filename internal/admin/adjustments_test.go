@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+func TestAdjustmentNeedsAnotherAdmin(t *testing.T) {
+	ctx := context.Background()
+	service, store, _ := testService(t)
+	maker := newUser(t, store, "maker", models.RoleAdmin)
+	checker := newUser(t, store, "checker", models.RoleAdmin)
+	alice := newUser(t, store, "alice", models.RoleUser)
+
+	adjustment, err := service.ProposeAdjustment(ctx, maker, alice.ID, 500, models.ReasonGoodwill, "late delivery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adjustment.Status != models.AdjustmentPending {
+		t.Fatalf("got status %s, want %s", adjustment.Status, models.AdjustmentPending)
+	}
+	expectBalance(t, store, alice.ID, 0)
+
+	if _, err := service.ApproveAdjustment(ctx, maker, adjustment.ID); !errors.Is(err, ErrSelfApproval) {
+		t.Fatalf("proposer approved their own adjustment: %v", err)
+	}
+	expectBalance(t, store, alice.ID, 0)
+
+	approved, err := service.ApproveAdjustment(ctx, checker, adjustment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approved.Status != models.AdjustmentApproved || approved.DecidedBy != checker.ID || approved.EntryID == 0 {
+		t.Fatalf("unexpected approved adjustment %+v", approved)
+	}
+	expectBalance(t, store, alice.ID, 500)
+
+	// A decided adjustment is never applied twice nor withdrawn.
+	if _, err := service.ApproveAdjustment(ctx, checker, adjustment.ID); !errors.Is(err, ErrAdjustmentDecided) {
+		t.Fatalf("second approval: got %v, want %v", err, ErrAdjustmentDecided)
+	}
+	if _, err := service.RejectAdjustment(ctx, maker, adjustment.ID); !errors.Is(err, ErrAdjustmentDecided) {
+		t.Fatalf("rejection after approval: got %v, want %v", err, ErrAdjustmentDecided)
+	}
+	expectBalance(t, store, alice.ID, 500)
+
+	history, err := service.UserHistory(ctx, checker, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Kind != string(ledger.KindAdjustment) ||
+		history[0].Amount != 500 || history[0].Description != "GOODWILL: late delivery" {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	events, err := store.Audit().List(ctx, models.AuditFilter{Subject: userSubject(alice.ID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := map[string]int64{}
+	for _, event := range events {
+		actions[event.Action] = event.ActorID
+	}
+	if actions[AuditAdjustmentProposed] != maker.ID || actions[AuditAdjustmentApproved] != checker.ID {
+		t.Fatalf("unexpected audit trail %+v", events)
+	}
+}
+
+func TestRejectAdjustment(t *testing.T) {
+	ctx := context.Background()
+	service, store, _ := testService(t)
+	maker := newUser(t, store, "maker", models.RoleAdmin)
+	checker := newUser(t, store, "checker", models.RoleAdmin)
+	alice := newUser(t, store, "alice", models.RoleUser)
+
+	// The proposer may withdraw their own adjustment.
+	adjustment, err := service.ProposeAdjustment(ctx, maker, alice.ID, 500, models.ReasonCorrection, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected, err := service.RejectAdjustment(ctx, maker, adjustment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejected.Status != models.AdjustmentRejected || rejected.EntryID != 0 {
+		t.Fatalf("unexpected rejected adjustment %+v", rejected)
+	}
+	if _, err := service.ApproveAdjustment(ctx, checker, adjustment.ID); !errors.Is(err, ErrAdjustmentDecided) {
+		t.Fatalf("approval after rejection: got %v, want %v", err, ErrAdjustmentDecided)
+	}
+	expectBalance(t, store, alice.ID, 0)
+
+	pending, err := service.ListAdjustments(ctx, checker, models.AdjustmentPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("got pending adjustments %+v", pending)
+	}
+}
+
+func TestProposeInvalidAdjustment(t *testing.T) {
+	ctx := context.Background()
+	service, store, _ := testService(t)
+	maker := newUser(t, store, "maker", models.RoleAdmin)
+	alice := newUser(t, store, "alice", models.RoleUser)
+
+	if _, err := service.ProposeAdjustment(ctx, maker, alice.ID, 0, models.ReasonGoodwill, ""); !errors.Is(err, ErrInvalidAdjustment) {
+		t.Fatalf("zero amount: got %v, want %v", err, ErrInvalidAdjustment)
+	}
+	if _, err := service.ProposeAdjustment(ctx, maker, alice.ID, 500, "BIRTHDAY", ""); !errors.Is(err, ErrInvalidAdjustment) {
+		t.Fatalf("unknown reason code: got %v, want %v", err, ErrInvalidAdjustment)
+	}
+}
+
+func TestDebitAdjustment(t *testing.T) {
+	ctx := context.Background()
+	service, store, orderService := testService(t)
+	maker := newUser(t, store, "maker", models.RoleAdmin)
+	checker := newUser(t, store, "checker", models.RoleAdmin)
+	alice := newUser(t, store, "alice", models.RoleUser)
+	credit(t, store, orderService, alice.ID, "12345678903", 300)
+
+	tooMuch, err := service.ProposeAdjustment(ctx, maker, alice.ID, -500, models.ReasonFraud, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.ApproveAdjustment(ctx, checker, tooMuch.ID); !errors.Is(err, ErrNotEnoughPoints) {
+		t.Fatalf("debit below zero: got %v, want %v", err, ErrNotEnoughPoints)
+	}
+	expectBalance(t, store, alice.ID, 300)
+
+	// The refused debit stays pending and is taken from the lots once
+	// approved with debt allowed.
+	service.opts.AllowDebt = true
+	if _, err := service.ApproveAdjustment(ctx, checker, tooMuch.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectBalance(t, store, alice.ID, -200)
+	lots, err := store.Lots().ListActive(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lots) != 0 {
+		t.Fatalf("debited points are still in lots %+v", lots)
+	}
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/orders"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/repository/memory"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger()
+	os.Exit(m.Run())
+}
+
+// testService runs AdminService on memory storage. The accrual system is
+// never asked: orders are credited with credit.
+func testService(t *testing.T) (*AdminService, repository.Store, *orders.OrderService) {
+	t.Helper()
+	store := memory.New()
+	orderService := orders.NewOrderService(store, nil, orders.Options{HoldTTL: time.Minute})
+	return NewAdminService(store, orderService, Options{}), store, orderService
+}
+
+func newUser(t *testing.T, store repository.Store, login, role string) Actor {
+	t.Helper()
+	ctx := context.Background()
+	userID, err := store.Users().Create(ctx, login, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Balances().Create(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Users().SetRole(ctx, userID, role); err != nil {
+		t.Fatal(err)
+	}
+	return Actor{ID: userID, IP: "192.0.2.1"}
+}
+
+// credit uploads the order and processes it with the accrual.
+func credit(t *testing.T, store repository.Store, orderService *orders.OrderService, userID int64, number string, accrual models.Money) {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.Orders().Create(ctx, userID, number); err != nil {
+		t.Fatal(err)
+	}
+	err := orderService.UpdateOrderAccrual(ctx, number, models.AccrualInfo{
+		OrderID: number,
+		Status:  models.OrderStatusProcessed,
+		Accrual: accrual,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectBalance(t *testing.T, store repository.Store, userID int64, want models.Money) {
+	t.Helper()
+	ctx := context.Background()
+	current, err := store.Balances().Current(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, err := store.Balances().Balance(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != want || balance != want {
+		t.Fatalf("got balance %s and ledger %s, want %s", current, balance, want)
+	}
+}
@@ -0,0 +1,136 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/orders"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tracing"
+)
+
+var ErrInvalidCampaign = errors.New("invalid campaign")
+
+const (
+	AuditCampaignCreated = "admin.campaign.create"
+	AuditCampaignUpdated = "admin.campaign.update"
+	AuditCampaignsView   = "admin.campaign.view"
+)
+
+// CreateCampaign stores a campaign. It starts awarding bonuses once it is
+// active and its window opens.
+func (s *AdminService) CreateCampaign(ctx context.Context, actor Actor, campaign models.Campaign) (models.Campaign, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.CreateCampaign")
+	defer span.End()
+
+	if err := validateCampaign(campaign); err != nil {
+		return models.Campaign{}, err
+	}
+	campaign.Spent = 0
+	campaign.CreatedBy = actor.ID
+	campaign.CreatedAt = time.Now()
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		var err error
+		if campaign.ID, err = tx.Campaigns().Create(ctx, campaign); err != nil {
+			return err
+		}
+		return s.record(ctx, tx, actor, AuditCampaignCreated, orders.CampaignReference(campaign.ID), campaignDetails(campaign))
+	})
+	if err != nil {
+		tracing.Error(span, err)
+		return models.Campaign{}, err
+	}
+	logger.Sugar.Infof("Campaign %d %q created by %d", campaign.ID, campaign.Name, actor.ID)
+	return campaign, nil
+}
+
+// UpdateCampaign replaces the rules of a campaign. Bonuses already awarded
+// stay, and so does the spending counted against the budget. Setting
+// active to false stops the campaign.
+func (s *AdminService) UpdateCampaign(ctx context.Context, actor Actor, campaign models.Campaign) (models.Campaign, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.UpdateCampaign")
+	defer span.End()
+
+	if err := validateCampaign(campaign); err != nil {
+		return models.Campaign{}, err
+	}
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		if _, err := tx.Campaigns().GetForUpdate(ctx, campaign.ID); err != nil {
+			return err
+		}
+		if err := tx.Campaigns().Update(ctx, campaign); err != nil {
+			return err
+		}
+		var err error
+		if campaign, err = tx.Campaigns().Get(ctx, campaign.ID); err != nil {
+			return err
+		}
+		return s.record(ctx, tx, actor, AuditCampaignUpdated, orders.CampaignReference(campaign.ID), campaignDetails(campaign))
+	})
+	if err != nil {
+		tracing.Error(span, err)
+		return models.Campaign{}, err
+	}
+	return campaign, nil
+}
+
+func (s *AdminService) GetCampaign(ctx context.Context, actor Actor, campaignID int64) (models.Campaign, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.GetCampaign")
+	defer span.End()
+
+	campaign, err := s.store.Campaigns().Get(ctx, campaignID)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	if err := s.record(ctx, s.store, actor, AuditCampaignsView, orders.CampaignReference(campaignID), ""); err != nil {
+		return models.Campaign{}, err
+	}
+	return campaign, nil
+}
+
+func (s *AdminService) ListCampaigns(ctx context.Context, actor Actor) ([]models.Campaign, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.ListCampaigns")
+	defer span.End()
+
+	if err := s.record(ctx, s.store, actor, AuditCampaignsView, "", ""); err != nil {
+		return nil, err
+	}
+	return s.store.Campaigns().List(ctx)
+}
+
+// CampaignAwards lists bonuses credited by the campaign, newest first.
+func (s *AdminService) CampaignAwards(ctx context.Context, actor Actor, campaignID int64) ([]models.CampaignAward, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.CampaignAwards")
+	defer span.End()
+
+	if _, err := s.store.Campaigns().Get(ctx, campaignID); err != nil {
+		return nil, err
+	}
+	if err := s.record(ctx, s.store, actor, AuditCampaignsView, orders.CampaignReference(campaignID), "awards"); err != nil {
+		return nil, err
+	}
+	return s.store.Campaigns().Awards(ctx, campaignID)
+}
+
+func validateCampaign(c models.Campaign) error {
+	switch {
+	case c.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidCampaign)
+	case !c.EndsAt.After(c.StartsAt):
+		return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidCampaign)
+	case c.Fixed < 0 || c.Rate < 0 || (c.Fixed == 0 && c.Rate == 0):
+		return fmt.Errorf("%w: fixed or rate must be positive", ErrInvalidCampaign)
+	case c.MinAccrual < 0 || c.UserBudget < 0 || c.Budget < 0:
+		return fmt.Errorf("%w: min_accrual and budgets can not be negative", ErrInvalidCampaign)
+	}
+	return nil
+}
+
+func campaignDetails(c models.Campaign) string {
+	return fmt.Sprintf("%q active=%t %s..%s fixed=%s rate=%s budget=%s user_budget=%s",
+		c.Name, c.Active, c.StartsAt.Format(time.RFC3339), c.EndsAt.Format(time.RFC3339), c.Fixed, c.Rate, c.Budget, c.UserBudget)
+}
@@ -0,0 +1,177 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/orders"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+// running is a campaign that is open for a day either side of now.
+func running(name string) models.Campaign {
+	now := time.Now()
+	return models.Campaign{
+		Name:     name,
+		Active:   true,
+		StartsAt: now.Add(-24 * time.Hour),
+		EndsAt:   now.Add(24 * time.Hour),
+	}
+}
+
+func createCampaign(t *testing.T, service *AdminService, actor Actor, campaign models.Campaign) models.Campaign {
+	t.Helper()
+	campaign, err := service.CreateCampaign(context.Background(), actor, campaign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return campaign
+}
+
+func expectSpent(t *testing.T, store repository.Store, campaignID int64, want models.Money) {
+	t.Helper()
+	campaign, err := store.Campaigns().Get(context.Background(), campaignID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if campaign.Spent != want {
+		t.Fatalf("campaign %d spent %s, want %s", campaignID, campaign.Spent, want)
+	}
+}
+
+func TestCampaignAwardsBonus(t *testing.T) {
+	ctx := context.Background()
+	service, store, orderService := testService(t)
+	admin := newUser(t, store, "admin", models.RoleAdmin)
+	alice := newUser(t, store, "alice", models.RoleUser)
+	campaign := running("spring")
+	campaign.Fixed = 100
+	campaign.Rate = 1000
+	campaign = createCampaign(t, service, admin, campaign)
+
+	credit(t, store, orderService, alice.ID, "12345678903", 1005)
+	// 1.00 fixed plus 10% of 10.05, rounded half away from zero.
+	expectBalance(t, store, alice.ID, 1206)
+	expectSpent(t, store, campaign.ID, 201)
+
+	awards, err := service.CampaignAwards(ctx, admin, campaign.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(awards) != 1 || awards[0].UserID != alice.ID || awards[0].Order != "12345678903" || awards[0].Amount != 201 {
+		t.Fatalf("unexpected awards %+v", awards)
+	}
+	history, err := service.UserHistory(ctx, admin, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := history[len(history)-1]
+	if last.Kind != string(ledger.KindBonus) || last.Amount != 201 || last.Reference != orders.CampaignReference(campaign.ID) {
+		t.Fatalf("unexpected bonus entry %+v", last)
+	}
+	lots, err := store.Lots().ListActive(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lots) != 2 || lots[1].Amount != 201 {
+		t.Fatalf("bonus has no lot of its own: %+v", lots)
+	}
+}
+
+func TestCampaignConditions(t *testing.T) {
+	ctx := context.Background()
+	service, store, orderService := testService(t)
+	admin := newUser(t, store, "admin", models.RoleAdmin)
+	alice := newUser(t, store, "alice", models.RoleUser)
+
+	bigOrders := running("big orders")
+	bigOrders.Fixed = 100
+	bigOrders.MinAccrual = 1000
+	bigOrders = createCampaign(t, service, admin, bigOrders)
+	welcome := running("welcome")
+	welcome.Fixed = 50
+	welcome.FirstOrder = true
+	welcome = createCampaign(t, service, admin, welcome)
+	future := running("future")
+	future.Fixed = 1000
+	future.StartsAt = time.Now().Add(time.Hour)
+	future = createCampaign(t, service, admin, future)
+
+	// MinAccrual is exclusive.
+	credit(t, store, orderService, alice.ID, "12345678903", 1000)
+	expectBalance(t, store, alice.ID, 1050)
+	credit(t, store, orderService, alice.ID, "9278923470", 1001)
+	expectBalance(t, store, alice.ID, 2151)
+	expectSpent(t, store, bigOrders.ID, 100)
+	expectSpent(t, store, welcome.ID, 50)
+	expectSpent(t, store, future.ID, 0)
+
+	// A stopped campaign awards nothing more.
+	bigOrders.Active = false
+	if _, err := service.UpdateCampaign(ctx, admin, bigOrders); err != nil {
+		t.Fatal(err)
+	}
+	credit(t, store, orderService, alice.ID, "2377225624", 2000)
+	expectBalance(t, store, alice.ID, 4151)
+
+	// Accruals of withdrawal numbers are not uploaded orders and earn no
+	// bonus.
+	status := orderService.WithdrawRequest(ctx, alice.ID, "79927398713", 100, models.AccrualInfo{
+		OrderID: "79927398713",
+		Status:  models.OrderStatusProcessed,
+		Accrual: 5000,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", status, http.StatusOK)
+	}
+	expectBalance(t, store, alice.ID, 9051)
+	expectSpent(t, store, bigOrders.ID, 100)
+	expectSpent(t, store, welcome.ID, 50)
+}
+
+func TestCampaignBudgets(t *testing.T) {
+	service, store, orderService := testService(t)
+	admin := newUser(t, store, "admin", models.RoleAdmin)
+	alice := newUser(t, store, "alice", models.RoleUser)
+	bob := newUser(t, store, "bob", models.RoleUser)
+	campaign := running("capped")
+	campaign.Fixed = 300
+	campaign.UserBudget = 500
+	campaign.Budget = 700
+	campaign = createCampaign(t, service, admin, campaign)
+
+	credit(t, store, orderService, alice.ID, "12345678903", 100)
+	credit(t, store, orderService, alice.ID, "9278923470", 100)
+	credit(t, store, orderService, alice.ID, "2377225624", 100)
+	// 300 and then what is left of the user budget.
+	expectBalance(t, store, alice.ID, 800)
+
+	credit(t, store, orderService, bob.ID, "79927398713", 100)
+	// Only 200 of the whole budget is left.
+	expectBalance(t, store, bob.ID, 300)
+	expectSpent(t, store, campaign.ID, 700)
+}
+
+func TestCreateInvalidCampaign(t *testing.T) {
+	ctx := context.Background()
+	service, store, _ := testService(t)
+	admin := newUser(t, store, "admin", models.RoleAdmin)
+
+	noReward := running("nothing")
+	backwards := running("backwards")
+	backwards.Fixed = 100
+	backwards.StartsAt, backwards.EndsAt = backwards.EndsAt, backwards.StartsAt
+	negative := running("negative")
+	negative.Fixed = 100
+	negative.Budget = -1
+	for _, campaign := range []models.Campaign{noReward, backwards, negative, {Fixed: 100}} {
+		if _, err := service.CreateCampaign(ctx, admin, campaign); !errors.Is(err, ErrInvalidCampaign) {
+			t.Errorf("campaign %q: got %v, want %v", campaign.Name, err, ErrInvalidCampaign)
+		}
+	}
+}
@@ -0,0 +1,514 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thalq/gopher_mart/internal/constants"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+type AdminHandler struct {
+	service *AdminService
+}
+
+func NewAdminHandler(service *AdminService) *AdminHandler {
+	return &AdminHandler{service: service}
+}
+
+type lockRequest struct {
+	Reason string `json:"reason"`
+}
+
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+type adjustmentRequest struct {
+	UserID     int64        `json:"user_id"`
+	Amount     models.Money `json:"amount"`
+	ReasonCode string       `json:"reason_code"`
+	Comment    string       `json:"comment"`
+}
+
+func (h *AdminHandler) FindUser(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(r)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	login := r.URL.Query().Get("login")
+	if login == "" {
+		http.Error(w, "login is required", http.StatusBadRequest)
+		return
+	}
+	user, err := h.service.FindUser(r.Context(), actor, login)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	actor, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+	user, err := h.service.GetUser(r.Context(), actor, userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (h *AdminHandler) UserOrders(w http.ResponseWriter, r *http.Request) {
+	actor, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+	orders, err := h.service.UserOrders(r.Context(), actor, userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+func (h *AdminHandler) UserWithdrawals(w http.ResponseWriter, r *http.Request) {
+	actor, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+	withdrawals, err := h.service.UserWithdrawals(r.Context(), actor, userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, withdrawals)
+}
+
+func (h *AdminHandler) UserBalance(w http.ResponseWriter, r *http.Request) {
+	actor, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+	balance, err := h.service.UserBalance(r.Context(), actor, userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, balance)
+}
+
+func (h *AdminHandler) UserHistory(w http.ResponseWriter, r *http.Request) {
+	actor, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+	history, err := h.service.UserHistory(r.Context(), actor, userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (h *AdminHandler) LockUser(w http.ResponseWriter, r *http.Request) {
+	actor, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+	var request lockRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if request.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.LockUser(r.Context(), actor, userID, request.Reason); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *AdminHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	actor, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.UnlockUser(r.Context(), actor, userID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *AdminHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	actor, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+	var request roleRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if err := h.service.SetRole(r.Context(), actor, userID, request.Role); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *AdminHandler) RecheckOrder(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(r)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := h.service.RecheckOrder(r.Context(), actor, chi.URLParam(r, "number")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AdminHandler) AuditTrail(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(r)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	filter := models.AuditFilter{Subject: query.Get("subject")}
+	var err error
+	if v := query.Get("actor"); v != "" {
+		if filter.ActorID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "Invalid actor", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	events, err := h.service.AuditTrail(r.Context(), actor, filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (h *AdminHandler) ProposeAdjustment(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(r)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var request adjustmentRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	adjustment, err := h.service.ProposeAdjustment(r.Context(), actor, request.UserID, request.Amount, request.ReasonCode, request.Comment)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, adjustment)
+}
+
+func (h *AdminHandler) ListAdjustments(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(r)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	adjustments, err := h.service.ListAdjustments(r.Context(), actor, r.URL.Query().Get("status"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adjustments)
+}
+
+func (h *AdminHandler) GetAdjustment(w http.ResponseWriter, r *http.Request) {
+	actor, adjustmentID, ok := adjustmentRequestParams(w, r)
+	if !ok {
+		return
+	}
+	adjustment, err := h.service.GetAdjustment(r.Context(), actor, adjustmentID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adjustment)
+}
+
+func (h *AdminHandler) ApproveAdjustment(w http.ResponseWriter, r *http.Request) {
+	actor, adjustmentID, ok := adjustmentRequestParams(w, r)
+	if !ok {
+		return
+	}
+	adjustment, err := h.service.ApproveAdjustment(r.Context(), actor, adjustmentID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adjustment)
+}
+
+func (h *AdminHandler) RejectAdjustment(w http.ResponseWriter, r *http.Request) {
+	actor, adjustmentID, ok := adjustmentRequestParams(w, r)
+	if !ok {
+		return
+	}
+	adjustment, err := h.service.RejectAdjustment(r.Context(), actor, adjustmentID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adjustment)
+}
+
+type reversalRequest struct {
+	Sum    models.Money `json:"sum"`
+	Reason string       `json:"reason"`
+}
+
+func (h *AdminHandler) ReverseOrder(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(r)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var request reversalRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	order, err := h.service.ReverseAccrual(r.Context(), actor, chi.URLParam(r, "number"), request.Sum, request.Reason)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+func (h *AdminHandler) RefundWithdrawal(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(r)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var request reversalRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	withdrawal, err := h.service.RefundWithdrawal(r.Context(), actor, chi.URLParam(r, "number"), request.Sum, request.Reason)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, withdrawal)
+}
+
+type campaignRequest struct {
+	Name       string             `json:"name"`
+	Active     *bool              `json:"active"`
+	StartsAt   time.Time          `json:"starts_at"`
+	EndsAt     time.Time          `json:"ends_at"`
+	MinAccrual models.Money       `json:"min_accrual"`
+	FirstOrder bool               `json:"first_order"`
+	Fixed      models.Money       `json:"fixed"`
+	Rate       models.BasisPoints `json:"rate"`
+	UserBudget models.Money       `json:"user_budget"`
+	Budget     models.Money       `json:"budget"`
+}
+
+// campaign builds the campaign described by the request. A campaign is
+// active unless the request says otherwise.
+func (request campaignRequest) campaign(campaignID int64) models.Campaign {
+	active := request.Active == nil || *request.Active
+	return models.Campaign{
+		ID:         campaignID,
+		Name:       request.Name,
+		Active:     active,
+		StartsAt:   request.StartsAt,
+		EndsAt:     request.EndsAt,
+		MinAccrual: request.MinAccrual,
+		FirstOrder: request.FirstOrder,
+		Fixed:      request.Fixed,
+		Rate:       request.Rate,
+		UserBudget: request.UserBudget,
+		Budget:     request.Budget,
+	}
+}
+
+func (h *AdminHandler) CreateCampaign(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(r)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var request campaignRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	campaign, err := h.service.CreateCampaign(r.Context(), actor, request.campaign(0))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, campaign)
+}
+
+func (h *AdminHandler) ListCampaigns(w http.ResponseWriter, r *http.Request) {
+	actor, ok := actorFromRequest(r)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	campaigns, err := h.service.ListCampaigns(r.Context(), actor)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, campaigns)
+}
+
+func (h *AdminHandler) GetCampaign(w http.ResponseWriter, r *http.Request) {
+	actor, campaignID, ok := campaignRequestParams(w, r)
+	if !ok {
+		return
+	}
+	campaign, err := h.service.GetCampaign(r.Context(), actor, campaignID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, campaign)
+}
+
+func (h *AdminHandler) UpdateCampaign(w http.ResponseWriter, r *http.Request) {
+	actor, campaignID, ok := campaignRequestParams(w, r)
+	if !ok {
+		return
+	}
+	var request campaignRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	campaign, err := h.service.UpdateCampaign(r.Context(), actor, request.campaign(campaignID))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, campaign)
+}
+
+func (h *AdminHandler) CampaignAwards(w http.ResponseWriter, r *http.Request) {
+	actor, campaignID, ok := campaignRequestParams(w, r)
+	if !ok {
+		return
+	}
+	awards, err := h.service.CampaignAwards(r.Context(), actor, campaignID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, awards)
+}
+
+func actorFromRequest(r *http.Request) (Actor, bool) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int64)
+	return Actor{ID: userID, IP: logger.ClientIP(r)}, ok
+}
+
+// userRequest reads the actor and the {id} URL parameter, answering the
+// request itself when either is missing.
+func userRequest(w http.ResponseWriter, r *http.Request) (Actor, int64, bool) {
+	return idRequest(w, r, "Invalid user id")
+}
+
+func adjustmentRequestParams(w http.ResponseWriter, r *http.Request) (Actor, int64, bool) {
+	return idRequest(w, r, "Invalid adjustment id")
+}
+
+func campaignRequestParams(w http.ResponseWriter, r *http.Request) (Actor, int64, bool) {
+	return idRequest(w, r, "Invalid campaign id")
+}
+
+func idRequest(w http.ResponseWriter, r *http.Request, invalid string) (Actor, int64, bool) {
+	actor, ok := actorFromRequest(r)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return Actor{}, 0, false
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, invalid, http.StatusBadRequest)
+		return Actor{}, 0, false
+	}
+	return actor, id, true
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Не удалось прочитать тело запроса", http.StatusBadRequest)
+		return false
+	}
+	defer r.Body.Close()
+	if err := json.Unmarshal(body, v); err != nil {
+		http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrInvalidAdjustment), errors.Is(err, ErrInvalidReversal),
+		errors.Is(err, ErrInvalidCampaign):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrSelfApproval), errors.Is(err, ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrNotEnoughPoints):
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+	case errors.Is(err, ErrSelf), errors.Is(err, ErrAdjustmentDecided),
+		errors.Is(err, ErrNotProcessed), errors.Is(err, ErrNothingToReverse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, repository.ErrConflict):
+		http.Error(w, "Order is already processed", http.StatusConflict)
+	default:
+		logger.Sugar.Errorf("Admin request failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	response, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
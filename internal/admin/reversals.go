@@ -0,0 +1,235 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tracing"
+)
+
+var (
+	ErrInvalidReversal  = errors.New("invalid reversal")
+	ErrNotProcessed     = errors.New("order is not processed")
+	ErrNothingToReverse = errors.New("sum exceeds what is left to reverse")
+)
+
+const (
+	AuditOrderReversed    = "admin.order.reverse"
+	AuditWithdrawalRefund = "admin.withdrawal.refund"
+)
+
+// ReverseAccrual takes back sum of the points credited for a processed
+// order, for example after the goods were returned. A zero sum reverses
+// everything that is left. Campaign bonuses awarded for the order are
+// reversed in the same proportion; campaign budgets are not given back.
+// Held points can not be taken. The points come out of the lots of the
+// order first. The ledger entries and the order change in one transaction.
+func (s *AdminService) ReverseAccrual(
+	ctx context.Context,
+	actor Actor,
+	orderNumber string,
+	sum models.Money,
+	reason string,
+) (models.Order, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.ReverseAccrual")
+	defer span.End()
+
+	if sum < 0 || reason == "" {
+		return models.Order{}, ErrInvalidReversal
+	}
+	var order models.Order
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		var err error
+		if order, err = tx.Orders().Get(ctx, orderNumber); err != nil {
+			return err
+		}
+		if order.Status != models.OrderStatusProcessed {
+			return ErrNotProcessed
+		}
+		if sum, err = reversalSum(order.Accrual, order.Reversed, sum); err != nil {
+			return err
+		}
+		balance, err := tx.Balances().CurrentForUpdate(ctx, order.UserID)
+		if err != nil {
+			return err
+		}
+		bonuses, bonus, err := bonusReversals(ctx, tx, order, sum, reason)
+		if err != nil {
+			return err
+		}
+		held, err := tx.Holds().Held(ctx, order.UserID, time.Now())
+		if err != nil {
+			return err
+		}
+		if balance-held < sum+bonus && !s.opts.AllowDebt {
+			return ErrNotEnoughPoints
+		}
+		original, err := originalEntry(ctx, tx, order.UserID, ledger.KindAccrual, orderNumber)
+		if err != nil {
+			return err
+		}
+		if original.ID == 0 {
+			original = ledger.Accrual(order.UserID, orderNumber, order.Accrual)
+		}
+		if _, err := tx.Balances().Post(ctx, ledger.PartialReversal(original, sum, reason)); err != nil {
+			return err
+		}
+		for _, entry := range bonuses {
+			if _, err := tx.Balances().Post(ctx, entry); err != nil {
+				return err
+			}
+		}
+		if err := tx.Orders().Reverse(ctx, orderNumber, sum); err != nil {
+			return err
+		}
+		if err := tx.Lots().Consume(ctx, order.UserID, orderNumber, sum+bonus); err != nil {
+			return err
+		}
+		order.Reversed += sum
+		order.ReversalStatus = models.ReversalStatus(order.Accrual, order.Reversed)
+		return s.record(ctx, tx, actor, AuditOrderReversed, userSubject(order.UserID), reversalDetails(orderNumber, sum, reason))
+	})
+	if errors.Is(err, repository.ErrConflict) {
+		err = ErrNothingToReverse
+	}
+	if err != nil {
+		tracing.Error(span, err)
+		return models.Order{}, err
+	}
+	logger.Sugar.Infof("Accrual of order %s reversed by %s by %d", orderNumber, sum, actor.ID)
+	return order, nil
+}
+
+// RefundWithdrawal gives back sum of the points spent on an order, for
+// example when it was cancelled. A zero sum refunds everything that is
+// left.
+func (s *AdminService) RefundWithdrawal(
+	ctx context.Context,
+	actor Actor,
+	orderNumber string,
+	sum models.Money,
+	reason string,
+) (models.WithdrawResponse, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.RefundWithdrawal")
+	defer span.End()
+
+	if sum < 0 || reason == "" {
+		return models.WithdrawResponse{}, ErrInvalidReversal
+	}
+	var withdrawal models.WithdrawResponse
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		var err error
+		if withdrawal, err = tx.Withdrawals().Get(ctx, orderNumber); err != nil {
+			return err
+		}
+		if sum, err = reversalSum(withdrawal.Sum, withdrawal.Refunded, sum); err != nil {
+			return err
+		}
+		if _, err := tx.Balances().CurrentForUpdate(ctx, withdrawal.UserID); err != nil {
+			return err
+		}
+		original, err := originalEntry(ctx, tx, withdrawal.UserID, ledger.KindWithdrawal, orderNumber)
+		if err != nil {
+			return err
+		}
+		if original.ID == 0 {
+			original = ledger.Withdrawal(withdrawal.UserID, orderNumber, withdrawal.Sum)
+		}
+		if _, err := tx.Balances().Post(ctx, ledger.PartialReversal(original, sum, reason)); err != nil {
+			return err
+		}
+		if err := tx.Withdrawals().Refund(ctx, orderNumber, sum); err != nil {
+			return err
+		}
+		withdrawal.Refunded += sum
+		withdrawal.RefundStatus = models.ReversalStatus(withdrawal.Sum, withdrawal.Refunded)
+		return s.record(ctx, tx, actor, AuditWithdrawalRefund, userSubject(withdrawal.UserID), reversalDetails(orderNumber, sum, reason))
+	})
+	if errors.Is(err, repository.ErrConflict) {
+		err = ErrNothingToReverse
+	}
+	if err != nil {
+		tracing.Error(span, err)
+		return models.WithdrawResponse{}, err
+	}
+	logger.Sugar.Infof("Withdrawal %s refunded by %s by %d", orderNumber, sum, actor.ID)
+	return withdrawal, nil
+}
+
+// reversalSum checks the requested sum against what is left of total.
+func reversalSum(total, reversed, sum models.Money) (models.Money, error) {
+	left := total - reversed
+	if sum == 0 {
+		sum = left
+	}
+	if sum <= 0 || sum > left {
+		return 0, ErrNothingToReverse
+	}
+	return sum, nil
+}
+
+// originalEntry finds the entry of the kind posted for the order. Orders
+// credited before the ledger existed have none: the zero entry is returned
+// and the reversal is posted without a link.
+func originalEntry(ctx context.Context, tx repository.Store, userID int64, kind ledger.EntryKind, orderNumber string) (ledger.Entry, error) {
+	entries, err := tx.Balances().Entries(ctx, userID)
+	if err != nil {
+		return ledger.Entry{}, err
+	}
+	for _, e := range entries {
+		if e.Kind == kind && e.Reference == orderNumber {
+			return e, nil
+		}
+	}
+	return ledger.Entry{}, nil
+}
+
+// bonusReversals builds the reversals of the campaign bonuses of the order
+// for reversing sum of its accrual, and returns their total. The reversed
+// share of each bonus follows the reversed share of the accrual and is
+// rounded as a whole, so partial reversals add up to the full bonus.
+func bonusReversals(ctx context.Context, tx repository.Store, order models.Order, sum models.Money, reason string) ([]ledger.Entry, models.Money, error) {
+	awards, err := tx.Campaigns().OrderAwards(ctx, order.Number)
+	if err != nil || len(awards) == 0 {
+		return nil, 0, err
+	}
+	entries, err := tx.Balances().Entries(ctx, order.UserID)
+	if err != nil {
+		return nil, 0, err
+	}
+	byID := make(map[int64]ledger.Entry, len(entries))
+	for _, e := range entries {
+		byID[e.ID] = e
+	}
+
+	var reversals []ledger.Entry
+	var total models.Money
+	for _, a := range awards {
+		amount := proRata(a.Amount, order.Reversed+sum, order.Accrual) - proRata(a.Amount, order.Reversed, order.Accrual)
+		if amount <= 0 {
+			continue
+		}
+		original, ok := byID[a.EntryID]
+		if !ok {
+			return nil, 0, fmt.Errorf("bonus entry %d of order %s not found", a.EntryID, order.Number)
+		}
+		reversals = append(reversals, ledger.PartialReversal(original, amount, reason))
+		total += amount
+	}
+	return reversals, total, nil
+}
+
+// proRata returns the part/whole share of amount, rounded half up.
+func proRata(amount, part, whole models.Money) models.Money {
+	return (2*amount*part + whole) / (2 * whole)
+}
+
+func reversalDetails(orderNumber string, sum models.Money, reason string) string {
+	return fmt.Sprintf("order:%s %s %s", orderNumber, sum, reason)
+}
@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+func TestReverseAccrualReversesBonuses(t *testing.T) {
+	ctx := context.Background()
+	service, store, orderService := testService(t)
+	admin := newUser(t, store, "admin", models.RoleAdmin)
+	alice := newUser(t, store, "alice", models.RoleUser)
+
+	now := time.Now()
+	_, err := service.CreateCampaign(ctx, admin, models.Campaign{
+		Name:     "spring",
+		Active:   true,
+		StartsAt: now.Add(-time.Hour),
+		EndsAt:   now.Add(time.Hour),
+		Fixed:    1000,
+		Rate:     1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 100 accrued and a bonus of 10 fixed plus 10%.
+	credit(t, store, orderService, alice.ID, "12345678903", 10000)
+	expectBalance(t, store, alice.ID, 12000)
+
+	// A quarter of the accrual takes a quarter of the bonus.
+	order, err := service.ReverseAccrual(ctx, admin, "12345678903", 2500, "returned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Reversed != 2500 || order.ReversalStatus != models.ReversalPartial {
+		t.Fatalf("got order %+v, want 25 reversed partially", order)
+	}
+	expectBalance(t, store, alice.ID, 9000)
+
+	// Reversing the rest takes the rest of the bonus.
+	if _, err := service.ReverseAccrual(ctx, admin, "12345678903", 0, "returned"); err != nil {
+		t.Fatal(err)
+	}
+	expectBalance(t, store, alice.ID, 0)
+	if _, err := service.ReverseAccrual(ctx, admin, "12345678903", 0, "returned"); !errors.Is(err, ErrNothingToReverse) {
+		t.Fatalf("got %v, want ErrNothingToReverse", err)
+	}
+}
+
+func TestReverseAccrualKeepsHeldPoints(t *testing.T) {
+	ctx := context.Background()
+	service, store, orderService := testService(t)
+	admin := newUser(t, store, "admin", models.RoleAdmin)
+	alice := newUser(t, store, "alice", models.RoleUser)
+
+	credit(t, store, orderService, alice.ID, "12345678903", 10000)
+	if _, err := orderService.AuthorizeHold(ctx, alice.ID, "2377225624", 8000); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.ReverseAccrual(ctx, admin, "12345678903", 3000, "returned"); !errors.Is(err, ErrNotEnoughPoints) {
+		t.Fatalf("got %v, want ErrNotEnoughPoints", err)
+	}
+	expectBalance(t, store, alice.ID, 10000)
+	if _, err := service.ReverseAccrual(ctx, admin, "12345678903", 2000, "returned"); err != nil {
+		t.Fatal(err)
+	}
+	expectBalance(t, store, alice.ID, 8000)
+}
@@ -0,0 +1,326 @@
+// Package admin is the support and admin surface. Every call, including
+// reads, is recorded in the audit trail with the staff member who made it.
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/orders"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tracing"
+)
+
+var (
+	ErrInvalidRole = errors.New("invalid role")
+	ErrSelf        = errors.New("staff can not change their own account")
+	ErrForbidden   = errors.New("staff can not manage a more privileged account")
+)
+
+const (
+	AuditUserLookup      = "admin.user.lookup"
+	AuditUserView        = "admin.user.view"
+	AuditUserOrders      = "admin.user.orders"
+	AuditUserWithdrawals = "admin.user.withdrawals"
+	AuditUserBalance     = "admin.user.balance"
+	AuditUserHistory     = "admin.user.history"
+	AuditAccountLocked   = "admin.account.lock"
+	AuditAccountUnlocked = "admin.account.unlock"
+	AuditRoleChanged     = "admin.role.change"
+	AuditRoleBootstrap   = "role.bootstrap"
+	AuditOrderRecheck    = "admin.order.recheck"
+	AuditTrailView       = "admin.audit.view"
+
+	revokeReasonLocked = "account locked"
+	revokeReasonRole   = "role changed"
+)
+
+// Actor is the staff member performing an action.
+type Actor struct {
+	ID int64
+	IP string
+}
+
+type UserView struct {
+	ID          int64      `json:"id"`
+	Login       string     `json:"login"`
+	Role        string     `json:"role"`
+	TOTPEnabled bool       `json:"totp_enabled"`
+	Locked      bool       `json:"locked"`
+	LockedAt    *time.Time `json:"locked_at,omitempty"`
+	LockReason  string     `json:"lock_reason,omitempty"`
+}
+
+func newUserView(user models.User) UserView {
+	view := UserView{
+		ID:          user.ID,
+		Login:       user.Login,
+		Role:        user.Role,
+		TOTPEnabled: user.TOTPEnabled,
+		Locked:      user.Locked(),
+		LockReason:  user.LockReason,
+	}
+	if user.Locked() {
+		lockedAt := user.LockedAt
+		view.LockedAt = &lockedAt
+	}
+	return view
+}
+
+type Options struct {
+	// AllowDebt lets approved debits and accrual reversals take a balance
+	// below zero. Otherwise they are refused.
+	AllowDebt bool
+}
+
+type AdminService struct {
+	store  repository.Store
+	orders *orders.OrderService
+	opts   Options
+}
+
+func NewAdminService(store repository.Store, orderService *orders.OrderService, opts Options) *AdminService {
+	return &AdminService{store: store, orders: orderService, opts: opts}
+}
+
+func (s *AdminService) FindUser(ctx context.Context, actor Actor, login string) (UserView, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.FindUser")
+	defer span.End()
+
+	if err := s.record(ctx, s.store, actor, AuditUserLookup, "login:"+login, ""); err != nil {
+		return UserView{}, err
+	}
+	user, err := s.store.Users().GetByLogin(ctx, login)
+	if err != nil {
+		return UserView{}, err
+	}
+	return newUserView(user), nil
+}
+
+func (s *AdminService) GetUser(ctx context.Context, actor Actor, userID int64) (UserView, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.GetUser")
+	defer span.End()
+
+	user, err := s.store.Users().GetByID(ctx, userID)
+	if err != nil {
+		return UserView{}, err
+	}
+	if err := s.record(ctx, s.store, actor, AuditUserView, userSubject(userID), ""); err != nil {
+		return UserView{}, err
+	}
+	return newUserView(user), nil
+}
+
+func (s *AdminService) UserOrders(ctx context.Context, actor Actor, userID int64) ([]models.Order, error) {
+	if err := s.userAction(ctx, actor, userID, AuditUserOrders); err != nil {
+		return nil, err
+	}
+	return s.orders.GetOrders(ctx, userID)
+}
+
+func (s *AdminService) UserWithdrawals(ctx context.Context, actor Actor, userID int64) ([]models.WithdrawResponse, error) {
+	if err := s.userAction(ctx, actor, userID, AuditUserWithdrawals); err != nil {
+		return nil, err
+	}
+	return s.orders.GetUserWithdrawls(ctx, userID)
+}
+
+func (s *AdminService) UserBalance(ctx context.Context, actor Actor, userID int64) (models.Balance, error) {
+	if err := s.userAction(ctx, actor, userID, AuditUserBalance); err != nil {
+		return models.Balance{}, err
+	}
+	return s.orders.GetBalance(ctx, userID)
+}
+
+func (s *AdminService) UserHistory(ctx context.Context, actor Actor, userID int64) ([]models.HistoryItem, error) {
+	if err := s.userAction(ctx, actor, userID, AuditUserHistory); err != nil {
+		return nil, err
+	}
+	return s.orders.GetHistory(ctx, userID)
+}
+
+// LockUser blocks login and revokes every session, so the user is logged
+// out at once. Staff can not lock accounts with a higher role than theirs.
+func (s *AdminService) LockUser(ctx context.Context, actor Actor, userID int64, reason string) error {
+	ctx, span := tracing.Start(ctx, "AdminService.LockUser")
+	defer span.End()
+
+	if userID == actor.ID {
+		return ErrSelf
+	}
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		if err := s.checkRank(ctx, tx, actor, userID); err != nil {
+			return err
+		}
+		if err := tx.Users().Lock(ctx, userID, reason, time.Now()); err != nil {
+			return err
+		}
+		if err := tx.Sessions().RevokeUser(ctx, userID, "", revokeReasonLocked); err != nil {
+			return err
+		}
+		return s.record(ctx, tx, actor, AuditAccountLocked, userSubject(userID), reason)
+	})
+	if err == nil {
+		logger.Sugar.Warnf("User %d locked by %d: %s", userID, actor.ID, reason)
+	}
+	tracing.Error(span, err)
+	return err
+}
+
+// UnlockUser lets a locked user log in again, with the same rule as
+// LockUser.
+func (s *AdminService) UnlockUser(ctx context.Context, actor Actor, userID int64) error {
+	ctx, span := tracing.Start(ctx, "AdminService.UnlockUser")
+	defer span.End()
+
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		if err := s.checkRank(ctx, tx, actor, userID); err != nil {
+			return err
+		}
+		if err := tx.Users().Unlock(ctx, userID); err != nil {
+			return err
+		}
+		return s.record(ctx, tx, actor, AuditAccountUnlocked, userSubject(userID), "")
+	})
+	tracing.Error(span, err)
+	return err
+}
+
+// SetRole revokes the user's sessions: the role is part of access tokens
+// and must not outlive the change.
+func (s *AdminService) SetRole(ctx context.Context, actor Actor, userID int64, role string) error {
+	ctx, span := tracing.Start(ctx, "AdminService.SetRole")
+	defer span.End()
+
+	if !models.ValidRole(role) {
+		return ErrInvalidRole
+	}
+	if userID == actor.ID {
+		return ErrSelf
+	}
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		user, err := tx.Users().GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Users().SetRole(ctx, userID, role); err != nil {
+			return err
+		}
+		if err := tx.Sessions().RevokeUser(ctx, userID, "", revokeReasonRole); err != nil {
+			return err
+		}
+		return s.record(ctx, tx, actor, AuditRoleChanged, userSubject(userID), fmt.Sprintf("%s -> %s", user.Role, role))
+	})
+	tracing.Error(span, err)
+	return err
+}
+
+// BootstrapAdmin grants the admin role to an existing user, so a fresh
+// deployment has someone who can assign roles. It is run by an operator
+// from the command line, so the audit event has no actor.
+func (s *AdminService) BootstrapAdmin(ctx context.Context, userID int64) error {
+	ctx, span := tracing.Start(ctx, "AdminService.BootstrapAdmin")
+	defer span.End()
+
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		user, err := tx.Users().GetByID(ctx, userID)
+		if err != nil || user.Role == models.RoleAdmin {
+			return err
+		}
+		if err := tx.Users().SetRole(ctx, userID, models.RoleAdmin); err != nil {
+			return err
+		}
+		if err := tx.Sessions().RevokeUser(ctx, userID, "", revokeReasonRole); err != nil {
+			return err
+		}
+		logger.Sugar.Warnf("User %s granted admin role from the command line", user.Login)
+		return s.record(ctx, tx, Actor{}, AuditRoleBootstrap, userSubject(userID), fmt.Sprintf("%s -> %s", user.Role, models.RoleAdmin))
+	})
+	tracing.Error(span, err)
+	return err
+}
+
+// RecheckOrder makes the accrual worker ask the accrual system about the
+// order again. Processed orders are final: they have been credited.
+func (s *AdminService) RecheckOrder(ctx context.Context, actor Actor, orderNumber string) error {
+	ctx, span := tracing.Start(ctx, "AdminService.RecheckOrder")
+	defer span.End()
+
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		userID, err := tx.Orders().Requeue(ctx, orderNumber)
+		if err != nil {
+			return err
+		}
+		return s.record(ctx, tx, actor, AuditOrderRecheck, "order:"+orderNumber, userSubject(userID))
+	})
+	tracing.Error(span, err)
+	return err
+}
+
+func (s *AdminService) AuditTrail(ctx context.Context, actor Actor, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	ctx, span := tracing.Start(ctx, "AdminService.AuditTrail")
+	defer span.End()
+
+	details := fmt.Sprintf("subject=%s actor=%d limit=%d", filter.Subject, filter.ActorID, filter.Limit)
+	if err := s.record(ctx, s.store, actor, AuditTrailView, "", details); err != nil {
+		return nil, err
+	}
+	return s.store.Audit().List(ctx, filter)
+}
+
+func (s *AdminService) userAction(ctx context.Context, actor Actor, userID int64, action string) error {
+	if _, err := s.store.Users().GetByID(ctx, userID); err != nil {
+		return err
+	}
+	return s.record(ctx, s.store, actor, action, userSubject(userID), "")
+}
+
+// checkRank refuses actions of the actor on users with a higher role. The
+// roles are read from storage, so a role changed since the actor's token
+// was issued already counts.
+func (s *AdminService) checkRank(ctx context.Context, tx repository.Store, actor Actor, userID int64) error {
+	staff, err := tx.Users().GetByID(ctx, actor.ID)
+	if err != nil {
+		return err
+	}
+	user, err := tx.Users().GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if roleRank(user.Role) > roleRank(staff.Role) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// roleRank orders roles by privilege, unknown roles rank lowest.
+func roleRank(role string) int {
+	switch role {
+	case models.RoleAdmin:
+		return 2
+	case models.RoleSupport:
+		return 1
+	}
+	return 0
+}
+
+// record fails the action when it can not be audited.
+func (s *AdminService) record(ctx context.Context, store repository.Store, actor Actor, action, subject, details string) error {
+	return store.Audit().Record(ctx, models.AuditEvent{
+		CreatedAt: time.Now(),
+		ActorID:   actor.ID,
+		Action:    action,
+		Subject:   subject,
+		IP:        actor.IP,
+		Details:   details,
+	})
+}
+
+func userSubject(userID int64) string {
+	return ledger.UserAccount(userID)
+}
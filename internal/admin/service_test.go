@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+func TestLockUserRespectsRoles(t *testing.T) {
+	ctx := context.Background()
+	service, store, _ := testService(t)
+	admin := newUser(t, store, "admin", models.RoleAdmin)
+	support := newUser(t, store, "support", models.RoleSupport)
+	peer := newUser(t, store, "peer", models.RoleSupport)
+	alice := newUser(t, store, "alice", models.RoleUser)
+
+	if err := service.LockUser(ctx, support, admin.ID, "takeover"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("support locked an admin: %v", err)
+	}
+	if err := service.LockUser(ctx, support, support.ID, "oops"); !errors.Is(err, ErrSelf) {
+		t.Fatalf("support locked themselves: %v", err)
+	}
+	for _, target := range []Actor{alice, peer} {
+		if err := service.LockUser(ctx, support, target.ID, "fraud"); err != nil {
+			t.Fatal(err)
+		}
+		if err := service.UnlockUser(ctx, support, target.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := service.LockUser(ctx, admin, support.ID, "left the team"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.UnlockUser(ctx, peer, support.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.LockUser(ctx, admin, support.ID, "left the team"); err != nil {
+		t.Fatal(err)
+	}
+	// An admin locked by mistake can only be unlocked by another admin.
+	if err := service.SetRole(ctx, admin, support.ID, models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.UnlockUser(ctx, peer, support.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("support unlocked an admin: %v", err)
+	}
+	user, err := store.Users().GetByID(ctx, support.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Locked() {
+		t.Fatal("admin was unlocked by support")
+	}
+}
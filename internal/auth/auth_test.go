@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/notify"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/repository/memory"
+	"github.com/thalq/gopher_mart/internal/tokens"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger()
+	os.Exit(m.Run())
+}
+
+// testService runs AuthService on memory storage. Three failures lock a
+// login, five a client IP; backoff is off unless opts asks for it.
+func testService(t *testing.T, opts Options) (*AuthService, repository.Store) {
+	t.Helper()
+	keys, err := tokens.LoadKeySet(tokens.KeyConfig{Secret: "test-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.AccessTokenTTL = time.Minute
+	opts.RefreshTokenTTL = time.Hour
+	opts.MaxLoginFailures = 3
+	opts.MaxIPLoginFailures = 5
+	opts.LoginLockout = time.Hour
+	opts.PasswordResetTTL = time.Minute
+	opts.Notifier = notify.LogNotifier{}
+	store := memory.New()
+	return NewAuthService(store, keys, opts), store
+}
+
+func newUser(t *testing.T, service *AuthService, store repository.Store, login, password string) int64 {
+	t.Helper()
+	hash, err := service.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := store.Users().Create(context.Background(), login, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
@@ -1,13 +1,19 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/thalq/gopher_mart/internal/constants"
 	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
 )
 
 type AuthRequest struct {
@@ -15,6 +21,32 @@ type AuthRequest struct {
 	Password string `json:"password"`
 }
 
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ResetRequest struct {
+	Login string `json:"login"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type TwoFactorRequest struct {
+	Code string `json:"code"`
+	// Challenge is set when completing a login.
+	Challenge string `json:"challenge,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+const refreshCookie = "RefreshToken"
+
 type AuthHandler struct {
 	service *AuthService
 }
@@ -42,7 +74,6 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	logger.Sugar.Infof("Got request: %s", string(body))
 	if err := json.Unmarshal(body, &req); err != nil {
 		http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
 		return
@@ -51,7 +82,11 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if userExists, err := h.service.CheckUserExists(req.Login); err != nil {
+	if err := h.service.CheckPassword(req.Login, req.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if userExists, err := h.service.CheckUserExists(r.Context(), req.Login); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	} else if userExists {
@@ -59,27 +94,25 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := h.service.Register(req.Login, req.Password)
+	userID, err := h.service.Register(r.Context(), req.Login, req.Password)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	logger.Sugar.Infof("User %s registered", req.Login)
 
-	if err := h.service.CreateUserBalance(userID); err != nil {
+	if err := h.service.CreateUserBalance(r.Context(), userID); err != nil {
 		http.Error(w, "Failed to create user balance account", http.StatusInternalServerError)
 		return
 	}
 	logger.Sugar.Infof("User balance account created for user %s", req.Login)
 
-	token := h.service.GenerateToken(userID)
-	http.SetCookie(w, &http.Cookie{
-		Name:    "Authorization",
-		Value:   token,
-		Expires: time.Now().Add(time.Hour * 24),
-		Path:    "/",
-	})
-	w.WriteHeader(http.StatusOK)
+	tokens, err := h.service.IssueTokens(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to issue tokens", http.StatusInternalServerError)
+		return
+	}
+	h.writeTokens(w, tokens)
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -91,7 +124,6 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	logger.Sugar.Infof("Got request: %s", string(body))
 	if err := json.Unmarshal(body, &req); err != nil {
 		http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
 		return
@@ -101,24 +133,395 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	autheticated, userID, err := h.service.Authenticate(req.Login, req.Password)
+	ip := logger.ClientIP(r)
+	wait, err := h.service.BeginLogin(r.Context(), req.Login, ip)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if wait > 0 {
+		writeThrottled(w, wait)
+		return
+	}
+
+	// Only a wrong password keeps the attempt counted as a failure; every
+	// other exit settles it.
+	autheticated, userID, err := h.service.Authenticate(r.Context(), req.Login, req.Password)
+	if errors.Is(err, ErrAccountLocked) {
+		h.releaseLogin(r.Context(), req.Login, ip)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		h.releaseLogin(r.Context(), req.Login, ip)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	if !autheticated {
+		if err := h.service.LoginFailed(r.Context(), req.Login, ip); err != nil {
+			logger.Sugar.Errorf("Failed to record login failure: %s", err)
+		}
 		http.Error(w, "Invalid login or password", http.StatusUnauthorized)
 		return
 	}
+
+	// With two-factor authentication the password alone is not enough:
+	// failures are reset only after the second factor, so codes can not
+	// be guessed by logging in again between attempts.
+	twoFactor, err := h.service.TwoFactorRequired(r.Context(), userID)
+	if err != nil {
+		h.releaseLogin(r.Context(), req.Login, ip)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if twoFactor {
+		h.releaseLogin(r.Context(), req.Login, ip)
+		challenge, err := h.service.Challenge(userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, challenge)
+		return
+	}
+
+	if err := h.service.LoginSucceeded(r.Context(), req.Login, ip); err != nil {
+		logger.Sugar.Errorf("Failed to reset login failures: %s", err)
+	}
 	logger.Sugar.Infof("User %s authenticated", req.Login)
 
-	token := h.service.GenerateToken(userID)
+	tokens, err := h.service.IssueTokens(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to issue tokens", http.StatusInternalServerError)
+		return
+	}
+	h.writeTokens(w, tokens)
+}
+
+// releaseLogin takes back an attempt that did not fail on the password.
+func (h *AuthHandler) releaseLogin(ctx context.Context, login, ip string) {
+	if err := h.service.LoginPassed(ctx, login, ip); err != nil {
+		logger.Sugar.Errorf("Failed to settle login attempt: %s", err)
+	}
+}
+
+// Refresh takes the refresh token from the JSON body or, for browsers,
+// from the cookie set on login.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Не удалось прочитать тело запроса", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.RefreshToken == "" {
+		if cookie, err := r.Cookie(refreshCookie); err == nil {
+			req.RefreshToken = cookie.Value
+		}
+	}
+	if req.RefreshToken == "" {
+		http.Error(w, "refresh token is empty", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.service.Refresh(r.Context(), req.RefreshToken)
+	if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+		h.clearTokenCookies(w)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	} else if errors.Is(err, ErrAccountLocked) {
+		h.clearTokenCookies(w)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeTokens(w, tokens)
+}
+
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := r.Context().Value(constants.SessionIDKey).(string)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := h.service.Logout(r.Context(), sessionID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.clearTokenCookies(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *AuthHandler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	enrollment, err := h.service.EnrollTOTP(r.Context(), userID)
+	if errors.Is(err, ErrTwoFactorEnabled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, enrollment)
+}
+
+// VerifyTwoFactor confirms enrollment for an authenticated user or, with
+// a challenge from login, completes the login.
+func (h *AuthHandler) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if req.Code == "" {
+		http.Error(w, "code is empty", http.StatusBadRequest)
+		return
+	}
+	if req.Challenge != "" {
+		h.completeLogin(w, r, req)
+		return
+	}
+
+	userID, ok := r.Context().Value(constants.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	codes, err := h.service.ConfirmTOTP(r.Context(), userID, req.Code, logger.ClientIP(r))
+	var throttled *ThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		writeThrottled(w, throttled.Wait)
+	case errors.Is(err, ErrInvalidCode):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrTwoFactorEnabled), errors.Is(err, ErrTwoFactorNotPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+	}
+}
+
+func (h *AuthHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	err := h.service.DisableTOTP(r.Context(), userID, req.Code, logger.ClientIP(r))
+	var throttled *ThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		writeThrottled(w, throttled.Wait)
+	case errors.Is(err, ErrInvalidCode):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrTwoFactorNotEnabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// completeLogin is throttled like Login: wrong codes count as failed
+// logins of the user.
+func (h *AuthHandler) completeLogin(w http.ResponseWriter, r *http.Request, req TwoFactorRequest) {
+	user, err := h.service.ParseChallenge(r.Context(), req.Challenge)
+	if errors.Is(err, ErrInvalidChallenge) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ip := logger.ClientIP(r)
+	wait, err := h.service.BeginLogin(r.Context(), user.Login, ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		writeThrottled(w, wait)
+		return
+	}
+
+	ok, err := h.service.VerifySecondFactor(r.Context(), user, req.Code)
+	if err != nil {
+		h.releaseLogin(r.Context(), user.Login, ip)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		if err := h.service.LoginFailed(r.Context(), user.Login, ip); err != nil {
+			logger.Sugar.Errorf("Failed to record login failure: %s", err)
+		}
+		http.Error(w, ErrInvalidCode.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := h.service.LoginSucceeded(r.Context(), user.Login, ip); err != nil {
+		logger.Sugar.Errorf("Failed to reset login failures: %s", err)
+	}
+	logger.Sugar.Infof("User %s authenticated with second factor", user.Login)
+
+	tokens, err := h.service.IssueTokens(r.Context(), user.ID)
+	if errors.Is(err, ErrAccountLocked) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to issue tokens", http.StatusInternalServerError)
+		return
+	}
+	h.writeTokens(w, tokens)
+}
+
+// writeTokens returns the tokens in every form clients use: cookies for
+// browsers, the Authorization header and the JSON body for API clients.
+func (h *AuthHandler) writeTokens(w http.ResponseWriter, tokens models.TokenResponse) {
+	h.setTokenCookies(w, tokens)
+	w.Header().Set("Authorization", "Bearer "+tokens.AccessToken)
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	response, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(constants.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sessionID, _ := r.Context().Value(constants.SessionIDKey).(string)
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		http.Error(w, "current_password and new_password are required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.ChangePassword(r.Context(), userID, sessionID, req.CurrentPassword, req.NewPassword, logger.ClientIP(r))
+	var throttled *ThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		writeThrottled(w, throttled.Wait)
+	case errors.Is(err, ErrWrongPassword):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrWeakPassword):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// RequestPasswordReset answers 202 whether the login exists or not, and
+// 429 when too many resets were requested.
+func (h *AuthHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req ResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if req.Login == "" {
+		http.Error(w, "login is empty", http.StatusBadRequest)
+		return
+	}
+	err := h.service.RequestPasswordReset(r.Context(), req.Login, logger.ClientIP(r))
+	var throttled *ThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		writeThrottled(w, throttled.Wait)
+	case err != nil:
+		http.Error(w, "Failed to request reset token", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Не удалось распарсить JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if req.Token == "" || req.NewPassword == "" {
+		http.Error(w, "token and new_password are required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.ResetPassword(r.Context(), req.Token, req.NewPassword, logger.ClientIP(r))
+	switch {
+	case errors.Is(err, ErrInvalidResetToken), errors.Is(err, ErrWeakPassword):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (h *AuthHandler) setTokenCookies(w http.ResponseWriter, tokens models.TokenResponse) {
 	http.SetCookie(w, &http.Cookie{
 		Name:    "Authorization",
-		Value:   token,
-		Expires: time.Now().Add(time.Hour * 24),
+		Value:   tokens.AccessToken,
+		Expires: time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second),
 		Path:    "/",
 	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    tokens.RefreshToken,
+		Expires:  time.Now().Add(h.service.opts.RefreshTokenTTL),
+		Path:     "/api/user",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
 
-	w.WriteHeader(http.StatusOK)
+func (h *AuthHandler) clearTokenCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: "Authorization", Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: refreshCookie, Path: "/api/user", MaxAge: -1})
+}
+
+func writeThrottled(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many attempts", http.StatusTooManyRequests)
 }
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func login(h *AuthHandler, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	h.Login(w, r)
+	return w
+}
+
+func TestLoginLockedAccountSettlesAttempts(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{LoginBackoff: time.Minute})
+	userID := newUser(t, service, store, "alice", "correct-horse-battery")
+	if err := store.Users().Lock(ctx, userID, "fraud", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	h := NewAuthHandler(service)
+
+	// More attempts than lock a login: a locked account is not a failure.
+	for i := 0; i < 5; i++ {
+		if w := login(h, `{"login":"alice","password":"correct-horse-battery"}`); w.Code != http.StatusForbidden {
+			t.Fatalf("attempt %d: got status %d, want 403", i, w.Code)
+		}
+	}
+	if err := store.Users().Unlock(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if w := login(h, `{"login":"alice","password":"correct-horse-battery"}`); w.Code != http.StatusOK {
+		t.Fatalf("got status %d after unlock, want 200", w.Code)
+	}
+
+	if w := login(h, `{"login":"alice","password":"wrong-horse-battery"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want 401", w.Code)
+	}
+	if w := login(h, `{"login":"alice","password":"correct-horse-battery"}`); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d after a wrong password, want 429", w.Code)
+	}
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/notify"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tracing"
+)
+
+var (
+	ErrWrongPassword     = errors.New("current password is wrong")
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+)
+
+const (
+	AuditPasswordChanged        = "password.changed"
+	AuditPasswordResetRequested = "password.reset_requested"
+	AuditPasswordReset          = "password.reset"
+
+	revokeReasonPassword = "password change"
+	revokeReasonReset    = "password reset"
+
+	resetDeliveryTimeout = 30 * time.Second
+)
+
+// resetKey counts reset requests apart from failed logins, so requesting
+// resets does not lock anybody out of logging in.
+func resetKey(key string) string {
+	return "reset:" + key
+}
+
+func (s *AuthService) CheckPassword(login, password string) error {
+	return s.opts.PasswordPolicy.Check(login, password)
+}
+
+// ChangePassword sets a new password and revokes every other session of
+// the user; the session the change was made from stays logged in. The
+// current password is throttled like a login, so a stolen session can not
+// be used to guess it.
+func (s *AuthService) ChangePassword(ctx context.Context, userID int64, sessionID, current, password, ip string) error {
+	ctx, span := tracing.Start(ctx, "AuthService.ChangePassword")
+	defer span.End()
+
+	user, err := s.beginCode(ctx, userID, ip)
+	if err != nil {
+		tracing.Error(span, err)
+		return err
+	}
+	if !s.CheckPasswordHash(current, user.PasswordHash) {
+		s.settleCode(ctx, user.Login, ip, ErrWrongPassword)
+		return ErrWrongPassword
+	}
+	s.settleCode(ctx, user.Login, ip, nil)
+	if err := s.CheckPassword(user.Login, password); err != nil {
+		return err
+	}
+	hash, err := s.HashPassword(password)
+	if err != nil {
+		tracing.Error(span, err)
+		return err
+	}
+
+	err = s.store.WithinTx(ctx, func(tx repository.Store) error {
+		if err := tx.Users().UpdatePassword(ctx, userID, hash); err != nil {
+			return err
+		}
+		if err := tx.Sessions().RevokeUser(ctx, userID, sessionID, revokeReasonPassword); err != nil {
+			return err
+		}
+		return tx.Audit().Record(ctx, models.AuditEvent{
+			CreatedAt: time.Now(),
+			ActorID:   userID,
+			Action:    AuditPasswordChanged,
+			Subject:   loginKey(user.Login),
+			IP:        ip,
+		})
+	})
+	tracing.Error(span, err)
+	return err
+}
+
+// RequestPasswordReset sends a single-use reset token to the user. It is
+// throttled per login and per client IP like logins. Unknown logins do the
+// same work up to the delivery, which runs in the background for everyone,
+// so neither the answer nor its timing tells which logins exist.
+func (s *AuthService) RequestPasswordReset(ctx context.Context, login, ip string) error {
+	ctx, span := tracing.Start(ctx, "AuthService.RequestPasswordReset")
+	defer span.End()
+
+	now := time.Now()
+	wait, err := s.attempt(ctx, resetKey(ipKey(ip)), now, s.ipLimits())
+	if err == nil && wait == 0 {
+		wait, err = s.attempt(ctx, resetKey(loginKey(login)), now, s.loginLimits())
+	}
+	if err != nil {
+		tracing.Error(span, err)
+		return err
+	}
+	if wait > 0 {
+		return &ThrottledError{Wait: wait}
+	}
+
+	user, err := s.store.Users().GetByLogin(ctx, login)
+	known := err == nil
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		tracing.Error(span, err)
+		return err
+	}
+
+	value, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	expiresAt := now.Add(s.opts.PasswordResetTTL)
+	err = s.store.WithinTx(ctx, func(tx repository.Store) error {
+		event := models.AuditEvent{
+			CreatedAt: now,
+			Action:    AuditPasswordResetRequested,
+			Subject:   loginKey(login),
+			IP:        ip,
+		}
+		if !known {
+			event.Details = "unknown login"
+			return tx.Audit().Record(ctx, event)
+		}
+		err := tx.PasswordResets().Create(ctx, models.PasswordResetToken{
+			Hash:      hashToken(value),
+			UserID:    user.ID,
+			CreatedAt: now,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Audit().Record(ctx, event)
+	})
+	if err != nil || !known {
+		tracing.Error(span, err)
+		return err
+	}
+
+	msg := notify.Message{
+		To:      user.Login,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Use this token to reset your password: %s\nIt expires at %s.",
+			value, expiresAt.Format(time.RFC3339)),
+		Secrets: []string{value},
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resetDeliveryTimeout)
+		defer cancel()
+		if err := s.opts.Notifier.Notify(ctx, msg); err != nil {
+			logger.Sugar.Errorf("Failed to deliver password reset token to user %d: %s", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+// ResetPassword consumes a reset token, sets the new password, revokes
+// every session of the user and lifts a login lockout. The token stays
+// valid if the new password is rejected by the policy.
+func (s *AuthService) ResetPassword(ctx context.Context, token, password, ip string) error {
+	ctx, span := tracing.Start(ctx, "AuthService.ResetPassword")
+	defer span.End()
+
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		reset, err := tx.PasswordResets().Use(ctx, hashToken(token), time.Now())
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrInvalidResetToken
+		} else if err != nil {
+			return err
+		}
+		user, err := tx.Users().GetByID(ctx, reset.UserID)
+		if err != nil {
+			return err
+		}
+		if err := s.CheckPassword(user.Login, password); err != nil {
+			return err
+		}
+		hash, err := s.HashPassword(password)
+		if err != nil {
+			return err
+		}
+		if err := tx.Users().UpdatePassword(ctx, user.ID, hash); err != nil {
+			return err
+		}
+		if err := tx.Sessions().RevokeUser(ctx, user.ID, "", revokeReasonReset); err != nil {
+			return err
+		}
+		if err := tx.LoginAttempts().Reset(ctx, loginKey(user.Login)); err != nil {
+			return err
+		}
+		return tx.Audit().Record(ctx, models.AuditEvent{
+			CreatedAt: time.Now(),
+			ActorID:   user.ID,
+			Action:    AuditPasswordReset,
+			Subject:   loginKey(user.Login),
+			IP:        ip,
+		})
+	})
+	tracing.Error(span, err)
+	return err
+}
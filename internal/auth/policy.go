@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrWeakPassword = errors.New("password does not meet the policy")
+
+// bcrypt ignores everything after 72 bytes.
+const maxPasswordBytes = 72
+
+// PasswordPolicy rejects short, breached and login-like passwords.
+type PasswordPolicy struct {
+	MinLength int
+	// breached holds upper-case SHA-1 hashes, the format of the Have I
+	// Been Pwned dumps, so plain and hashed lists are both supported.
+	breached map[string]struct{}
+}
+
+// LoadPasswordPolicy reads the breached password list: one password, SHA-1
+// hash or HASH:count line per entry. An empty path disables the check.
+func LoadPasswordPolicy(minLength int, breachedFile string) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{MinLength: minLength, breached: make(map[string]struct{})}
+	if breachedFile == "" {
+		return p, nil
+	}
+
+	f, err := os.Open(breachedFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1(hash) {
+			p.breached[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		p.breached[sha1Hex(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", breachedFile, err)
+	}
+	return p, nil
+}
+
+// Check returns an ErrWeakPassword error describing the first violation.
+func (p *PasswordPolicy) Check(login, password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrWeakPassword, p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: must be at most %d bytes", ErrWeakPassword, maxPasswordBytes)
+	}
+	if similar(login, password) {
+		return fmt.Errorf("%w: is too similar to the login", ErrWeakPassword)
+	}
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		return fmt.Errorf("%w: appears in a list of breached passwords", ErrWeakPassword)
+	}
+	return nil
+}
+
+// similar catches passwords that contain the login, are contained in it
+// or differ from it by a couple of edits, case-insensitively.
+func similar(login, password string) bool {
+	login = strings.ToLower(login)
+	password = strings.ToLower(password)
+	if login == "" {
+		return false
+	}
+	if utf8.RuneCountInString(login) >= 3 && strings.Contains(password, login) {
+		return true
+	}
+	if strings.Contains(login, password) {
+		return true
+	}
+	return levenshtein(login, password) <= 2
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func isSHA1(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
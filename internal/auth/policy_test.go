@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	breached := filepath.Join(t.TempDir(), "breached.txt")
+	list := strings.Join([]string{
+		"# plain passwords and SHA-1 hashes",
+		"password123",
+		"",
+		// SHA-1 of "letmein-please" with a count, as in the HIBP dumps.
+		strings.ToLower(sha1Hex("letmein-please")) + ":42",
+	}, "\n")
+	if err := os.WriteFile(breached, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPasswordPolicy(8, breached)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		valid    bool
+	}{
+		{name: "strong", login: "alice", password: "correct-horse-battery", valid: true},
+		{name: "minimal length", login: "alice", password: "tr0ub4d&", valid: true},
+		{name: "short", login: "alice", password: "tr0ub4d"},
+		{name: "short in characters", login: "alice", password: "пароль"},
+		{name: "multibyte counted in characters", login: "alice", password: "пароль-на-вечер", valid: true},
+		{name: "longer than bcrypt reads", login: "alice", password: strings.Repeat("x", maxPasswordBytes+1)},
+		{name: "contains login", login: "alice", password: "ALICE-in-wonderland"},
+		{name: "contained in login", login: "alice.wonderland@example.com", password: "wonderland"},
+		{name: "login with a couple of edits", login: "gopher-mart", password: "gopher_mart!"},
+		{name: "short login is not matched inside", login: "al", password: "always-almost-all", valid: true},
+		{name: "breached plain", login: "alice", password: "password123"},
+		{name: "breached hash", login: "alice", password: "letmein-please"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.login, tt.password)
+			if tt.valid && err != nil {
+				t.Fatalf("got %v, want valid", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrWeakPassword) {
+				t.Fatalf("got %v, want ErrWeakPassword", err)
+			}
+		})
+	}
+}
+
+func TestLoadPasswordPolicyMissingFile(t *testing.T) {
+	if _, err := LoadPasswordPolicy(8, filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Fatal("missing breached password list was accepted")
+	}
+	policy, err := LoadPasswordPolicy(8, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Check("alice", "password123"); err != nil {
+		t.Fatalf("got %v without a breached password list", err)
+	}
+}
@@ -1,95 +1,291 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"time"
 
-	"database/sql"
-
 	"github.com/golang-jwt/jwt"
 	logger "github.com/thalq/gopher_mart/internal/middleware"
 	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/notify"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tokens"
+	"github.com/thalq/gopher_mart/internal/tracing"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+	ErrAccountLocked       = errors.New("account is locked")
+)
+
+const revokeReasonLogout = "logout"
+const revokeReasonReuse = "refresh token reuse"
+
+type Options struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// MaxLoginFailures locks a login, MaxIPLoginFailures a client IP.
+	MaxLoginFailures   int
+	MaxIPLoginFailures int
+	LoginBackoff       time.Duration
+	LoginLockout       time.Duration
+
+	PasswordPolicy   *PasswordPolicy
+	PasswordResetTTL time.Duration
+	// Notifier delivers password reset tokens.
+	Notifier notify.Notifier
+}
+
 type AuthService struct {
-	db        *sql.DB
-	jwtSecret string
+	store repository.Store
+	keys  *tokens.KeySet
+	opts  Options
 }
 
-func NewAuthService(db *sql.DB, jwtSecret string) *AuthService {
+// dummyHash is compared against when the login does not exist, so unknown
+// logins take as long as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("gophermart-dummy-password"), bcrypt.DefaultCost)
+
+func NewAuthService(store repository.Store, keys *tokens.KeySet, opts Options) *AuthService {
 	return &AuthService{
-		db:        db,
-		jwtSecret: jwtSecret}
+		store: store,
+		keys:  keys,
+		opts:  opts}
 }
 
-func (s *AuthService) GenerateToken(userID int64) string {
+// GenerateToken issues an access token. The role is copied into the
+// token, so role changes revoke sessions to take effect at once.
+func (s *AuthService) GenerateToken(user models.User, sessionID string) (string, error) {
 	claims := &models.Claims{
-		UserID: userID,
+		UserID:    user.ID,
+		SessionID: sessionID,
+		Role:      user.Role,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+			ExpiresAt: time.Now().Add(s.opts.AccessTokenTTL).Unix(),
 		},
 	}
+	return s.keys.Sign(claims)
+}
+
+// IssueTokens starts a new session and returns its first access and
+// refresh tokens.
+func (s *AuthService) IssueTokens(ctx context.Context, userID int64) (models.TokenResponse, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.IssueTokens")
+	defer span.End()
+
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	var user models.User
+	var refreshToken string
+	err = s.store.WithinTx(ctx, func(tx repository.Store) error {
+		if user, err = tx.Users().GetByID(ctx, userID); err != nil {
+			return err
+		}
+		if user.Locked() {
+			return ErrAccountLocked
+		}
+		session := models.Session{ID: sessionID, UserID: userID, CreatedAt: time.Now()}
+		if err := tx.Sessions().Create(ctx, session); err != nil {
+			return err
+		}
+		refreshToken, err = s.addRefreshToken(ctx, tx, session)
+		return err
+	})
+	if err != nil {
+		tracing.Error(span, err)
+		return models.TokenResponse{}, err
+	}
+	return s.tokenResponse(user, sessionID, refreshToken)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.jwtSecret))
+// Refresh exchanges a refresh token for a new pair. A token can be used
+// once: presenting it again means it leaked, so the whole session is
+// revoked and every token issued from it stops working.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (models.TokenResponse, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Refresh")
+	defer span.End()
+
+	hash := hashToken(refreshToken)
+	var session models.Session
+	var user models.User
+	var newToken string
+	var reused bool
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		token, err := tx.Sessions().GetRefreshToken(ctx, hash)
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrInvalidRefreshToken
+		} else if err != nil {
+			return err
+		}
+		if session, err = tx.Sessions().Get(ctx, token.SessionID); err != nil {
+			return err
+		}
+		if session.Revoked() {
+			return ErrInvalidRefreshToken
+		}
+
+		fresh := token.UsedAt.IsZero()
+		if fresh {
+			if fresh, err = tx.Sessions().UseRefreshToken(ctx, hash, time.Now()); err != nil {
+				return err
+			}
+		}
+		if !fresh {
+			// The revocation must be committed, so the error is returned
+			// after the transaction.
+			reused = true
+			return tx.Sessions().Revoke(ctx, session.ID, revokeReasonReuse)
+		}
+		if time.Now().After(token.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+		if user, err = tx.Users().GetByID(ctx, session.UserID); err != nil {
+			return err
+		}
+		if user.Locked() {
+			return ErrAccountLocked
+		}
+		newToken, err = s.addRefreshToken(ctx, tx, session)
+		return err
+	})
+	if err == nil && reused {
+		logger.Sugar.Warnf("Refresh token reused, session %s of user %d revoked", session.ID, session.UserID)
+		err = ErrRefreshTokenReused
+	}
 	if err != nil {
-		return ""
+		tracing.Error(span, err)
+		return models.TokenResponse{}, err
 	}
+	return s.tokenResponse(user, session.ID, newToken)
+}
+
+func (s *AuthService) Logout(ctx context.Context, sessionID string) error {
+	ctx, span := tracing.Start(ctx, "AuthService.Logout")
+	defer span.End()
 
-	return tokenString
+	err := s.store.Sessions().Revoke(ctx, sessionID, revokeReasonLogout)
+	tracing.Error(span, err)
+	return err
 }
 
-func (s *AuthService) CheckUserExists(username string) (bool, error) {
-	var userExists bool
-	if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", username).Scan(&userExists); err != nil {
-		logger.Sugar.Errorf("Error check user exists: %s", err)
+// SessionActive implements middleware.SessionValidator. Tokens issued
+// before sessions existed carry no session and are rejected.
+func (s *AuthService) SessionActive(ctx context.Context, claims *models.Claims) (bool, error) {
+	session, err := s.store.Sessions().Get(ctx, claims.SessionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
 		return false, err
 	}
-	return userExists, nil
+	return session.UserID == claims.UserID && !session.Revoked(), nil
 }
 
-func (s *AuthService) Register(login, password string) (int64, error) {
-	var userID int64
-	hash, err := s.HashPassword(password)
+func (s *AuthService) addRefreshToken(ctx context.Context, tx repository.Store, session models.Session) (string, error) {
+	value, err := randomToken(32)
 	if err != nil {
-		return 0, err
+		return "", err
 	}
-	err = s.db.QueryRow(
-		"INSERT INTO users (username, password) VALUES ($1, $2) RETURNING id",
-		login, hash,
-	).Scan(&userID)
+	now := time.Now()
+	err = tx.Sessions().AddRefreshToken(ctx, models.RefreshToken{
+		Hash:      hashToken(value),
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.opts.RefreshTokenTTL),
+	})
+	return value, err
+}
+
+func (s *AuthService) tokenResponse(user models.User, sessionID, refreshToken string) (models.TokenResponse, error) {
+	accessToken, err := s.GenerateToken(user, sessionID)
 	if err != nil {
-		logger.Sugar.Errorf("Error insert user to db: %s", err)
-		return 0, err
+		return models.TokenResponse{}, err
 	}
-	return userID, nil
+	return models.TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.opts.AccessTokenTTL.Seconds()),
+	}, nil
 }
 
-func (s *AuthService) CreateUserBalance(userID int64) error {
-	_, err := s.db.Exec(`
-		INSERT INTO user_balance (user_id, current_balance)
-		VALUES ($1, 0.0)
-	`, userID)
-	if err != nil {
-		logger.Sugar.Errorf("Error insert user balance to db: %s", err)
-		return err
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	return nil
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what is stored for refresh tokens: they are random, so a
+// plain SHA-256 is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *AuthService) CheckUserExists(ctx context.Context, username string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.CheckUserExists")
+	defer span.End()
+
+	return s.store.Users().Exists(ctx, username)
 }
 
-func (s *AuthService) Authenticate(login, password string) (bool, int64, error) {
-	var storedPassword string
-	var userID int64
+func (s *AuthService) Register(ctx context.Context, login, password string) (int64, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Register")
+	defer span.End()
 
-	err := s.db.QueryRow("SELECT id, password FROM users WHERE username = $1", login).Scan(&userID, &storedPassword)
+	_, hashSpan := tracing.Start(ctx, "bcrypt.GenerateFromPassword")
+	hash, err := s.HashPassword(password)
+	hashSpan.End()
 	if err != nil {
-		logger.Sugar.Errorf("Error get user from db: %s", err)
+		tracing.Error(span, err)
+		return 0, err
+	}
+	userID, err := s.store.Users().Create(ctx, login, hash)
+	tracing.Error(span, err)
+	return userID, err
+}
+
+func (s *AuthService) CreateUserBalance(ctx context.Context, userID int64) error {
+	ctx, span := tracing.Start(ctx, "AuthService.CreateUserBalance")
+	defer span.End()
+
+	return s.store.Balances().Create(ctx, userID)
+}
+
+func (s *AuthService) Authenticate(ctx context.Context, login, password string) (bool, int64, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Authenticate")
+	defer span.End()
+
+	user, err := s.store.Users().GetByLogin(ctx, login)
+	found := err == nil
+	if errors.Is(err, repository.ErrNotFound) {
+		user.PasswordHash = string(dummyHash)
+	} else if err != nil {
+		tracing.Error(span, err)
 		return false, 0, err
 	}
-	if !s.CheckPasswordHash(password, storedPassword) {
+	_, compareSpan := tracing.Start(ctx, "bcrypt.CompareHashAndPassword")
+	matches := s.CheckPasswordHash(password, user.PasswordHash)
+	compareSpan.End()
+	if !matches || !found {
 		return false, 0, nil
 	}
-	return true, userID, nil
+	if user.Locked() {
+		return false, 0, ErrAccountLocked
+	}
+	return true, user.ID, nil
 }
 
 func (s *AuthService) CheckPasswordHash(password, hash string) bool {
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+// sessionOf checks the access token and returns its claims.
+func sessionOf(t *testing.T, service *AuthService, tokens models.TokenResponse) *models.Claims {
+	t.Helper()
+	claims := &models.Claims{}
+	if _, err := service.keys.Parse(tokens.AccessToken, claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func expectActive(t *testing.T, service *AuthService, claims *models.Claims, want bool) {
+	t.Helper()
+	active, err := service.SessionActive(context.Background(), claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active != want {
+		t.Fatalf("session %s active: got %v, want %v", claims.SessionID, active, want)
+	}
+}
+
+func TestRefreshRotation(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{})
+	userID := newUser(t, service, store, "alice", "password")
+
+	first, err := service.IssueTokens(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh token was not rotated")
+	}
+	claims := sessionOf(t, service, second)
+	if claims.UserID != userID || claims.SessionID != sessionOf(t, service, first).SessionID {
+		t.Fatalf("refreshed token belongs to user %d, session %s", claims.UserID, claims.SessionID)
+	}
+	expectActive(t, service, claims, true)
+
+	if _, err := service.Refresh(ctx, "unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("unknown refresh token: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+func TestRefreshReuseRevokesSession(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{})
+	userID := newUser(t, service, store, "alice", "password")
+
+	stolen, err := service.IssueTokens(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legitimate, err := service.Refresh(ctx, stolen.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := service.IssueTokens(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.Refresh(ctx, stolen.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reused refresh token: got %v, want %v", err, ErrRefreshTokenReused)
+	}
+	// Every token of the session stops working, also the one issued to
+	// whoever refreshed first.
+	expectActive(t, service, sessionOf(t, service, legitimate), false)
+	if _, err := service.Refresh(ctx, legitimate.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("refresh in a revoked session: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	// Other sessions of the user are left alone.
+	expectActive(t, service, sessionOf(t, service, other), true)
+	if _, err := service.Refresh(ctx, other.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshExpired(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{})
+	userID := newUser(t, service, store, "alice", "password")
+	service.opts.RefreshTokenTTL = -time.Second
+
+	tokens, err := service.IssueTokens(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Refresh(ctx, tokens.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expired refresh token: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	// An expired token is not a reuse: the session survives.
+	expectActive(t, service, sessionOf(t, service, tokens), true)
+}
+
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{})
+	userID := newUser(t, service, store, "alice", "password")
+
+	tokens, err := service.IssueTokens(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := sessionOf(t, service, tokens)
+	if err := service.Logout(ctx, claims.SessionID); err != nil {
+		t.Fatal(err)
+	}
+	expectActive(t, service, claims, false)
+	if _, err := service.Refresh(ctx, tokens.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("refresh after logout: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+
+	// Tokens issued before sessions existed are refused.
+	expectActive(t, service, &models.Claims{UserID: userID}, false)
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tracing"
+)
+
+const AuditLoginLocked = "login.locked"
+
+// ThrottledError refuses an attempt until Wait has passed.
+type ThrottledError struct {
+	Wait time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return "too many failed attempts"
+}
+
+// Failed logins are tracked separately for the login and for the client
+// IP: the first stops guessing one password, the second stops trying one
+// password against many logins.
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginLimits throttle one login: failures slow it down exponentially and
+// enough of them lock it.
+func (s *AuthService) loginLimits() models.AttemptLimits {
+	return models.AttemptLimits{
+		MaxFailures: s.opts.MaxLoginFailures,
+		Backoff:     s.opts.LoginBackoff,
+		Lockout:     s.opts.LoginLockout,
+	}
+}
+
+// ipLimits throttle a client IP. Many users may share an IP behind NAT, so
+// IPs are only locked, never slowed down by failures of others.
+func (s *AuthService) ipLimits() models.AttemptLimits {
+	return models.AttemptLimits{
+		MaxFailures: s.opts.MaxIPLoginFailures,
+		Lockout:     s.opts.LoginLockout,
+	}
+}
+
+// BeginLogin starts an attempt to prove the identity of a login, with a
+// password or a code. The attempt is counted as a failed one up front, in
+// the same step that checks the throttle, so parallel requests can not all
+// be let in before the first failure is recorded. It returns how long the
+// client has to wait when the attempt is refused, or 0. The attempt is
+// then settled with LoginFailed, LoginPassed or LoginSucceeded. Unknown
+// logins are throttled the same way as existing ones, so the answer says
+// nothing about which logins exist.
+func (s *AuthService) BeginLogin(ctx context.Context, login, ip string) (time.Duration, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.BeginLogin")
+	defer span.End()
+
+	now := time.Now()
+	wait, err := s.attempt(ctx, ipKey(ip), now, s.ipLimits())
+	if err != nil || wait > 0 {
+		tracing.Error(span, err)
+		return wait, err
+	}
+	wait, err = s.attempt(ctx, loginKey(login), now, s.loginLimits())
+	if err == nil && wait > 0 {
+		err = s.store.LoginAttempts().Forgive(ctx, ipKey(ip))
+	}
+	tracing.Error(span, err)
+	return wait, err
+}
+
+func (s *AuthService) attempt(ctx context.Context, key string, now time.Time, limits models.AttemptLimits) (time.Duration, error) {
+	attempts, allowed, err := s.store.LoginAttempts().Attempt(ctx, key, now, limits)
+	if errors.Is(err, repository.ErrNotFound) {
+		// The key was reset between the check and the read.
+		return 0, nil
+	} else if err != nil || allowed {
+		return 0, err
+	}
+	return max(attempts.BlockedUntil(limits).Sub(now), time.Second), nil
+}
+
+// LoginFailed settles a failed attempt, which BeginLogin has already
+// counted, and locks the keys that reached their limit.
+func (s *AuthService) LoginFailed(ctx context.Context, login, ip string) error {
+	ctx, span := tracing.Start(ctx, "AuthService.LoginFailed")
+	defer span.End()
+
+	err := errors.Join(
+		s.lockExceeded(ctx, loginKey(login), s.opts.MaxLoginFailures, ip),
+		s.lockExceeded(ctx, ipKey(ip), s.opts.MaxIPLoginFailures, ip),
+	)
+	tracing.Error(span, err)
+	return err
+}
+
+// LoginPassed takes back an attempt that was good but does not finish the
+// login yet, like a password that still needs a second factor.
+func (s *AuthService) LoginPassed(ctx context.Context, login, ip string) error {
+	return errors.Join(
+		s.store.LoginAttempts().Forgive(ctx, loginKey(login)),
+		s.store.LoginAttempts().Forgive(ctx, ipKey(ip)),
+	)
+}
+
+// LoginSucceeded forgets failures of the login. Failures of the IP are
+// kept, only the successful attempt is taken back: logging into one's own
+// account must not reset the counter used to guess passwords of others.
+func (s *AuthService) LoginSucceeded(ctx context.Context, login, ip string) error {
+	return errors.Join(
+		s.store.LoginAttempts().Reset(ctx, loginKey(login)),
+		s.store.LoginAttempts().Forgive(ctx, ipKey(ip)),
+	)
+}
+
+// beginCode starts a throttled attempt to check a code or the password of
+// a logged in user. They are throttled like logins, so a stolen session can
+// not be used to guess them either.
+func (s *AuthService) beginCode(ctx context.Context, userID int64, ip string) (models.User, error) {
+	user, err := s.store.Users().GetByID(ctx, userID)
+	if err != nil {
+		return models.User{}, err
+	}
+	wait, err := s.BeginLogin(ctx, user.Login, ip)
+	if err != nil {
+		return models.User{}, err
+	}
+	if wait > 0 {
+		return models.User{}, &ThrottledError{Wait: wait}
+	}
+	return user, nil
+}
+
+// settleCode settles the attempt of beginCode: wrong codes and passwords
+// are failures, anything else is taken back.
+func (s *AuthService) settleCode(ctx context.Context, login, ip string, err error) {
+	settle := s.LoginPassed
+	if errors.Is(err, ErrInvalidCode) || errors.Is(err, ErrWrongPassword) {
+		settle = s.LoginFailed
+	}
+	if err := settle(ctx, login, ip); err != nil {
+		logger.Sugar.Errorf("Failed to settle code attempt: %s", err)
+	}
+}
+
+func (s *AuthService) lockExceeded(ctx context.Context, key string, maxFailures int, ip string) error {
+	now := time.Now()
+	attempts, err := s.store.LoginAttempts().Get(ctx, key)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if maxFailures <= 0 || attempts.Failures < maxFailures || attempts.LockedUntil.After(now) {
+		return nil
+	}
+
+	until := now.Add(s.opts.LoginLockout)
+	if err := s.store.LoginAttempts().Lock(ctx, key, until); err != nil {
+		return err
+	}
+	logger.Sugar.Warnf("Login locked for %s until %s after %d failures", key, until.Format(time.RFC3339), attempts.Failures)
+	return s.store.Audit().Record(ctx, models.AuditEvent{
+		CreatedAt: now,
+		Action:    AuditLoginLocked,
+		Subject:   key,
+		IP:        ip,
+		Details:   fmt.Sprintf("%d failed attempts, locked until %s", attempts.Failures, until.Format(time.RFC3339)),
+	})
+}
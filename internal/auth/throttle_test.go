@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+// fail makes a failed attempt and checks that it was let in.
+func fail(t *testing.T, service *AuthService, login, ip string) {
+	t.Helper()
+	ctx := context.Background()
+	wait, err := service.BeginLogin(ctx, login, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait > 0 {
+		t.Fatalf("attempt for %s from %s refused for %s", login, ip, wait)
+	}
+	if err := service.LoginFailed(ctx, login, ip); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectWait(t *testing.T, service *AuthService, login, ip string, min, max time.Duration) {
+	t.Helper()
+	wait, err := service.BeginLogin(context.Background(), login, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait < min || wait > max {
+		t.Fatalf("attempt for %s from %s: got wait %s, want %s..%s", login, ip, wait, min, max)
+	}
+}
+
+func TestLoginBackoff(t *testing.T) {
+	service, _ := testService(t, Options{LoginBackoff: time.Minute})
+
+	fail(t, service, "alice", "192.0.2.1")
+	expectWait(t, service, "alice", "192.0.2.1", 59*time.Second, time.Minute)
+	// The refused attempt is not counted: the backoff did not double.
+	expectWait(t, service, "alice", "192.0.2.1", 59*time.Second, time.Minute)
+	// Other logins from the same IP are not slowed down.
+	expectWait(t, service, "bob", "192.0.2.1", 0, 0)
+}
+
+func TestLoginLockout(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{})
+
+	for i := 0; i < 3; i++ {
+		fail(t, service, "alice", "192.0.2.1")
+	}
+	expectWait(t, service, "alice", "192.0.2.2", 59*time.Minute, time.Hour)
+	events, err := store.Audit().List(ctx, models.AuditFilter{Subject: loginKey("alice")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Action != AuditLoginLocked {
+		t.Fatalf("got audit events %+v, want one %s", events, AuditLoginLocked)
+	}
+}
+
+func TestIPLockout(t *testing.T) {
+	service, _ := testService(t, Options{})
+
+	// One password tried against many logins.
+	for _, login := range []string{"a", "b", "c", "d", "e"} {
+		fail(t, service, login, "192.0.2.1")
+	}
+	expectWait(t, service, "f", "192.0.2.1", 59*time.Minute, time.Hour)
+	expectWait(t, service, "f", "192.0.2.2", 0, 0)
+}
+
+func TestLoginSucceededKeepsIPFailures(t *testing.T) {
+	ctx := context.Background()
+	service, _ := testService(t, Options{})
+
+	for _, login := range []string{"a", "b", "c", "alice"} {
+		fail(t, service, login, "192.0.2.1")
+	}
+	expectWait(t, service, "alice", "192.0.2.1", 0, 0)
+	if err := service.LoginSucceeded(ctx, "alice", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	// Logging into one's own account does not reset the IP: one more
+	// failure locks it.
+	fail(t, service, "d", "192.0.2.1")
+	expectWait(t, service, "mallory", "192.0.2.1", 59*time.Minute, time.Hour)
+}
+
+func TestLoginPassedTakesBackTheAttempt(t *testing.T) {
+	ctx := context.Background()
+	service, _ := testService(t, Options{LoginBackoff: time.Minute})
+
+	for i := 0; i < 10; i++ {
+		expectWait(t, service, "alice", "192.0.2.1", 0, 0)
+		if err := service.LoginPassed(ctx, "alice", "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The RFC lists eight digit codes, six digit codes are their tails.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		step   int64
+		ok     bool
+	}{
+		{name: "current step", secret: rfc6238Secret, code: "050471", step: current, ok: true},
+		{name: "lower-case secret", secret: strings.ToLower(rfc6238Secret), code: "050471", step: current, ok: true},
+		{name: "previous step", secret: rfc6238Secret, code: codeAt(t, current-1), step: current - 1, ok: true},
+		{name: "next step", secret: rfc6238Secret, code: codeAt(t, current+1), step: current + 1, ok: true},
+		{name: "two steps ago", secret: rfc6238Secret, code: codeAt(t, current-2)},
+		{name: "wrong code", secret: rfc6238Secret, code: "000000"},
+		{name: "eight digits", secret: rfc6238Secret, code: "14050471"},
+		{name: "empty code", secret: rfc6238Secret, code: ""},
+		{name: "broken secret", secret: "not base32!", code: "050471"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := matchTOTP(tt.secret, tt.code, now)
+			if ok != tt.ok || step != tt.step {
+				t.Fatalf("got step %d, %v, want %d, %v", step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+func codeAt(t *testing.T, step int64) string {
+	t.Helper()
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, step)
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes, %v", secret, len(key), err)
+	}
+	if _, ok := matchTOTP(secret, totpCode(key, 1), time.Unix(totpPeriod, 0)); !ok {
+		t.Fatal("code of a new secret is not accepted")
+	}
+}
+
+func TestOTPAuthURL(t *testing.T) {
+	u, err := url.Parse(otpauthURL("alice smith", rfc6238Secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/GopherMart:alice smith" {
+		t.Fatalf("got %s", u)
+	}
+	query := u.Query()
+	if query.Get("secret") != rfc6238Secret || query.Get("issuer") != "GopherMart" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Fatalf("got query %v", query)
+	}
+}
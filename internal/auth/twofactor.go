@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tracing"
+)
+
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotPending = errors.New("two-factor enrollment was not started")
+	ErrInvalidCode         = errors.New("invalid code")
+	ErrInvalidChallenge    = errors.New("invalid or expired challenge")
+)
+
+const (
+	AuditTwoFactorEnabled  = "2fa.enabled"
+	AuditTwoFactorDisabled = "2fa.disabled"
+
+	challengeTTL      = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URL    string `json:"otpauth_url"`
+}
+
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	Challenge         string `json:"challenge"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+// EnrollTOTP generates a secret. It is not required on login until
+// ConfirmTOTP proves the user's app produces valid codes.
+func (s *AuthService) EnrollTOTP(ctx context.Context, userID int64) (TOTPEnrollment, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.EnrollTOTP")
+	defer span.End()
+
+	user, err := s.store.Users().GetByID(ctx, userID)
+	if err != nil {
+		tracing.Error(span, err)
+		return TOTPEnrollment{}, err
+	}
+	if user.TOTPEnabled {
+		return TOTPEnrollment{}, ErrTwoFactorEnabled
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if err := s.store.TwoFactor().SetPending(ctx, userID, secret); err != nil {
+		tracing.Error(span, err)
+		return TOTPEnrollment{}, err
+	}
+	return TOTPEnrollment{Secret: secret, URL: otpauthURL(user.Login, secret)}, nil
+}
+
+// ConfirmTOTP enables TOTP and returns recovery codes. They are shown only
+// once: only their hashes are stored. Codes are throttled like logins.
+func (s *AuthService) ConfirmTOTP(ctx context.Context, userID int64, code, ip string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.ConfirmTOTP")
+	defer span.End()
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.beginCode(ctx, userID, ip)
+	if err != nil {
+		tracing.Error(span, err)
+		return nil, err
+	}
+	err = s.store.WithinTx(ctx, func(tx repository.Store) error {
+		user, err := tx.Users().GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user.TOTPEnabled {
+			return ErrTwoFactorEnabled
+		}
+		if user.TOTPSecret == "" {
+			return ErrTwoFactorNotPending
+		}
+		if ok, err := s.verifyTOTP(ctx, tx, user, code); err != nil {
+			return err
+		} else if !ok {
+			return ErrInvalidCode
+		}
+		if err := tx.TwoFactor().Enable(ctx, userID, hashes); err != nil {
+			return err
+		}
+		return tx.Audit().Record(ctx, models.AuditEvent{
+			CreatedAt: time.Now(),
+			ActorID:   userID,
+			Action:    AuditTwoFactorEnabled,
+			Subject:   loginKey(user.Login),
+			IP:        ip,
+		})
+	})
+	s.settleCode(ctx, user.Login, ip, err)
+	if err != nil {
+		tracing.Error(span, err)
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP requires a current code or a recovery code. Codes are
+// throttled like logins.
+func (s *AuthService) DisableTOTP(ctx context.Context, userID int64, code, ip string) error {
+	ctx, span := tracing.Start(ctx, "AuthService.DisableTOTP")
+	defer span.End()
+
+	user, err := s.beginCode(ctx, userID, ip)
+	if err != nil {
+		tracing.Error(span, err)
+		return err
+	}
+	err = s.store.WithinTx(ctx, func(tx repository.Store) error {
+		user, err := tx.Users().GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !user.TOTPEnabled {
+			return ErrTwoFactorNotEnabled
+		}
+		if ok, err := s.verifySecondFactor(ctx, tx, user, code); err != nil {
+			return err
+		} else if !ok {
+			return ErrInvalidCode
+		}
+		if err := tx.TwoFactor().Disable(ctx, userID); err != nil {
+			return err
+		}
+		return tx.Audit().Record(ctx, models.AuditEvent{
+			CreatedAt: time.Now(),
+			ActorID:   userID,
+			Action:    AuditTwoFactorDisabled,
+			Subject:   loginKey(user.Login),
+			IP:        ip,
+		})
+	})
+	s.settleCode(ctx, user.Login, ip, err)
+	tracing.Error(span, err)
+	return err
+}
+
+func (s *AuthService) TwoFactorRequired(ctx context.Context, userID int64) (bool, error) {
+	user, err := s.store.Users().GetByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return user.TOTPEnabled, nil
+}
+
+// Challenge is returned by login instead of tokens when the password was
+// right but a second factor is required. It only proves the password and
+// is rejected by AuthMiddleware.
+func (s *AuthService) Challenge(userID int64) (TwoFactorChallenge, error) {
+	token, err := s.keys.Sign(&models.Claims{
+		UserID: userID,
+		Scope:  models.ScopeTwoFactor,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(challengeTTL).Unix(),
+		},
+	})
+	if err != nil {
+		return TwoFactorChallenge{}, err
+	}
+	return TwoFactorChallenge{
+		TwoFactorRequired: true,
+		Challenge:         token,
+		ExpiresIn:         int64(challengeTTL.Seconds()),
+	}, nil
+}
+
+func (s *AuthService) ParseChallenge(ctx context.Context, challenge string) (models.User, error) {
+	claims := &models.Claims{}
+	token, err := s.keys.Parse(challenge, claims)
+	if err != nil || !token.Valid || claims.Scope != models.ScopeTwoFactor {
+		return models.User{}, ErrInvalidChallenge
+	}
+	user, err := s.store.Users().GetByID(ctx, claims.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return models.User{}, ErrInvalidChallenge
+	}
+	return user, err
+}
+
+// VerifySecondFactor accepts a TOTP code or an unused recovery code.
+func (s *AuthService) VerifySecondFactor(ctx context.Context, user models.User, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.VerifySecondFactor")
+	defer span.End()
+
+	ok, err := s.verifySecondFactor(ctx, s.store, user, code)
+	tracing.Error(span, err)
+	return ok, err
+}
+
+func (s *AuthService) verifySecondFactor(ctx context.Context, store repository.Store, user models.User, code string) (bool, error) {
+	if ok, err := s.verifyTOTP(ctx, store, user, code); ok || err != nil {
+		return ok, err
+	}
+	return store.TwoFactor().UseRecoveryCode(ctx, user.ID, hashToken(normalizeRecoveryCode(code)), time.Now())
+}
+
+// verifyTOTP accepts each time step once, so an observed code can not be
+// replayed within its validity window.
+func (s *AuthService) verifyTOTP(ctx context.Context, store repository.Store, user models.User, code string) (bool, error) {
+	step, ok := matchTOTP(user.TOTPSecret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return false, nil
+	}
+	return store.TwoFactor().UseStep(ctx, user.ID, step)
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for len(codes) < recoveryCodeCount {
+		value, err := newTOTPSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(value[:5] + "-" + value[5:10])
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -4,3 +4,4 @@ import "errors"
 
 var ErrTooManyRequests = errors.New("too many requests")
 var ErrInternalServer = errors.New("internal server error")
+var ErrInvalidAccrual = errors.New("invalid accrual response")
@@ -0,0 +1,132 @@
+// Package health serves liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+
+	checkTimeout = 2 * time.Second
+)
+
+// Check returns nil when the dependency is usable.
+type Check func(ctx context.Context) error
+
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs dependency checks. Liveness runs only the checks added with
+// AddLiveness, readiness runs all of them and fails while the app is not
+// ready to serve, e.g. during graceful shutdown.
+type Checker struct {
+	liveness  []namedCheck
+	readiness []namedCheck
+	ready     atomic.Bool
+}
+
+func NewChecker() *Checker {
+	c := &Checker{}
+	c.ready.Store(true)
+	return c
+}
+
+// AddReadiness registers a check of an external dependency.
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.readiness = append(c.readiness, namedCheck{name: name, check: check})
+}
+
+// AddLiveness registers a check of the process itself. It is a readiness
+// check as well.
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.liveness = append(c.liveness, namedCheck{name: name, check: check})
+	c.AddReadiness(name, check)
+}
+
+func (c *Checker) SetReady(ready bool) {
+	c.ready.Store(ready)
+}
+
+func run(ctx context.Context, checks []namedCheck) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := nc.check(ctx)
+			result := CheckResult{
+				Status:    StatusOK,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[nc.name] = result
+			if err != nil {
+				report.Status = StatusFail
+			}
+		}(nc)
+	}
+	wg.Wait()
+	return report
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	response, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	if report.Status == StatusOK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(response)
+}
+
+// Liveness serves /healthz.
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, run(r.Context(), c.liveness))
+}
+
+// Readiness serves /readyz.
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := run(r.Context(), c.readiness)
+	if !c.ready.Load() {
+		report.Status = StatusFail
+		report.Checks["shutdown"] = CheckResult{Status: StatusFail, Error: "shutting down"}
+	}
+	writeReport(w, report)
+}
@@ -0,0 +1,186 @@
+// Package ledger describes points as an append-only double-entry journal.
+// Persistence lives in repository.BalanceRepository.
+package ledger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+type EntryKind string
+
+const (
+	KindAccrual    EntryKind = "ACCRUAL"
+	KindWithdrawal EntryKind = "WITHDRAWAL"
+	KindReversal   EntryKind = "REVERSAL"
+	KindAdjustment EntryKind = "ADJUSTMENT"
+	KindExpiry     EntryKind = "EXPIRY"
+	KindBonus      EntryKind = "BONUS"
+)
+
+// System accounts are the counterparties of user accounts.
+const (
+	AccountAccrual     = "system:accrual"
+	AccountWithdrawals = "system:withdrawals"
+	AccountAdjustments = "system:adjustments"
+	AccountExpired     = "system:expired"
+	AccountCampaigns   = "system:campaigns"
+
+	userAccountPrefix = "user:"
+)
+
+func UserAccount(userID int64) string {
+	return userAccountPrefix + strconv.FormatInt(userID, 10)
+}
+
+// ParseUserAccount returns the user of a user account code.
+func ParseUserAccount(code string) (int64, bool) {
+	id, ok := strings.CutPrefix(code, userAccountPrefix)
+	if !ok {
+		return 0, false
+	}
+	userID, err := strconv.ParseInt(id, 10, 64)
+	return userID, err == nil
+}
+
+// Posting changes the balance of a single account. Positive amount
+// increases the balance.
+type Posting struct {
+	Account string       `json:"account"`
+	Amount  models.Money `json:"amount"`
+}
+
+// Entry is an immutable journal record. Postings of an entry always sum
+// to zero.
+type Entry struct {
+	ID          int64     `json:"id"`
+	Kind        EntryKind `json:"kind"`
+	Reference   string    `json:"reference,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Reverses    int64     `json:"reverses,omitempty"`
+	Postings    []Posting `json:"postings"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (e Entry) Validate() error {
+	if len(e.Postings) < 2 {
+		return fmt.Errorf("entry needs at least two postings")
+	}
+	var sum models.Money
+	for _, p := range e.Postings {
+		if p.Amount == 0 {
+			return fmt.Errorf("posting to %s has zero amount", p.Account)
+		}
+		sum += p.Amount
+	}
+	if sum != 0 {
+		return fmt.Errorf("entry is not balanced: postings sum to %s", sum)
+	}
+	return nil
+}
+
+// Accrual credits points for a processed order.
+func Accrual(userID int64, orderNumber string, amount models.Money) Entry {
+	return Entry{
+		Kind:      KindAccrual,
+		Reference: orderNumber,
+		Postings: []Posting{
+			{Account: UserAccount(userID), Amount: amount},
+			{Account: AccountAccrual, Amount: -amount},
+		},
+	}
+}
+
+// Withdrawal debits points spent on an order.
+func Withdrawal(userID int64, orderNumber string, amount models.Money) Entry {
+	return Entry{
+		Kind:      KindWithdrawal,
+		Reference: orderNumber,
+		Postings: []Posting{
+			{Account: UserAccount(userID), Amount: -amount},
+			{Account: AccountWithdrawals, Amount: amount},
+		},
+	}
+}
+
+// Expiry debits points of a lot earned for the order that were not spent
+// in time.
+func Expiry(userID int64, orderNumber string, amount models.Money) Entry {
+	return Entry{
+		Kind:        KindExpiry,
+		Reference:   orderNumber,
+		Description: "points expired",
+		Postings: []Posting{
+			{Account: UserAccount(userID), Amount: -amount},
+			{Account: AccountExpired, Amount: amount},
+		},
+	}
+}
+
+// Bonus credits points granted by a campaign. The reference names the
+// campaign.
+func Bonus(userID int64, reference, description string, amount models.Money) Entry {
+	return Entry{
+		Kind:        KindBonus,
+		Reference:   reference,
+		Description: description,
+		Postings: []Posting{
+			{Account: UserAccount(userID), Amount: amount},
+			{Account: AccountCampaigns, Amount: -amount},
+		},
+	}
+}
+
+// Adjustment credits (positive amount) or debits (negative amount) a user
+// outside of the order flow.
+func Adjustment(userID int64, reference, description string, amount models.Money) Entry {
+	return Entry{
+		Kind:        KindAdjustment,
+		Reference:   reference,
+		Description: description,
+		Postings: []Posting{
+			{Account: UserAccount(userID), Amount: amount},
+			{Account: AccountAdjustments, Amount: -amount},
+		},
+	}
+}
+
+// Reversal cancels the original entry with mirrored postings.
+func Reversal(original Entry, description string) Entry {
+	postings := make([]Posting, 0, len(original.Postings))
+	for _, p := range original.Postings {
+		postings = append(postings, Posting{Account: p.Account, Amount: -p.Amount})
+	}
+	return Entry{
+		Kind:        KindReversal,
+		Reference:   original.Reference,
+		Description: description,
+		Reverses:    original.ID,
+		Postings:    postings,
+	}
+}
+
+// PartialReversal cancels amount of a two-posting entry, which is what
+// every entry made outside of backfill is. Reversals of the same entry
+// must not add up to more than it.
+func PartialReversal(original Entry, amount models.Money, description string) Entry {
+	postings := make([]Posting, 0, len(original.Postings))
+	for _, p := range original.Postings {
+		reversed := amount
+		if p.Amount > 0 {
+			reversed = -amount
+		}
+		postings = append(postings, Posting{Account: p.Account, Amount: reversed})
+	}
+	return Entry{
+		Kind:        KindReversal,
+		Reference:   original.Reference,
+		Description: description,
+		Reverses:    original.ID,
+		Postings:    postings,
+	}
+}
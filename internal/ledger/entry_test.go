@@ -0,0 +1,83 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+func TestEntryValidate(t *testing.T) {
+	accrual := Accrual(1, "12345678903", 72998)
+	accrual.ID = 7
+	tests := []struct {
+		name  string
+		entry Entry
+		valid bool
+	}{
+		{name: "accrual", entry: accrual, valid: true},
+		{name: "withdrawal", entry: Withdrawal(1, "2377225624", 500), valid: true},
+		{name: "debit adjustment", entry: Adjustment(1, "ticket-1", "goodwill", -100), valid: true},
+		{name: "expiry", entry: Expiry(1, "12345678903", 1), valid: true},
+		{name: "bonus", entry: Bonus(1, "campaign:1", "spring", 2525), valid: true},
+		{name: "reversal", entry: Reversal(accrual, "fraud"), valid: true},
+		{name: "partial reversal", entry: PartialReversal(accrual, 100, "refund"), valid: true},
+		{name: "zero amount", entry: Accrual(1, "12345678903", 0)},
+		{name: "single posting", entry: Entry{
+			Kind:     KindAdjustment,
+			Postings: []Posting{{Account: UserAccount(1), Amount: 100}},
+		}},
+		{name: "unbalanced", entry: Entry{
+			Kind: KindAdjustment,
+			Postings: []Posting{
+				{Account: UserAccount(1), Amount: 100},
+				{Account: AccountAdjustments, Amount: -99},
+			},
+		}},
+		{name: "no postings", entry: Entry{Kind: KindAccrual}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.entry.Validate()
+			if tt.valid && err != nil {
+				t.Fatalf("got %v, want valid", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("got valid, want error")
+			}
+		})
+	}
+}
+
+func TestReversal(t *testing.T) {
+	accrual := Accrual(1, "12345678903", 72998)
+	accrual.ID = 7
+
+	partial := PartialReversal(accrual, 100, "refund")
+	if partial.Reverses != 7 || partial.Kind != KindReversal {
+		t.Fatalf("got %+v", partial)
+	}
+	want := map[string]models.Money{UserAccount(1): -100, AccountAccrual: 100}
+	for _, p := range partial.Postings {
+		if want[p.Account] != p.Amount {
+			t.Errorf("posting to %s is %s, want %s", p.Account, p.Amount, want[p.Account])
+		}
+	}
+
+	full := Reversal(accrual, "fraud")
+	for i, p := range full.Postings {
+		if p.Account != accrual.Postings[i].Account || p.Amount != -accrual.Postings[i].Amount {
+			t.Errorf("posting %d is %+v, want mirror of %+v", i, p, accrual.Postings[i])
+		}
+	}
+}
+
+func TestParseUserAccount(t *testing.T) {
+	if userID, ok := ParseUserAccount(UserAccount(42)); !ok || userID != 42 {
+		t.Fatalf("got %d, %v, want 42", userID, ok)
+	}
+	for _, code := range []string{AccountAccrual, "user:", "user:abc", "42"} {
+		if _, ok := ParseUserAccount(code); ok {
+			t.Errorf("%q was parsed as a user account", code)
+		}
+	}
+}
@@ -3,38 +3,145 @@ package middleware
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
 
-	"github.com/golang-jwt/jwt"
 	"github.com/thalq/gopher_mart/internal/constants"
 	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/tokens"
 )
 
-func AuthMiddleware(jwtSecret string) func(next http.Handler) http.Handler {
+const bearerRealm = `Bearer realm="gophermart"`
+
+type authErrorKey struct{}
+
+// authError is why a token was rejected. It is reported by RequireAuth.
+type authError struct {
+	code        string
+	description string
+}
+
+// SessionValidator reports whether the session a token was issued for is
+// still active.
+type SessionValidator interface {
+	SessionActive(ctx context.Context, claims *models.Claims) (bool, error)
+}
+
+// AuthMiddleware reads the token from the Authorization: Bearer header or,
+// for browsers, from the Authorization cookie, and puts the user into the
+// request context. Requests without a valid token pass through
+// unauthenticated, so an expired cookie does not block login or refresh;
+// RequireAuth rejects them on protected routes.
+func AuthMiddleware(keys *tokens.KeySet, sessions SessionValidator) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString, err := tokenFromRequest(r)
+			if err != nil {
+				next.ServeHTTP(w, withAuthError(r, "invalid_request", err.Error()))
+				return
+			}
+			if tokenString == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims := &models.Claims{}
+			token, err := keys.Parse(tokenString, claims)
+			if err != nil || !token.Valid {
+				next.ServeHTTP(w, withAuthError(r, "invalid_token", "Token is not valid"))
+				return
+			}
+			if claims.Scope != "" {
+				next.ServeHTTP(w, withAuthError(r, "invalid_token", "Token is limited to "+claims.Scope))
+				return
+			}
+			active, err := sessions.SessionActive(r.Context(), claims)
+			if err != nil {
+				http.Error(w, "Failed to check session", http.StatusInternalServerError)
+				return
+			}
+			if !active {
+				next.ServeHTTP(w, withAuthError(r, "invalid_token", "Session is revoked"))
+				return
+			}
+			ctx := context.WithValue(r.Context(), constants.UserIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, constants.SessionIDKey, claims.SessionID)
+			ctx = context.WithValue(ctx, constants.RoleKey, claims.Role)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func withAuthError(r *http.Request, code, description string) *http.Request {
+	ctx := context.WithValue(r.Context(), authErrorKey{}, authError{code: code, description: description})
+	return r.WithContext(ctx)
+}
+
+// RequireAuth answers 401 to requests that AuthMiddleware did not
+// authenticate.
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(constants.UserIDKey).(int64); !ok {
+			if authErr, ok := r.Context().Value(authErrorKey{}).(authError); ok {
+				Unauthorized(w, authErr.code, authErr.description)
+			} else {
+				Unauthorized(w, "", "User unauthorized")
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole answers 403 unless the authenticated user has one of the
+// roles. Use it after RequireAuth.
+func RequireRole(roles ...string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			tokenString, err := r.Cookie("Authorization")
-			if err == nil {
-				claims := &models.Claims{}
-				token, err := jwt.ParseWithClaims(tokenString.Value, claims,
-					func(t *jwt.Token) (interface{}, error) {
-						if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-							return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-						}
-						return []byte(jwtSecret), nil
-					})
-				if err != nil {
-					http.Error(w, "Failed to parse token", http.StatusInternalServerError)
-					return
-				}
-				if !token.Valid {
-					http.Error(w, "Token is not valid", http.StatusUnauthorized)
-					return
-				}
-				ctx := context.WithValue(r.Context(), constants.UserIDKey, claims.UserID)
-				r = r.WithContext(ctx)
+			role, _ := r.Context().Value(constants.RoleKey).(string)
+			if !slices.Contains(roles, role) {
+				w.Header().Set("WWW-Authenticate", bearerRealm+`, error="insufficient_scope"`)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
 			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// Unauthorized writes 401 with a WWW-Authenticate challenge as described in
+// RFC 6750. errorCode is empty when no token was sent.
+func Unauthorized(w http.ResponseWriter, errorCode, description string) {
+	challenge := bearerRealm
+	if errorCode != "" {
+		challenge += fmt.Sprintf(`, error=%q, error_description=%q`, errorCode, description)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, description, http.StatusUnauthorized)
+}
+
+func tokenFromRequest(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return "", fmt.Errorf("Authorization header must be Bearer <token>")
+		}
+		return strings.TrimSpace(token), nil
+	}
+	if cookie, err := r.Cookie("Authorization"); err == nil {
+		return cookie.Value, nil
+	}
+	return "", nil
+}
+
+// ClientIP is the remote address. Behind a proxy enable TRUST_PROXY_HEADERS
+// so it comes from X-Forwarded-For or X-Real-IP.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
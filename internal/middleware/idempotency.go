@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	chiMiddleware "github.com/go-chi/chi/middleware"
+	"github.com/thalq/gopher_mart/internal/constants"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// Idempotency makes a request with an Idempotency-Key header safe to
+// retry. The first request with a key is served and its response stored;
+// a retry with the same body gets the stored response, a retry with a
+// different body gets 422 and one arriving while the first is still
+// running gets 409. Responses with 5xx are not stored: nothing was done,
+// so the key is released. Keys are per user and live for ttl. A request
+// that never finished, because its process died, holds its key only for
+// lease; a retry after that takes the key over. Use it after RequireAuth.
+func Idempotency(store repository.Store, ttl, lease time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			userID, ok := r.Context().Value(constants.UserIDKey).(int64)
+			if key == "" || !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			claim := make([]byte, 16)
+			if _, err := rand.Read(claim); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			now := time.Now()
+			record, claimed, err := store.Idempotency().Begin(r.Context(), models.IdempotencyRecord{
+				UserID:      userID,
+				Key:         key,
+				Fingerprint: fingerprint(r, body),
+				CreatedAt:   now,
+				Claim:       hex.EncodeToString(claim),
+				LockedUntil: now.Add(lease),
+			}, now.Add(-ttl))
+			if err != nil {
+				Sugar.Errorf("Failed to claim idempotency key: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !claimed {
+				replay(w, r, record, body)
+				return
+			}
+			serveClaimed(w, r, next, store, record)
+		})
+	}
+}
+
+func replay(w http.ResponseWriter, r *http.Request, record models.IdempotencyRecord, body []byte) {
+	switch {
+	case record.Fingerprint != fingerprint(r, body):
+		http.Error(w, "Idempotency-Key was used for a different request", http.StatusUnprocessableEntity)
+	case !record.Completed():
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+	default:
+		if record.ContentType != "" {
+			w.Header().Set("Content-Type", record.ContentType)
+		}
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		w.WriteHeader(record.StatusCode)
+		w.Write(record.Body)
+	}
+}
+
+func serveClaimed(w http.ResponseWriter, r *http.Request, next http.Handler, store repository.Store, record models.IdempotencyRecord) {
+	// The outcome is saved even when the client has gone away: that is
+	// exactly when it is going to retry.
+	ctx := context.WithoutCancel(r.Context())
+	release := func() {
+		if err := store.Idempotency().Delete(ctx, record.UserID, record.Key, record.Claim); err != nil {
+			Sugar.Errorf("Failed to release idempotency key: %v", err)
+		}
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			release()
+			panic(rec)
+		}
+	}()
+
+	var response bytes.Buffer
+	ww := chiMiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	ww.Tee(&response)
+	next.ServeHTTP(ww, r)
+
+	status := ww.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status >= http.StatusInternalServerError {
+		release()
+		return
+	}
+	err := store.Idempotency().Complete(ctx, record.UserID, record.Key, record.Claim, status, ww.Header().Get("Content-Type"), response.Bytes())
+	if err != nil {
+		Sugar.Errorf("Failed to store idempotent response: %v", err)
+	}
+}
+
+// fingerprint identifies the request a key was used for.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
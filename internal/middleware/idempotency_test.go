@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/constants"
+	"github.com/thalq/gopher_mart/internal/repository/memory"
+)
+
+func TestMain(m *testing.M) {
+	InitLogger()
+	os.Exit(m.Run())
+}
+
+// countingHandler answers status with the number of the call as body.
+// While gate is set, calls wait for it to be closed.
+type countingHandler struct {
+	calls  atomic.Int32
+	status atomic.Int32
+	gate   chan struct{}
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	call := h.calls.Add(1)
+	if h.gate != nil && call == 1 {
+		<-h.gate
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(int(h.status.Load()))
+	fmt.Fprintf(w, "call %d", call)
+}
+
+func idempotent(handler http.Handler, lease time.Duration) http.Handler {
+	return Idempotency(memory.New(), time.Hour, lease)(handler)
+}
+
+func serve(h http.Handler, userID int64, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), constants.UserIDKey, userID))
+	if key != "" {
+		r.Header.Set(IdempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func expectResponse(t *testing.T, w *httptest.ResponseRecorder, status int, body string, replayed bool) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("got status %d, want %d", w.Code, status)
+	}
+	if body != "" && w.Body.String() != body {
+		t.Fatalf("got body %q, want %q", w.Body.String(), body)
+	}
+	if got := w.Header().Get(IdempotentReplayedHeader) == "true"; got != replayed {
+		t.Fatalf("got replayed %t, want %t", got, replayed)
+	}
+}
+
+func TestIdempotencyReplays(t *testing.T) {
+	handler := &countingHandler{}
+	handler.status.Store(http.StatusOK)
+	h := idempotent(handler, time.Minute)
+
+	expectResponse(t, serve(h, 1, "key-1", `{"sum":1}`), http.StatusOK, "call 1", false)
+	replayed := serve(h, 1, "key-1", `{"sum":1}`)
+	expectResponse(t, replayed, http.StatusOK, "call 1", true)
+	if replayed.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("got content type %q", replayed.Header().Get("Content-Type"))
+	}
+	// A different body under the same key is a client bug.
+	expectResponse(t, serve(h, 1, "key-1", `{"sum":2}`), http.StatusUnprocessableEntity, "", false)
+	// Keys are per user, and requests without a key are not tracked.
+	expectResponse(t, serve(h, 2, "key-1", `{"sum":1}`), http.StatusOK, "call 2", false)
+	expectResponse(t, serve(h, 1, "", `{"sum":1}`), http.StatusOK, "call 3", false)
+	expectResponse(t, serve(h, 1, "", `{"sum":1}`), http.StatusOK, "call 4", false)
+	expectResponse(t, serve(h, 1, strings.Repeat("k", 256), `{"sum":1}`), http.StatusBadRequest, "", false)
+	if calls := handler.calls.Load(); calls != 4 {
+		t.Fatalf("handler was called %d times, want 4", calls)
+	}
+}
+
+func TestIdempotencyReleasesServerErrors(t *testing.T) {
+	handler := &countingHandler{}
+	handler.status.Store(http.StatusServiceUnavailable)
+	h := idempotent(handler, time.Minute)
+
+	expectResponse(t, serve(h, 1, "key-1", `{"sum":1}`), http.StatusServiceUnavailable, "call 1", false)
+	handler.status.Store(http.StatusOK)
+	expectResponse(t, serve(h, 1, "key-1", `{"sum":1}`), http.StatusOK, "call 2", false)
+	expectResponse(t, serve(h, 1, "key-1", `{"sum":1}`), http.StatusOK, "call 2", true)
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	handler := &countingHandler{gate: make(chan struct{})}
+	handler.status.Store(http.StatusOK)
+	h := idempotent(handler, time.Minute)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var first *httptest.ResponseRecorder
+	go func() {
+		defer wg.Done()
+		first = serve(h, 1, "key-1", `{"sum":1}`)
+	}()
+	for handler.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	busy := serve(h, 1, "key-1", `{"sum":1}`)
+	expectResponse(t, busy, http.StatusConflict, "", false)
+	if busy.Header().Get("Retry-After") == "" {
+		t.Fatal("conflict has no Retry-After header")
+	}
+	close(handler.gate)
+	wg.Wait()
+	expectResponse(t, first, http.StatusOK, "call 1", false)
+	expectResponse(t, serve(h, 1, "key-1", `{"sum":1}`), http.StatusOK, "call 1", true)
+}
+
+func TestIdempotencyLeaseExpires(t *testing.T) {
+	handler := &countingHandler{gate: make(chan struct{})}
+	handler.status.Store(http.StatusOK)
+	// With no lease a stuck request holds its key for no time at all.
+	h := idempotent(handler, 0)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var stuck *httptest.ResponseRecorder
+	go func() {
+		defer wg.Done()
+		stuck = serve(h, 1, "key-1", `{"sum":1}`)
+	}()
+	for handler.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	expectResponse(t, serve(h, 1, "key-1", `{"sum":1}`), http.StatusOK, "call 2", false)
+	close(handler.gate)
+	wg.Wait()
+	expectResponse(t, stuck, http.StatusOK, "call 1", false)
+	// The late request does not overwrite the response of the one that
+	// took its key over.
+	expectResponse(t, serve(h, 1, "key-1", `{"sum":1}`), http.StatusOK, "call 2", true)
+}
@@ -63,3 +63,10 @@ func InitLogger() {
 	defer logger.Sync()
 	Sugar = logger.Sugar()
 }
+
+// SyncLogger flushes buffered log entries.
+func SyncLogger() {
+	if Sugar != nil {
+		Sugar.Sync()
+	}
+}
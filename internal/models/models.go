@@ -6,38 +6,423 @@ import (
 	"github.com/golang-jwt/jwt"
 )
 
+const (
+	OrderStatusNew        = "NEW"
+	OrderStatusProcessing = "PROCESSING"
+	OrderStatusInvalid    = "INVALID"
+	OrderStatusProcessed  = "PROCESSED"
+
+	AccrualStatusRegistered = "REGISTERED"
+)
+
+// Roles, from the least to the most privileged. Support staff can look
+// into accounts and lock them, admins can also manage roles.
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+func ValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleSupport, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 type Claims struct {
 	jwt.StandardClaims
-	UserID int64 `json:"user_id"`
+	UserID    int64  `json:"user_id"`
+	SessionID string `json:"sid"`
+	// Scope limits what the token is good for. Access tokens have none;
+	// ScopeTwoFactor tokens only complete a login.
+	Scope string `json:"scope,omitempty"`
+	Role  string `json:"role,omitempty"`
+}
+
+const ScopeTwoFactor = "2fa"
+
+// Session groups the access and refresh tokens issued from one login.
+// Revoking it invalidates every token of the family.
+type Session struct {
+	ID           string
+	UserID       int64
+	CreatedAt    time.Time
+	RevokedAt    time.Time
+	RevokeReason string
+}
+
+func (s Session) Revoked() bool {
+	return !s.RevokedAt.IsZero()
+}
+
+// RefreshToken is stored by the SHA-256 hash of its value. Each token can
+// be exchanged once; UsedAt is set when it is.
+type RefreshToken struct {
+	Hash      string
+	SessionID string
+	UserID    int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    time.Time
+}
+
+// LoginAttempts counts recent failed logins for one key: a login or a
+// client IP.
+type LoginAttempts struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// AttemptLimits say when a key stops accepting attempts. Failures older
+// than Lockout are forgotten.
+type AttemptLimits struct {
+	// MaxFailures blocks the key for Lockout after that many failures;
+	// 0 means no limit.
+	MaxFailures int
+	// Backoff blocks the key for Backoff * 2^(n-1) after the n-th
+	// failure, but never longer than Lockout; 0 means no backoff.
+	Backoff time.Duration
+	Lockout time.Duration
+}
+
+// BlockedUntil returns when the key accepts attempts again under limits.
+func (a LoginAttempts) BlockedUntil(limits AttemptLimits) time.Time {
+	until := a.LockedUntil
+	if a.Failures == 0 {
+		return until
+	}
+	var blocked time.Time
+	if limits.MaxFailures > 0 && a.Failures >= limits.MaxFailures {
+		blocked = a.LastFailureAt.Add(limits.Lockout)
+	} else if limits.Backoff > 0 {
+		backoff := limits.Backoff
+		for i := 1; i < a.Failures && backoff < limits.Lockout; i++ {
+			backoff *= 2
+		}
+		blocked = a.LastFailureAt.Add(min(backoff, limits.Lockout))
+	}
+	if blocked.After(until) {
+		until = blocked
+	}
+	return until
+}
+
+// AuditEvent records a security relevant action. ActorID is 0 for actions
+// taken by the system itself.
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ActorID   int64     `json:"actor_id,omitempty"`
+	Action    string    `json:"action"`
+	Subject   string    `json:"subject"`
+	IP        string    `json:"ip,omitempty"`
+	Details   string    `json:"details,omitempty"`
+}
+
+// PasswordResetToken is stored by the SHA-256 hash of its value.
+type PasswordResetToken struct {
+	Hash      string
+	UserID    int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    time.Time
+}
+
+type AuditFilter struct {
+	Subject string
+	ActorID int64
+	Limit   int
+}
+
+// Adjustment statuses. An adjustment is applied when it is approved.
+const (
+	AdjustmentPending  = "PENDING"
+	AdjustmentApproved = "APPROVED"
+	AdjustmentRejected = "REJECTED"
+)
+
+// Adjustment reason codes.
+const (
+	ReasonGoodwill   = "GOODWILL"
+	ReasonFraud      = "FRAUD"
+	ReasonCorrection = "CORRECTION"
+)
+
+func ValidReasonCode(code string) bool {
+	switch code {
+	case ReasonGoodwill, ReasonFraud, ReasonCorrection:
+		return true
+	}
+	return false
+}
+
+// Adjustment is a manual credit (positive amount) or debit (negative
+// amount) proposed by one admin and decided by another.
+type Adjustment struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Amount     Money      `json:"amount"`
+	ReasonCode string     `json:"reason_code"`
+	Comment    string     `json:"comment,omitempty"`
+	Status     string     `json:"status"`
+	ProposedBy int64      `json:"proposed_by"`
+	ProposedAt time.Time  `json:"proposed_at"`
+	DecidedBy  int64      `json:"decided_by,omitempty"`
+	DecidedAt  *time.Time `json:"decided_at,omitempty"`
+	// EntryID is the ledger entry that applied an approved adjustment.
+	EntryID int64 `json:"entry_id,omitempty"`
+}
+
+// Campaign is a time-boxed promotion. An order that becomes PROCESSED
+// while the campaign runs and meets its conditions earns a bonus of Fixed
+// plus Rate times the accrual, within the budgets. Zero conditions and
+// budgets do not limit anything.
+type Campaign struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`
+	Active   bool      `json:"active"`
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+
+	// MinAccrual is exclusive: only orders with a larger accrual qualify.
+	MinAccrual Money `json:"min_accrual,omitempty"`
+	// FirstOrder limits the campaign to the first credited order of a user.
+	FirstOrder bool `json:"first_order,omitempty"`
+
+	Fixed Money       `json:"fixed,omitempty"`
+	Rate  BasisPoints `json:"rate,omitempty"`
+
+	// UserBudget caps bonuses of a single user, Budget caps all of them.
+	UserBudget Money `json:"user_budget,omitempty"`
+	Budget     Money `json:"budget,omitempty"`
+	Spent      Money `json:"spent"`
+
+	CreatedBy int64     `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Running reports whether the campaign applies to orders processed at
+// the time.
+func (c Campaign) Running(at time.Time) bool {
+	return c.Active && !at.Before(c.StartsAt) && at.Before(c.EndsAt)
+}
+
+// Bonus is the reward for an order with the accrual before budgets.
+func (c Campaign) Bonus(accrual Money) Money {
+	return c.Fixed + accrual.Mul(c.Rate)
+}
+
+// CampaignAward is a bonus credited by a campaign for an order.
+type CampaignAward struct {
+	ID         int64     `json:"id"`
+	CampaignID int64     `json:"campaign_id"`
+	UserID     int64     `json:"user_id"`
+	Order      string    `json:"order"`
+	Amount     Money     `json:"amount"`
+	EntryID    int64     `json:"entry_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// HistoryItem is a ledger entry as seen by the user: Amount is the change
+// of their balance.
+type HistoryItem struct {
+	ID          int64     `json:"id"`
+	Kind        string    `json:"kind"`
+	Reference   string    `json:"reference,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Amount      Money     `json:"amount"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IdempotencyRecord remembers a request made with an Idempotency-Key and,
+// once it is complete, its response. StatusCode is 0 while in progress.
+type IdempotencyRecord struct {
+	UserID      int64
+	Key         string
+	Fingerprint string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	// Claim identifies the request that holds the key. An unfinished
+	// claim can be taken over by a retry after LockedUntil.
+	Claim       string
+	LockedUntil time.Time
+}
+
+func (r IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type User struct {
+	ID           int64  `db:"id" json:"id"`
+	Login        string `db:"username" json:"login"`
+	PasswordHash string `db:"password" json:"-"`
+	// TOTPSecret is set on enrollment and used once TOTPEnabled is true.
+	TOTPSecret   string `db:"totp_secret" json:"-"`
+	TOTPEnabled  bool   `db:"totp_enabled" json:"totp_enabled"`
+	TOTPLastStep int64  `db:"totp_last_step" json:"-"`
+	Role         string `db:"role" json:"role"`
+	// LockedAt is set while an admin keeps the account locked.
+	LockedAt   time.Time `db:"locked_at" json:"-"`
+	LockReason string    `db:"lock_reason" json:"-"`
+}
+
+func (u User) Locked() bool {
+	return !u.LockedAt.IsZero()
 }
 
 type Order struct {
+	UserID     int64     `db:"user_id" json:"-"`
 	Number     string    `db:"order_id" json:"number"`
 	Status     string    `db:"status" json:"status"`
 	UploadedAt time.Time `db:"uploaded_at" json:"uploaded_at"`
-	Accrual    float32   `db:"accrual" json:"accrual,omitempty"`
+	Accrual    Money     `db:"accrual" json:"accrual,omitempty"`
+	// Reversed is the part of Accrual taken back after a return.
+	Reversed       Money  `db:"reversed" json:"reversed,omitempty"`
+	ReversalStatus string `db:"-" json:"reversal_status,omitempty"`
+}
+
+// Reversal statuses of orders and withdrawals. Nothing reversed has none.
+const (
+	ReversalPartial = "PARTIAL"
+	ReversalFull    = "FULL"
+)
+
+func ReversalStatus(total, reversed Money) string {
+	switch {
+	case reversed <= 0:
+		return ""
+	case reversed < total:
+		return ReversalPartial
+	default:
+		return ReversalFull
+	}
 }
 
+// Balance is what the user has. Held points are reserved by holds: they
+// are still part of Current but can not be spent, Available can.
 type Balance struct {
-	Current   float32 `json:"current"`
-	Withdrawn float32 `json:"withdrawn"`
+	Current   Money `json:"current"`
+	Withdrawn Money `json:"withdrawn"`
+	Held      Money `json:"held"`
+	Available Money `json:"available"`
+	// Expiring lists points that expire soon, earliest first.
+	Expiring []ExpiringPoints `json:"expiring,omitempty"`
+}
+
+type ExpiringPoints struct {
+	Sum       Money     `json:"sum"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Tier is a loyalty level reached by accruing Threshold points within the
+// last twelve months. Accruals of its members are multiplied by
+// Multiplier.
+type Tier struct {
+	Name       string      `json:"name"`
+	Threshold  Money       `json:"threshold"`
+	Multiplier BasisPoints `json:"multiplier"`
+}
+
+// Apply multiplies an accrual, rounding to the minor unit.
+func (t Tier) Apply(accrual Money) Money {
+	return accrual.Mul(t.Multiplier)
+}
+
+// TierChange is an entry of the tier history of a user. Accrued is what
+// the user had accrued over the twelve months before the change.
+type TierChange struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	Tier      string    `json:"tier"`
+	Accrued   Money     `json:"accrued"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// TierStatus is the current tier of a user and how far the next one is.
+type TierStatus struct {
+	Tier       string      `json:"tier"`
+	Multiplier BasisPoints `json:"multiplier"`
+	Accrued    Money       `json:"accrued"`
+	// The next tier is empty at the top tier.
+	NextTier      string `json:"next_tier,omitempty"`
+	NextThreshold Money  `json:"next_threshold,omitempty"`
+	Remaining     Money  `json:"remaining,omitempty"`
+	// Progress is the share of the way from the current threshold to the
+	// next one, from 0 to 1.
+	Progress float64 `json:"progress"`
+}
+
+// Lot is a dated portion of accrued points. Spending takes points from
+// the oldest lots first, and what is left of a lot expires a fixed time
+// after it was earned.
+type Lot struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"-"`
+	Order     string     `json:"order"`
+	Amount    Money      `json:"amount"`
+	Remaining Money      `json:"remaining"`
+	EarnedAt  time.Time  `json:"earned_at"`
+	ExpiredAt *time.Time `json:"expired_at,omitempty"`
+}
+
+// Hold statuses. Only HELD holds reserve points.
+const (
+	HoldActive   = "HELD"
+	HoldCaptured = "CAPTURED"
+	HoldVoided   = "VOIDED"
+	HoldExpired  = "EXPIRED"
+)
+
+// Hold reserves points for a withdrawal until it is captured, voided or
+// expires.
+type Hold struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"-"`
+	Order     string     `json:"order"`
+	Sum       Money      `json:"sum"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+}
+
+// Active reports whether the hold still reserves points at the time.
+func (h Hold) Active(at time.Time) bool {
+	return h.Status == HoldActive && at.Before(h.ExpiresAt)
 }
 
 type WithdrawRequest struct {
-	Order string  `json:"order"`
-	Sum   float32 `json:"sum"`
+	Order string `json:"order"`
+	Sum   Money  `json:"sum"`
 }
 
 type WithdrawResponse struct {
+	UserID      int64     `json:"-"`
 	OrderID     string    `json:"order"`
-	Sum         float32   `json:"sum"`
+	Sum         Money     `json:"sum"`
 	ProcessedAt time.Time `json:"processed_at"`
+	// Refunded is the part of Sum given back.
+	Refunded     Money  `json:"refunded,omitempty"`
+	RefundStatus string `json:"refund_status,omitempty"`
 }
 
 type AccrualInfo struct {
-	OrderID string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float32 `json:"accrual"`
+	OrderID string `json:"order"`
+	Status  string `json:"status"`
+	Accrual Money  `json:"accrual"`
 }
 
 func (a *AccrualInfo) SetDefaults(orderID string) {
@@ -45,6 +430,18 @@ func (a *AccrualInfo) SetDefaults(orderID string) {
 		a.Status = "NEW"
 	}
 	if a.Accrual == 0 {
-		a.Accrual = 0.0
+		a.Accrual = 0
+	}
+}
+
+// OrderStatus maps accrual system status onto the order status stored in db.
+func (a *AccrualInfo) OrderStatus() string {
+	switch a.Status {
+	case AccrualStatusRegistered:
+		return OrderStatusProcessing
+	case OrderStatusProcessing, OrderStatusInvalid, OrderStatusProcessed:
+		return a.Status
+	default:
+		return OrderStatusNew
 	}
 }
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MoneyScale is the number of minor units in one point.
+const MoneyScale = 100
+
+// Money is an amount of points stored in minor units (hundredths), so
+// arithmetic on balances is exact. In JSON and SQL it is a decimal number
+// with at most two fractional digits.
+type Money int64
+
+// moneyPattern is the only accepted form: an optional minus, digits, and at
+// most two fractional digits. Exponents, fractions and extra digits are
+// rejected rather than rounded, so an amount is never changed silently.
+var moneyPattern = regexp.MustCompile(`^-?\d+(\.\d{1,2})?$`)
+
+// ParseMoney parses a decimal number like "729.98".
+func ParseMoney(value string) (Money, error) {
+	value = strings.TrimSpace(value)
+	if !moneyPattern.MatchString(value) {
+		return 0, fmt.Errorf("invalid money value %q", value)
+	}
+	whole, fraction, _ := strings.Cut(strings.TrimPrefix(value, "-"), ".")
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > math.MaxInt64/MoneyScale-1 {
+		return 0, fmt.Errorf("money value %q is out of range", value)
+	}
+	units *= MoneyScale
+	if fraction != "" {
+		cents, _ := strconv.ParseInt(fraction, 10, 64)
+		if len(fraction) == 1 {
+			cents *= 10
+		}
+		units += cents
+	}
+	if strings.HasPrefix(value, "-") {
+		units = -units
+	}
+	return Money(units), nil
+}
+
+func (m Money) String() string {
+	sign := ""
+	units := int64(m)
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	whole := strconv.FormatUint(uint64(units)/MoneyScale, 10)
+	cents := uint64(units) % MoneyScale
+	if cents == 0 {
+		return sign + whole
+	}
+	return strings.TrimRight(fmt.Sprintf("%s%s.%02d", sign, whole, cents), "0")
+}
+
+// Float64 is meant for metrics and logs only, never for arithmetic.
+func (m Money) Float64() float64 {
+	return float64(m) / MoneyScale
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if value == "null" {
+		return nil
+	}
+	parsed, err := ParseMoney(strings.Trim(value, `"`))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads NUMERIC columns. NULL is scanned as zero, so aggregates like
+// SUM over no rows need no special handling.
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v * MoneyScale)
+	case float64:
+		*m = Money(math.Round(v * MoneyScale))
+	case []byte:
+		return m.Scan(string(v))
+	case string:
+		parsed, err := ParseMoney(v)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	default:
+		return fmt.Errorf("can not scan %T into Money", src)
+	}
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// BasisPointsScale is the number of basis points in a ratio of one.
+const BasisPointsScale = 10000
+
+// BasisPoints is a ratio in hundredths of a percent, so 12500 is 1.25. It is
+// used for multipliers and rates, which keeps Money arithmetic in integers.
+// In JSON it is a decimal number with at most four fractional digits.
+type BasisPoints int64
+
+var basisPointsPattern = regexp.MustCompile(`^\d+(\.\d{1,4})?$`)
+
+// ParseBasisPoints parses a non-negative ratio like "1.25" or "0.05".
+func ParseBasisPoints(value string) (BasisPoints, error) {
+	value = strings.TrimSpace(value)
+	if !basisPointsPattern.MatchString(value) {
+		return 0, fmt.Errorf("invalid ratio %q", value)
+	}
+	whole, fraction, _ := strings.Cut(value, ".")
+	points, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || points > math.MaxInt64/BasisPointsScale-1 {
+		return 0, fmt.Errorf("ratio %q is out of range", value)
+	}
+	points *= BasisPointsScale
+	if fraction != "" {
+		part, _ := strconv.ParseInt(fraction+strings.Repeat("0", 4-len(fraction)), 10, 64)
+		points += part
+	}
+	return BasisPoints(points), nil
+}
+
+func (b BasisPoints) String() string {
+	whole := strconv.FormatInt(int64(b)/BasisPointsScale, 10)
+	fraction := int64(b) % BasisPointsScale
+	if fraction == 0 {
+		return whole
+	}
+	return strings.TrimRight(fmt.Sprintf("%s.%04d", whole, fraction), "0")
+}
+
+func (b BasisPoints) MarshalJSON() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+func (b *BasisPoints) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if value == "null" {
+		return nil
+	}
+	parsed, err := ParseBasisPoints(strings.Trim(value, `"`))
+	if err != nil {
+		return err
+	}
+	*b = parsed
+	return nil
+}
+
+// Mul multiplies m by a ratio, rounding half away from zero to the minor
+// unit.
+func (m Money) Mul(b BasisPoints) Money {
+	units, sign := int64(m), int64(1)
+	if units < 0 {
+		units, sign = -units, -1
+	}
+	whole, rest := units/BasisPointsScale, units%BasisPointsScale
+	product := whole*int64(b) + (rest*int64(b)+BasisPointsScale/2)/BasisPointsScale
+	return Money(sign * product)
+}
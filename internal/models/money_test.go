@@ -0,0 +1,131 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Money
+		err   bool
+	}{
+		{value: "0", want: 0},
+		{value: "729.98", want: 72998},
+		{value: "729.9", want: 72990},
+		{value: "729", want: 72900},
+		{value: " 42.5 ", want: 4250},
+		{value: "-0.01", want: -1},
+		{value: "-15.5", want: -1550},
+		{value: "92233720368547758", err: true},
+		{value: "0.001", err: true},
+		{value: "1.999", err: true},
+		{value: "1e3", err: true},
+		{value: "1.", err: true},
+		{value: ".5", err: true},
+		{value: "+1", err: true},
+		{value: "1,5", err: true},
+		{value: "NaN", err: true},
+		{value: "", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseMoney(%q) = %s, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %s, %v, want %s", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{money: 0, want: "0"},
+		{money: 72998, want: "729.98"},
+		{money: 72990, want: "729.9"},
+		{money: 72900, want: "729"},
+		{money: 5, want: "0.05"},
+		{money: -1550, want: "-15.5"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.money), got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var request struct {
+		Sum Money `json:"sum"`
+	}
+	if err := json.Unmarshal([]byte(`{"sum": 751.5}`), &request); err != nil || request.Sum != 75150 {
+		t.Fatalf("got %s, %v, want 751.5", request.Sum, err)
+	}
+	if err := json.Unmarshal([]byte(`{"sum": 0.125}`), &request); err == nil {
+		t.Fatal("0.125 was accepted")
+	}
+	data, err := json.Marshal(request)
+	if err != nil || string(data) != `{"sum":751.5}` {
+		t.Fatalf("got %s, %v", data, err)
+	}
+}
+
+func TestParseBasisPoints(t *testing.T) {
+	tests := []struct {
+		value string
+		want  BasisPoints
+		err   bool
+	}{
+		{value: "1", want: 10000},
+		{value: "1.25", want: 12500},
+		{value: "0.05", want: 500},
+		{value: "0.0505", want: 505},
+		{value: "0", want: 0},
+		{value: "0.00001", err: true},
+		{value: "-1", err: true},
+		{value: "1e2", err: true},
+		{value: "", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBasisPoints(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseBasisPoints(%q) = %s, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseBasisPoints(%q) = %s, %v, want %s", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyMul(t *testing.T) {
+	tests := []struct {
+		money Money
+		ratio BasisPoints
+		want  Money
+	}{
+		{money: 50000, ratio: 505, want: 2525},
+		{money: 72998, ratio: 10000, want: 72998},
+		{money: 72998, ratio: 12500, want: 91248},
+		{money: 1, ratio: 5000, want: 1},
+		{money: 1, ratio: 4999, want: 0},
+		{money: -1, ratio: 5000, want: -1},
+		{money: 100, ratio: 0, want: 0},
+		{money: 922337203685477, ratio: 20000, want: 1844674407370954},
+	}
+	for _, tt := range tests {
+		if got := tt.money.Mul(tt.ratio); got != tt.want {
+			t.Errorf("%s.Mul(%s) = %s, want %s", tt.money, tt.ratio, got, tt.want)
+		}
+	}
+}
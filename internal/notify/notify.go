@@ -0,0 +1,89 @@
+// Package notify delivers messages to users. Users only have a login, so
+// the transport decides how a login maps to a mailbox; the log and file
+// notifiers are meant for local runs and tests, and only the file one
+// delivers secrets.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+)
+
+type Message struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+	// Secrets are parts of Body, like reset tokens, that must not end up
+	// in the application log.
+	Secrets []string `json:"-"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// New creates a notifier by name: log or file.
+func New(kind, file string) (Notifier, error) {
+	switch kind {
+	case "", "log":
+		return LogNotifier{}, nil
+	case "file":
+		return NewFileNotifier(file), nil
+	}
+	return nil, fmt.Errorf("unknown notifier %q", kind)
+}
+
+// LogNotifier writes messages to the application log with their secrets
+// redacted, so it shows that a message was sent but does not deliver it.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, msg Message) error {
+	body := msg.Body
+	for _, secret := range msg.Secrets {
+		if secret != "" {
+			body = strings.ReplaceAll(body, secret, "[redacted]")
+		}
+	}
+	logger.Sugar.Infof("Notification to %s: %s\n%s", msg.To, msg.Subject, body)
+	return nil
+}
+
+// FileNotifier appends messages to a file as JSON lines.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Notify(ctx context.Context, msg Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now()
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,17 @@
+package orders
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+// AccrualClient is the part of the accrual system OrderService depends on.
+// accrual.Client is the production implementation.
+type AccrualClient interface {
+	FetchAccrualInfo(ctx context.Context, orderNumber string) (models.AccrualInfo, error)
+	Throttled() bool
+	RetryAfter() time.Duration
+	Wait(ctx context.Context) error
+}
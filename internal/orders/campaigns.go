@@ -0,0 +1,121 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	"github.com/thalq/gopher_mart/internal/metrics"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+// awardBonuses credits bonuses of running campaigns for an order that has
+// just been credited with accrual, the amount from the accrual system.
+// Every bonus is a separate BONUS entry with its own lot.
+func (s *OrderService) awardBonuses(ctx context.Context, tx repository.Store, userID int64, orderNumber string, accrual models.Money) error {
+	now := time.Now()
+	campaigns, err := tx.Campaigns().ListRunning(ctx, now)
+	if err != nil || len(campaigns) == 0 {
+		return err
+	}
+	first, err := firstOrder(ctx, tx, userID, orderNumber)
+	if err != nil {
+		return err
+	}
+	for _, c := range campaigns {
+		if accrual <= c.MinAccrual || (c.FirstOrder && !first) {
+			continue
+		}
+		if err := s.award(ctx, tx, c.ID, userID, orderNumber, accrual, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// award credits the bonus of the campaign, cut down to what is left of
+// its budgets. The campaign is locked, so concurrent orders can not
+// overspend it.
+func (s *OrderService) award(
+	ctx context.Context,
+	tx repository.Store,
+	campaignID int64,
+	userID int64,
+	orderNumber string,
+	accrual models.Money,
+	at time.Time,
+) error {
+	c, err := tx.Campaigns().GetForUpdate(ctx, campaignID)
+	if err != nil {
+		return err
+	}
+	if !c.Running(at) {
+		return nil
+	}
+	bonus := c.Bonus(accrual)
+	if c.Budget > 0 {
+		bonus = min(bonus, c.Budget-c.Spent)
+	}
+	if c.UserBudget > 0 {
+		awarded, err := tx.Campaigns().Awarded(ctx, c.ID, userID)
+		if err != nil {
+			return err
+		}
+		bonus = min(bonus, c.UserBudget-awarded)
+	}
+	if bonus <= 0 {
+		return nil
+	}
+
+	entryID, err := tx.Balances().Post(ctx, ledger.Bonus(
+		userID,
+		CampaignReference(c.ID),
+		fmt.Sprintf("%s: order %s", c.Name, orderNumber),
+		bonus,
+	))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Lots().Create(ctx, models.Lot{UserID: userID, Order: orderNumber, Amount: bonus, EarnedAt: at}); err != nil {
+		return err
+	}
+	_, err = tx.Campaigns().Award(ctx, models.CampaignAward{
+		CampaignID: c.ID,
+		UserID:     userID,
+		Order:      orderNumber,
+		Amount:     bonus,
+		EntryID:    entryID,
+		CreatedAt:  at,
+	})
+	if err != nil {
+		return err
+	}
+	metrics.PointsAwarded.Add(bonus.Float64())
+	logger.Sugar.Infof("Campaign %d awarded %s for order %s to user %d", c.ID, bonus, orderNumber, userID)
+	return nil
+}
+
+// firstOrder reports whether the order is the only one the user has been
+// credited for.
+func firstOrder(ctx context.Context, tx repository.Store, userID int64, orderNumber string) (bool, error) {
+	entries, err := tx.Balances().Entries(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.Kind == ledger.KindAccrual && e.Reference != orderNumber {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// CampaignReference is the ledger reference of bonus entries of the
+// campaign.
+func CampaignReference(campaignID int64) string {
+	return "campaign:" + strconv.FormatInt(campaignID, 10)
+}
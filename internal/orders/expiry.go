@@ -0,0 +1,40 @@
+package orders
+
+import (
+	"context"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+)
+
+// ExpiryWorker periodically closes what has run out of time.
+type ExpiryWorker struct {
+	service  *OrderService
+	interval time.Duration
+}
+
+func NewExpiryWorker(service *OrderService, interval time.Duration) *ExpiryWorker {
+	return &ExpiryWorker{service: service, interval: interval}
+}
+
+// Run blocks until ctx is cancelled.
+func (w *ExpiryWorker) Run(ctx context.Context) {
+	defer logger.Sugar.Info("Expiry worker stopped")
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	logger.Sugar.Infof("Expiry worker started, runs every %s", w.interval)
+	for {
+		if err := w.service.ExpireHolds(ctx); err != nil {
+			logger.Sugar.Errorf("Failed to expire holds: %v", err)
+		}
+		if err := w.service.ExpirePoints(ctx); err != nil {
+			logger.Sugar.Errorf("Failed to expire points: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -3,62 +3,27 @@ package orders
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/thalq/gopher_mart/internal/constants"
-	"github.com/thalq/gopher_mart/internal/errors"
 	logger "github.com/thalq/gopher_mart/internal/middleware"
 	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
 )
 
 type OrderHandler struct {
-	service              *OrderService
-	AccrualSystemAddress string
+	service *OrderService
 }
 
-func fetchAccrualInfo(orderNumber string, AccrualSystemAddress string) (models.AccrualInfo, error) {
-	url := AccrualSystemAddress + "/api/orders/" + orderNumber
-	resp, err := http.Get(url)
-	if err != nil {
-		logger.Sugar.Errorf("Failed to send request to accrual system: %v", err)
-		return models.AccrualInfo{}, err
-	}
-	logger.Sugar.Infof("Got response from accrual system: %v", resp)
-
-	defer resp.Body.Close()
-
-	var accrualInfo models.AccrualInfo
-	if resp.StatusCode == http.StatusOK {
-		logger.Sugar.Infof("Order %s was successfully accrued", orderNumber)
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			logger.Sugar.Errorf("Failed to read response body: %v", err)
-			return models.AccrualInfo{}, err
-		}
-		if err := json.Unmarshal(body, &accrualInfo); err != nil {
-			logger.Sugar.Errorf("Failed to unmarshal response: %v", err)
-			return models.AccrualInfo{}, err
-		}
-		logger.Sugar.Infof("Got accrual info: %v", accrualInfo)
-	} else if resp.StatusCode == http.StatusNoContent {
-		accrualInfo.SetDefaults(orderNumber)
-		logger.Sugar.Infof("Order %s not found", orderNumber)
-	} else if resp.StatusCode == http.StatusTooManyRequests {
-		logger.Sugar.Infof("Too many requests to accrual system")
-		return models.AccrualInfo{}, errors.ErrTooManyRequests
-	} else if resp.StatusCode == http.StatusInternalServerError {
-		logger.Sugar.Infof("Internal server error in accrual system")
-		return models.AccrualInfo{}, errors.ErrInternalServer
-	}
-	logger.Sugar.Infof("Order %s was successfully accrued", orderNumber)
-	return accrualInfo, nil
-}
-
-func NewOrderHandler(service *OrderService, AccrualSystemAddress string) *OrderHandler {
-	return &OrderHandler{service: service, AccrualSystemAddress: AccrualSystemAddress}
+func NewOrderHandler(service *OrderService) *OrderHandler {
+	return &OrderHandler{service: service}
 }
 
 func (h *OrderHandler) UploadOrder(w http.ResponseWriter, r *http.Request) {
@@ -83,7 +48,7 @@ func (h *OrderHandler) UploadOrder(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid order number", http.StatusUnprocessableEntity)
 		return
 	}
-	userHasOrder, err := h.service.CheckUserHasOrders(userID, orderNumber)
+	userHasOrder, err := h.service.CheckUserHasOrders(ctx, userID, orderNumber)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -92,7 +57,7 @@ func (h *OrderHandler) UploadOrder(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		logger.Sugar.Infof("User %d has order %s", userID, orderNumber)
 	} else {
-		otherUserHasOrder, err := h.service.CheckOtherUserHasOrders(orderNumber)
+		otherUserHasOrder, err := h.service.CheckOtherUserHasOrders(ctx, orderNumber)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -101,23 +66,13 @@ func (h *OrderHandler) UploadOrder(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Order already exists", http.StatusConflict)
 			logger.Sugar.Infof("Order %s already exists for another user", orderNumber)
 		} else {
-			accrualInfoChan := make(chan models.AccrualInfo)
-			go func(orderNumber string) {
-				accrualInfo, err := fetchAccrualInfo(orderNumber, h.AccrualSystemAddress)
-				if err != nil {
-					close(accrualInfoChan)
-					return
-				}
-				accrualInfoChan <- accrualInfo
-				close(accrualInfoChan)
-			}(orderNumber)
-			accrualInfo, ok := <-accrualInfoChan
-			if !ok {
-				http.Error(w, "Failed to get accrual info", http.StatusInternalServerError)
+			err := h.service.CreateOrder(ctx, userID, orderNumber)
+			if errors.Is(err, repository.ErrConflict) {
+				// Another upload of the number won the race.
+				h.answerExistingOrder(ctx, w, userID, orderNumber)
 				return
 			}
-
-			if err := h.service.CreateOrder(userID, orderNumber, accrualInfo); err != nil {
+			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
@@ -128,6 +83,19 @@ func (h *OrderHandler) UploadOrder(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *OrderHandler) answerExistingOrder(ctx context.Context, w http.ResponseWriter, userID int64, orderNumber string) {
+	userHasOrder, err := h.service.CheckUserHasOrders(ctx, userID, orderNumber)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if userHasOrder {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Error(w, "Order already exists", http.StatusConflict)
+}
+
 func (h *OrderHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
@@ -138,7 +106,7 @@ func (h *OrderHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	orders, err := h.service.GetOrders(userID)
+	orders, err := h.service.GetOrders(ctx, userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -168,7 +136,7 @@ func (h *OrderHandler) GetBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	balance, err := h.service.GetBalance(userID)
+	balance, err := h.service.GetBalance(ctx, userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -214,22 +182,21 @@ func (h *OrderHandler) WithdrawRequest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid order number", http.StatusUnprocessableEntity)
 		return
 	}
-	accrualInfoChan := make(chan models.AccrualInfo)
-	go func(orderNumber string) {
-		accrualInfo, err := fetchAccrualInfo(orderNumber, h.AccrualSystemAddress)
-		if err != nil {
-			close(accrualInfoChan)
-			return
-		}
-		accrualInfoChan <- accrualInfo
-		close(accrualInfoChan)
-	}(request.Order)
-	accrualInfo, ok := <-accrualInfoChan
-	if !ok {
+	if request.Sum <= 0 {
+		http.Error(w, "Invalid withdrawal sum", http.StatusUnprocessableEntity)
+		return
+	}
+	accrualInfo, err := h.service.GetAccrualInfo(ctx, request.Order)
+	var throttled *AccrualThrottledError
+	if errors.As(err, &throttled) {
+		writeAccrualThrottled(w, throttled.Wait)
+		return
+	}
+	if err != nil {
 		http.Error(w, "Failed to get accrual info", http.StatusInternalServerError)
 		return
 	}
-	response := h.service.WithdrawRequest(userID, request.Order, request.Sum, accrualInfo)
+	response := h.service.WithdrawRequest(ctx, userID, request.Order, request.Sum, accrualInfo)
 	w.WriteHeader(response)
 }
 
@@ -243,7 +210,7 @@ func (h *OrderHandler) UserWithdrawls(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	withdrawls, err := h.service.GetUserWithdrawls(userID)
+	withdrawls, err := h.service.GetUserWithdrawls(ctx, userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -262,3 +229,210 @@ func (h *OrderHandler) UserWithdrawls(w http.ResponseWriter, r *http.Request) {
 	w.Write(response)
 	w.WriteHeader(http.StatusOK)
 }
+
+func (h *OrderHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	userID, ok := ctx.Value(constants.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	history, err := h.service.GetHistory(ctx, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(history) == 0 {
+		http.Error(w, "No history for user", http.StatusNoContent)
+		return
+	}
+	response, err := json.Marshal(history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.Write(response)
+}
+
+func (h *OrderHandler) AuthorizeHold(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	userID, ok := ctx.Value(constants.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request models.WithdrawRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if err := json.Unmarshal(body, &request); err != nil {
+		http.Error(w, "Failed to unmarshal request", http.StatusBadRequest)
+		return
+	}
+	if !ValidateOrderNumber(request.Order) {
+		http.Error(w, "Invalid order number", http.StatusUnprocessableEntity)
+		return
+	}
+	if request.Sum <= 0 {
+		http.Error(w, "Invalid withdrawal sum", http.StatusUnprocessableEntity)
+		return
+	}
+	hold, err := h.service.AuthorizeHold(ctx, userID, request.Order, request.Sum)
+	if err != nil {
+		writeHoldError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, hold)
+}
+
+func (h *OrderHandler) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	h.closeHold(w, r, h.service.CaptureHold)
+}
+
+func (h *OrderHandler) VoidHold(w http.ResponseWriter, r *http.Request) {
+	h.closeHold(w, r, h.service.VoidHold)
+}
+
+func (h *OrderHandler) closeHold(
+	w http.ResponseWriter,
+	r *http.Request,
+	action func(ctx context.Context, userID, holdID int64) (models.Hold, error),
+) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	userID, ok := ctx.Value(constants.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+	holdID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid hold id", http.StatusBadRequest)
+		return
+	}
+	hold, err := action(ctx, userID, holdID)
+	if err != nil {
+		writeHoldError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, hold)
+}
+
+func (h *OrderHandler) GetHolds(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	userID, ok := ctx.Value(constants.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	holds, err := h.service.GetHolds(ctx, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(holds) == 0 {
+		http.Error(w, "No holds for user", http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, holds)
+}
+
+func (h *OrderHandler) GetTier(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	userID, ok := ctx.Value(constants.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	status, err := h.service.GetTier(ctx, userID)
+	if errors.Is(err, ErrTiersDisabled) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (h *OrderHandler) GetTierHistory(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	userID, ok := ctx.Value(constants.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "User unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	history, err := h.service.GetTierHistory(ctx, userID)
+	if errors.Is(err, ErrTiersDisabled) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(history) == 0 {
+		http.Error(w, "No tier history for user", http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+func writeHoldError(w http.ResponseWriter, err error) {
+	var throttled *AccrualThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		writeAccrualThrottled(w, throttled.Wait)
+	case errors.Is(err, errNotEnoughMoney):
+		http.Error(w, "Not enough points", http.StatusPaymentRequired)
+	case errors.Is(err, repository.ErrNotFound):
+		http.Error(w, "Hold not found", http.StatusNotFound)
+	case errors.Is(err, ErrHoldClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, repository.ErrConflict):
+		http.Error(w, "Order number is already used", http.StatusConflict)
+	default:
+		logger.Sugar.Errorf("Hold request failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeAccrualThrottled refuses a withdrawal while the accrual system is
+// throttled. Nothing has been written and the 5xx status releases the
+// Idempotency-Key, so the client retries the same request after Retry-After.
+func writeAccrualThrottled(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Accrual system is unavailable", http.StatusServiceUnavailable)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	response, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
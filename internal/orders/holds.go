@@ -0,0 +1,177 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/metrics"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tracing"
+)
+
+var ErrHoldClosed = errors.New("hold is not active")
+
+// AuthorizeHold reserves sum of the available points for a withdrawal
+// with the order number. The points stay in the balance until the hold is
+// captured.
+func (s *OrderService) AuthorizeHold(ctx context.Context, userID int64, orderNumber string, sum models.Money) (models.Hold, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.AuthorizeHold")
+	defer span.End()
+
+	now := time.Now()
+	hold := models.Hold{
+		UserID:    userID,
+		Order:     orderNumber,
+		Sum:       sum,
+		Status:    models.HoldActive,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.opts.HoldTTL),
+	}
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		balance, err := tx.Balances().CurrentForUpdate(ctx, userID)
+		if err != nil {
+			return err
+		}
+		// A number that was already withdrawn could never be captured.
+		if _, err := tx.Withdrawals().Get(ctx, orderNumber); err == nil {
+			return repository.ErrConflict
+		} else if !errors.Is(err, repository.ErrNotFound) {
+			return err
+		}
+		held, err := tx.Holds().Held(ctx, userID, now)
+		if err != nil {
+			return err
+		}
+		if balance-held < sum {
+			return errNotEnoughMoney
+		}
+		hold.ID, err = tx.Holds().Create(ctx, hold)
+		return err
+	})
+	if err != nil {
+		tracing.Error(span, err)
+		return models.Hold{}, err
+	}
+	logger.Sugar.Infof("Hold %d of %s for order %s authorized for user %d", hold.ID, sum, orderNumber, userID)
+	return hold, nil
+}
+
+// CaptureHold turns an active hold into a withdrawal.
+func (s *OrderService) CaptureHold(ctx context.Context, userID, holdID int64) (models.Hold, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.CaptureHold")
+	defer span.End()
+
+	hold, err := s.userHold(ctx, userID, holdID)
+	if err != nil {
+		return models.Hold{}, err
+	}
+	accrualInfo, err := s.GetAccrualInfo(ctx, hold.Order)
+	if err != nil {
+		tracing.Error(span, err)
+		return models.Hold{}, err
+	}
+	now := time.Now()
+	err = s.store.WithinTx(ctx, func(tx repository.Store) error {
+		// The balance is locked first, like everywhere else.
+		if _, err := tx.Balances().CurrentForUpdate(ctx, userID); err != nil {
+			return err
+		}
+		if err := s.closeHold(ctx, tx, holdID, models.HoldCaptured, now); err != nil {
+			return err
+		}
+		return s.withdraw(ctx, tx, userID, hold.Order, hold.Sum, accrualInfo)
+	})
+	if err != nil {
+		tracing.Error(span, err)
+		return models.Hold{}, err
+	}
+	metrics.PointsWithdrawn.Add(hold.Sum.Float64())
+	if accrualInfo.OrderStatus() == models.OrderStatusProcessed {
+		metrics.PointsAccrued.Add(accrualInfo.Accrual.Float64())
+	}
+	logger.Sugar.Infof("Hold %d captured for user %d", holdID, userID)
+	hold.Status = models.HoldCaptured
+	hold.ClosedAt = &now
+	return hold, nil
+}
+
+// VoidHold releases the points of an active hold.
+func (s *OrderService) VoidHold(ctx context.Context, userID, holdID int64) (models.Hold, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.VoidHold")
+	defer span.End()
+
+	hold, err := s.userHold(ctx, userID, holdID)
+	if err != nil {
+		return models.Hold{}, err
+	}
+	now := time.Now()
+	if err := s.closeHold(ctx, s.store, holdID, models.HoldVoided, now); err != nil {
+		tracing.Error(span, err)
+		return models.Hold{}, err
+	}
+	hold.Status = models.HoldVoided
+	hold.ClosedAt = &now
+	return hold, nil
+}
+
+// GetHolds lists holds of the user. Holds past their expiry that the
+// expiry job has not reached yet are reported EXPIRED.
+func (s *OrderService) GetHolds(ctx context.Context, userID int64) ([]models.Hold, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.GetHolds")
+	defer span.End()
+
+	holds, err := s.store.Holds().ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i, h := range holds {
+		if h.Status == models.HoldActive && !h.Active(now) {
+			holds[i].Status = models.HoldExpired
+			expiresAt := h.ExpiresAt
+			holds[i].ClosedAt = &expiresAt
+		}
+	}
+	return holds, nil
+}
+
+// ExpireHolds closes holds whose TTL has passed. They stop reserving
+// points at expiry anyway; this records it.
+func (s *OrderService) ExpireHolds(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "OrderService.ExpireHolds")
+	defer span.End()
+
+	expired, err := s.store.Holds().Expire(ctx, time.Now())
+	if err != nil {
+		tracing.Error(span, err)
+		return err
+	}
+	if expired > 0 {
+		logger.Sugar.Infof("Expired %d holds", expired)
+	}
+	return nil
+}
+
+// userHold returns the hold when it belongs to the user. Holds of other
+// users are ErrNotFound.
+func (s *OrderService) userHold(ctx context.Context, userID, holdID int64) (models.Hold, error) {
+	hold, err := s.store.Holds().Get(ctx, holdID)
+	if err != nil {
+		return models.Hold{}, err
+	}
+	if hold.UserID != userID {
+		return models.Hold{}, repository.ErrNotFound
+	}
+	return hold, nil
+}
+
+func (s *OrderService) closeHold(ctx context.Context, store repository.Store, holdID int64, status string, at time.Time) error {
+	err := store.Holds().Close(ctx, holdID, status, at)
+	if errors.Is(err, repository.ErrConflict) {
+		return ErrHoldClosed
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+func TestCaptureHold(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{})
+	userID := newUser(t, store, "alice")
+	credit(t, service, userID, "12345678903", 500)
+
+	hold, err := service.AuthorizeHold(ctx, userID, "9278923470", 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Held points stay in the balance but can not be spent twice.
+	expectBalance(t, service, userID, 500, 200)
+	if _, err := service.AuthorizeHold(ctx, userID, "2377225624", 300); !errors.Is(err, errNotEnoughMoney) {
+		t.Fatalf("second hold: got %v, want %v", err, errNotEnoughMoney)
+	}
+	if status := service.WithdrawRequest(ctx, userID, "2377225624", 300, models.AccrualInfo{}); status != http.StatusPaymentRequired {
+		t.Fatalf("withdrawal of held points: got status %d, want %d", status, http.StatusPaymentRequired)
+	}
+
+	captured, err := service.CaptureHold(ctx, userID, hold.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if captured.Status != models.HoldCaptured || captured.ClosedAt == nil {
+		t.Fatalf("unexpected captured hold %+v", captured)
+	}
+	balance := expectBalance(t, service, userID, 200, 200)
+	if balance.Withdrawn != 300 || balance.Held != 0 {
+		t.Fatalf("got withdrawn %s and held %s, want 300 and 0", balance.Withdrawn, balance.Held)
+	}
+	withdrawals, err := service.GetUserWithdrawls(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(withdrawals) != 1 || withdrawals[0].OrderID != "9278923470" || withdrawals[0].Sum != 300 {
+		t.Fatalf("unexpected withdrawals %+v", withdrawals)
+	}
+
+	if _, err := service.CaptureHold(ctx, userID, hold.ID); !errors.Is(err, ErrHoldClosed) {
+		t.Fatalf("second capture: got %v, want %v", err, ErrHoldClosed)
+	}
+	expectBalance(t, service, userID, 200, 200)
+}
+
+func TestVoidHold(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{})
+	userID := newUser(t, store, "alice")
+	otherID := newUser(t, store, "bob")
+	credit(t, service, userID, "12345678903", 500)
+
+	hold, err := service.AuthorizeHold(ctx, userID, "9278923470", 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.VoidHold(ctx, otherID, hold.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("void by another user: got %v, want %v", err, repository.ErrNotFound)
+	}
+	if _, err := service.CaptureHold(ctx, otherID, hold.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("capture by another user: got %v, want %v", err, repository.ErrNotFound)
+	}
+
+	voided, err := service.VoidHold(ctx, userID, hold.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if voided.Status != models.HoldVoided {
+		t.Fatalf("got status %s, want %s", voided.Status, models.HoldVoided)
+	}
+	expectBalance(t, service, userID, 500, 500)
+	if _, err := service.CaptureHold(ctx, userID, hold.ID); !errors.Is(err, ErrHoldClosed) {
+		t.Fatalf("capture after void: got %v, want %v", err, ErrHoldClosed)
+	}
+	expectBalance(t, service, userID, 500, 500)
+}
+
+func TestHoldExpires(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{HoldTTL: 200 * time.Millisecond})
+	userID := newUser(t, store, "alice")
+	credit(t, service, userID, "12345678903", 500)
+
+	hold, err := service.AuthorizeHold(ctx, userID, "9278923470", 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Until(hold.ExpiresAt))
+
+	// The points are free at expiry, before the expiry job runs.
+	expectBalance(t, service, userID, 500, 500)
+	holds, err := service.GetHolds(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holds) != 1 || holds[0].Status != models.HoldExpired {
+		t.Fatalf("unexpected holds %+v", holds)
+	}
+	if _, err := service.CaptureHold(ctx, userID, hold.ID); !errors.Is(err, ErrHoldClosed) {
+		t.Fatalf("capture after expiry: got %v, want %v", err, ErrHoldClosed)
+	}
+
+	if err := service.ExpireHolds(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Holds().Get(ctx, hold.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.HoldExpired || !stored.ClosedAt.Equal(hold.ExpiresAt) {
+		t.Fatalf("unexpected expired hold %+v", stored)
+	}
+}
+
+func TestHoldForWithdrawnNumber(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{})
+	userID := newUser(t, store, "alice")
+	credit(t, service, userID, "12345678903", 500)
+
+	if status := service.WithdrawRequest(ctx, userID, "9278923470", 100, models.AccrualInfo{}); status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", status, http.StatusOK)
+	}
+	if _, err := service.AuthorizeHold(ctx, userID, "9278923470", 100); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("hold for a withdrawn number: got %v, want %v", err, repository.ErrConflict)
+	}
+	expectBalance(t, service, userID, 400, 400)
+}
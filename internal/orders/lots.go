@@ -0,0 +1,119 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	"github.com/thalq/gopher_mart/internal/metrics"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tracing"
+)
+
+// expireBatch is how many lots one run of the expiry job looks at.
+const expireBatch = 100
+
+// accrue credits amount for the order in tx and opens a lot for it.
+func (s *OrderService) accrue(ctx context.Context, tx repository.Store, userID int64, orderNumber string, amount models.Money) error {
+	if _, err := tx.Balances().Post(ctx, ledger.Accrual(userID, orderNumber, amount)); err != nil {
+		return err
+	}
+	_, err := tx.Lots().Create(ctx, models.Lot{
+		UserID:   userID,
+		Order:    orderNumber,
+		Amount:   amount,
+		EarnedAt: time.Now(),
+	})
+	return err
+}
+
+// ExpirePoints debits what is left of lots earned longer than
+// PointsExpireMonths ago. Each lot expires in its own transaction, under
+// the balance lock. Points reserved by holds are never expired: what the
+// rest of the balance can not cover stays in the lot until the holds
+// settle, so a capture never finds its points gone.
+func (s *OrderService) ExpirePoints(ctx context.Context) error {
+	if s.opts.PointsExpireMonths <= 0 {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "OrderService.ExpirePoints")
+	defer span.End()
+
+	now := time.Now()
+	lots, err := s.store.Lots().ListExpired(ctx, s.expiresBefore(now), now, expireBatch)
+	if err != nil {
+		tracing.Error(span, err)
+		return err
+	}
+	for _, lot := range lots {
+		var expired models.Money
+		err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+			balance, err := tx.Balances().CurrentForUpdate(ctx, lot.UserID)
+			if err != nil {
+				return err
+			}
+			held, err := tx.Holds().Held(ctx, lot.UserID, now)
+			if err != nil {
+				return err
+			}
+			current, err := tx.Lots().GetForUpdate(ctx, lot.ID)
+			if err != nil {
+				return err
+			}
+			// Points of the lot that the balance does not have any more
+			// are dropped without a posting.
+			expired = min(max(balance-held, 0), current.Remaining)
+			keep := min(current.Remaining-expired, max(held, 0))
+			if err := tx.Lots().Expire(ctx, lot.ID, keep, now); err != nil {
+				return err
+			}
+			if expired == 0 {
+				return nil
+			}
+			_, err = tx.Balances().Post(ctx, ledger.Expiry(lot.UserID, lot.Order, expired))
+			return err
+		})
+		if errors.Is(err, repository.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			tracing.Error(span, err)
+			return err
+		}
+		metrics.PointsExpired.Add(expired.Float64())
+		logger.Sugar.Infof("%s points of lot %d expired for user %d", expired, lot.ID, lot.UserID)
+	}
+	return nil
+}
+
+// expiring lists lots of the user that expire within ExpiryNotice.
+func (s *OrderService) expiring(ctx context.Context, tx repository.Store, userID int64) ([]models.ExpiringPoints, error) {
+	if s.opts.PointsExpireMonths <= 0 {
+		return nil, nil
+	}
+	lots, err := tx.Lots().ListActive(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	var expiring []models.ExpiringPoints
+	noticeFrom := s.expiresBefore(time.Now().Add(s.opts.ExpiryNotice))
+	for _, lot := range lots {
+		if !lot.EarnedAt.Before(noticeFrom) {
+			break
+		}
+		expiring = append(expiring, models.ExpiringPoints{
+			Sum:       lot.Remaining,
+			ExpiresAt: lot.EarnedAt.AddDate(0, s.opts.PointsExpireMonths, 0),
+		})
+	}
+	return expiring, nil
+}
+
+// expiresBefore returns the time lots must have been earned before to be
+// expired at the given time.
+func (s *OrderService) expiresBefore(at time.Time) time.Time {
+	return at.AddDate(0, -s.opts.PointsExpireMonths, 0)
+}
@@ -0,0 +1,148 @@
+package orders
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+// earn credits amount for the order like accrue does, with a lot earned
+// at the given time.
+func earn(t *testing.T, store repository.Store, userID int64, number string, amount models.Money, earnedAt time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := store.Balances().Post(ctx, ledger.Accrual(userID, number, amount)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.Lots().Create(ctx, models.Lot{UserID: userID, Order: number, Amount: amount, EarnedAt: earnedAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectLots checks what is left in the active lots, oldest first.
+func expectLots(t *testing.T, store repository.Store, userID int64, remaining ...models.Money) {
+	t.Helper()
+	lots, err := store.Lots().ListActive(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]models.Money, 0, len(lots))
+	for _, lot := range lots {
+		got = append(got, lot.Remaining)
+	}
+	if len(got) != len(remaining) {
+		t.Fatalf("got lots with %v left, want %v", got, remaining)
+	}
+	for i := range got {
+		if got[i] != remaining[i] {
+			t.Fatalf("got lots with %v left, want %v", got, remaining)
+		}
+	}
+}
+
+func TestWithdrawSpendsOldestLotsFirst(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{})
+	userID := newUser(t, store, "alice")
+	now := time.Now()
+	earn(t, store, userID, "12345678903", 100, now.AddDate(0, -2, 0))
+	earn(t, store, userID, "9278923470", 200, now.AddDate(0, -1, 0))
+
+	if status := service.WithdrawRequest(ctx, userID, "2377225624", 150, models.AccrualInfo{}); status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", status, http.StatusOK)
+	}
+	expectLots(t, store, userID, 150)
+
+	// Accruals credited later open a new lot.
+	credit(t, service, userID, "79927398713", 50)
+	expectLots(t, store, userID, 150, 50)
+	expectBalance(t, service, userID, 200, 200)
+}
+
+func TestExpirePoints(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{PointsExpireMonths: 12})
+	userID := newUser(t, store, "alice")
+	now := time.Now()
+	earn(t, store, userID, "12345678903", 100, now.AddDate(-1, -1, 0))
+	earn(t, store, userID, "9278923470", 200, now.AddDate(-1, -1, 1))
+	earn(t, store, userID, "2377225624", 400, now.AddDate(0, -1, 0))
+
+	// Part of the second lot is spent, so only the rest expires.
+	if status := service.WithdrawRequest(ctx, userID, "79927398713", 150, models.AccrualInfo{}); status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", status, http.StatusOK)
+	}
+	expectLots(t, store, userID, 150, 400)
+
+	if err := service.ExpirePoints(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectBalance(t, service, userID, 400, 400)
+	expectLots(t, store, userID, 400)
+	history, err := service.GetHistory(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := history[len(history)-1]
+	if last.Kind != string(ledger.KindExpiry) || last.Amount != -150 || last.Reference != "9278923470" {
+		t.Fatalf("unexpected expiry %+v", last)
+	}
+
+	// Nothing is left to expire.
+	if err := service.ExpirePoints(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectBalance(t, service, userID, 400, 400)
+}
+
+func TestExpirePointsWaitsForHolds(t *testing.T) {
+	ctx := context.Background()
+	service, store := testService(t, Options{PointsExpireMonths: 12})
+	userID := newUser(t, store, "alice")
+	earn(t, store, userID, "12345678903", 300, time.Now().AddDate(-1, -1, 0))
+
+	hold, err := service.AuthorizeHold(ctx, userID, "9278923470", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.ExpirePoints(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectBalance(t, service, userID, 300, 100)
+
+	// The hold is captured with the points it reserved.
+	if _, err := service.CaptureHold(ctx, userID, hold.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.ExpirePoints(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectBalance(t, service, userID, 0, 0)
+	expectLots(t, store, userID)
+}
+
+func TestBalanceListsExpiringPoints(t *testing.T) {
+	service, store := testService(t, Options{PointsExpireMonths: 12, ExpiryNotice: 30 * 24 * time.Hour})
+	userID := newUser(t, store, "alice")
+	soon := time.Now().AddDate(-1, 0, 10)
+	earn(t, store, userID, "12345678903", 100, soon)
+	earn(t, store, userID, "9278923470", 200, time.Now().AddDate(0, -6, 0))
+
+	balance := expectBalance(t, service, userID, 300, 300)
+	if len(balance.Expiring) != 1 || balance.Expiring[0].Sum != 100 ||
+		!balance.Expiring[0].ExpiresAt.Equal(soon.AddDate(1, 0, 0)) {
+		t.Fatalf("unexpected expiring points %+v", balance.Expiring)
+	}
+
+	// Without expiry nothing is listed.
+	service.opts.PointsExpireMonths = 0
+	if balance := expectBalance(t, service, userID, 300, 300); len(balance.Expiring) != 0 {
+		t.Fatalf("unexpected expiring points %+v", balance.Expiring)
+	}
+}
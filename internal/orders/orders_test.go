@@ -0,0 +1,82 @@
+package orders
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/repository/memory"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger()
+	os.Exit(m.Run())
+}
+
+// stubAccrual knows no order: every number it is asked about is NEW.
+type stubAccrual struct{}
+
+func (stubAccrual) FetchAccrualInfo(ctx context.Context, orderNumber string) (models.AccrualInfo, error) {
+	return models.AccrualInfo{OrderID: orderNumber}, nil
+}
+
+func (stubAccrual) Throttled() bool                { return false }
+func (stubAccrual) RetryAfter() time.Duration      { return 0 }
+func (stubAccrual) Wait(ctx context.Context) error { return nil }
+
+// testService runs OrderService on memory storage. Holds last a minute
+// unless opts says otherwise.
+func testService(t *testing.T, opts Options) (*OrderService, repository.Store) {
+	t.Helper()
+	if opts.HoldTTL == 0 {
+		opts.HoldTTL = time.Minute
+	}
+	store := memory.New()
+	return NewOrderService(store, stubAccrual{}, opts), store
+}
+
+func newUser(t *testing.T, store repository.Store, login string) int64 {
+	t.Helper()
+	ctx := context.Background()
+	userID, err := store.Users().Create(ctx, login, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Balances().Create(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
+
+// credit uploads the order and processes it with the accrual.
+func credit(t *testing.T, service *OrderService, userID int64, number string, accrual models.Money) {
+	t.Helper()
+	ctx := context.Background()
+	if err := service.CreateOrder(ctx, userID, number); err != nil {
+		t.Fatal(err)
+	}
+	err := service.UpdateOrderAccrual(ctx, number, models.AccrualInfo{
+		OrderID: number,
+		Status:  models.OrderStatusProcessed,
+		Accrual: accrual,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectBalance(t *testing.T, service *OrderService, userID int64, current, available models.Money) models.Balance {
+	t.Helper()
+	balance, err := service.GetBalance(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Current != current || balance.Available != available {
+		t.Fatalf("got balance %s, available %s, want %s, %s", balance.Current, balance.Available, current, available)
+	}
+	return balance
+}
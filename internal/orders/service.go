@@ -1,205 +1,357 @@
 package orders
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 
 	"net/http"
+	"strconv"
+	"time"
 
+	myErrors "github.com/thalq/gopher_mart/internal/errors"
+	"github.com/thalq/gopher_mart/internal/ledger"
+	"github.com/thalq/gopher_mart/internal/metrics"
 	logger "github.com/thalq/gopher_mart/internal/middleware"
 	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tracing"
 )
 
+var errNotEnoughMoney = errors.New("not enough money")
+
+type Options struct {
+	// HoldTTL is how long a hold reserves points unless it is captured or
+	// voided.
+	HoldTTL time.Duration
+	// PointsExpireMonths is how long accrued points last. Zero keeps them
+	// forever.
+	PointsExpireMonths int
+	// ExpiryNotice is how far ahead the balance lists points that expire.
+	ExpiryNotice time.Duration
+	// Tiers are loyalty tiers ordered by threshold, see ParseTiers. None
+	// credits accruals as they are.
+	Tiers []models.Tier
+}
+
 type OrderService struct {
-	db *sql.DB
+	store         repository.Store
+	accrualClient AccrualClient
+	opts          Options
 }
 
-func NewOrderService(db *sql.DB) *OrderService {
-	return &OrderService{db: db}
+func NewOrderService(store repository.Store, accrualClient AccrualClient, opts Options) *OrderService {
+	return &OrderService{store: store, accrualClient: accrualClient, opts: opts}
 }
 
-func (s *OrderService) CheckUserHasOrders(userID int64, orderNumber string) (bool, error) {
-	var orderExists bool
-	if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM orders WHERE user_id = $1 AND order_id = $2)", userID, orderNumber).Scan(&orderExists); err != nil {
-		return false, err
-	}
-	return orderExists, nil
+// AccrualThrottledError is returned while the accrual system asks to back
+// off. Nothing has been stored; the request can be retried after Wait.
+type AccrualThrottledError struct {
+	Wait time.Duration
 }
 
-func (s *OrderService) CreateOrder(
-	userID int64,
-	orderNumber string,
-	accrualInfo models.AccrualInfo,
-) error {
-	tx, err := s.db.Begin()
-	if err != nil {
-		return err
+func (e *AccrualThrottledError) Error() string {
+	return "accrual system is throttled"
+}
+
+// GetAccrualInfo asks the accrual system about the order. While the accrual
+// system is throttled it returns *AccrualThrottledError instead of guessing:
+// a withdrawal row is never polled again, so its accrual must be known when
+// it is written.
+func (s *OrderService) GetAccrualInfo(ctx context.Context, orderNumber string) (models.AccrualInfo, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.GetAccrualInfo")
+	defer span.End()
+
+	if s.accrualClient.Throttled() {
+		return models.AccrualInfo{}, &AccrualThrottledError{Wait: s.accrualClient.RetryAfter()}
 	}
-	_, err = tx.Exec(
-		"INSERT INTO orders (user_id, order_id, status, accrual) VALUES ($1, $2, $3, $4)",
-		userID,
-		orderNumber,
-		accrualInfo.Status,
-		accrualInfo.Accrual,
-	)
-	if err != nil {
-		logger.Sugar.Errorf("Failed to insert order: %v", err)
-		return err
+	info, err := s.accrualClient.FetchAccrualInfo(ctx, orderNumber)
+	if errors.Is(err, myErrors.ErrTooManyRequests) {
+		return models.AccrualInfo{}, &AccrualThrottledError{Wait: s.accrualClient.RetryAfter()}
 	}
-	_, err = tx.Exec(
-		"UPDATE user_balance SET current_balance = current_balance + $1 WHERE user_id = $2",
-		accrualInfo.Accrual,
-		userID,
-	)
 	if err != nil {
-		logger.Sugar.Errorf("Failed to update user balance: %v", err)
-		return err
+		tracing.Error(span, err)
+		return models.AccrualInfo{}, err
 	}
-	if err = tx.Commit(); err != nil {
-		logger.Sugar.Errorf("Failed to commit transaction: %v", err)
+	return info, nil
+}
+
+func (s *OrderService) CheckUserHasOrders(ctx context.Context, userID int64, orderNumber string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.CheckUserHasOrders")
+	defer span.End()
+
+	return s.store.Orders().ExistsForUser(ctx, userID, orderNumber)
+}
+
+func (s *OrderService) CreateOrder(ctx context.Context, userID int64, orderNumber string) error {
+	ctx, span := tracing.Start(ctx, "OrderService.CreateOrder")
+	defer span.End()
+
+	if err := s.store.Orders().Create(ctx, userID, orderNumber); err != nil {
 		return err
 	}
+	metrics.OrdersUploaded.Inc()
 	logger.Sugar.Infof("Order %s created for user %d", orderNumber, userID)
 	return nil
 }
 
-func (s *OrderService) CheckOtherUserHasOrders(orderNumber string) (bool, error) {
-	var orderExists bool
-	if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM orders WHERE order_id = $1)", orderNumber).Scan(&orderExists); err != nil {
-		return false, err
-	}
-	return orderExists, nil
+// ClaimPendingOrders returns orders that are due to be polled and
+// schedules their next poll, so other replicas skip them meanwhile.
+func (s *OrderService) ClaimPendingOrders(ctx context.Context, limit int, backoff time.Duration) ([]string, error) {
+	return s.store.Orders().ClaimPending(ctx, limit, backoff, maxPollBackoff)
 }
 
-func (s *OrderService) GetOrders(userID int64) ([]models.Order, error) {
-	rows, err := s.db.Query(
-		"SELECT order_id, status, upload_time, accrual FROM orders WHERE user_id = $1 ORDER BY upload_time DESC",
-		userID,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// UpdateOrderAccrual applies accrual system response to a non-final order.
+// The balance is credited only by the transaction that moves the order
+// into a final status, so repeated polls never credit it twice. The
+// accrual is multiplied by the tier of the owner, and the order keeps the
+// credited amount. Campaign bonuses are credited in the same transaction.
+func (s *OrderService) UpdateOrderAccrual(ctx context.Context, orderNumber string, accrualInfo models.AccrualInfo) error {
+	ctx, span := tracing.Start(ctx, "OrderService.UpdateOrderAccrual")
+	defer span.End()
 
-	var orders []models.Order
-	for rows.Next() {
-		var order models.Order
-		if err := rows.Scan(&order.Number, &order.Status, &order.UploadedAt, &order.Accrual); err != nil {
-			return nil, err
+	status := accrualInfo.OrderStatus()
+	credited := accrualInfo.Accrual
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		// Withdrawal rows are not uploaded orders; they are credited as they
+		// are and earn no bonuses.
+		uploaded := false
+		if status == models.OrderStatusProcessed && credited > 0 {
+			order, err := tx.Orders().Get(ctx, orderNumber)
+			if err != nil && !errors.Is(err, repository.ErrNotFound) {
+				return err
+			}
+			if uploaded = err == nil; uploaded {
+				if credited, err = s.multiplied(ctx, tx, order.UserID, credited); err != nil {
+					return err
+				}
+			}
+		}
+		userID, err := tx.Orders().UpdateAccrual(ctx, orderNumber, status, credited)
+		if err != nil {
+			return err
 		}
-		orders = append(orders, order)
+		if status == models.OrderStatusProcessed && credited > 0 {
+			if err := s.accrue(ctx, tx, userID, orderNumber, credited); err != nil {
+				return err
+			}
+			if _, err := s.evaluateTier(ctx, tx, userID); err != nil {
+				return err
+			}
+			if uploaded {
+				return s.awardBonuses(ctx, tx, userID, orderNumber, accrualInfo.Accrual)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		logger.Sugar.Infof("Order %s is already in final status", orderNumber)
+		return nil
 	}
-	if err = rows.Err(); err != nil {
-		logger.Sugar.Errorf("Failed to iterate over rows: %v", err)
-		return nil, err
+	if err != nil {
+		tracing.Error(span, err)
+		logger.Sugar.Errorf("Failed to update order %s: %v", orderNumber, err)
+		return err
+	}
+	if status == models.OrderStatusProcessed || status == models.OrderStatusInvalid {
+		metrics.OrdersProcessed.WithLabelValues(status).Inc()
+	}
+	if status == models.OrderStatusProcessed {
+		metrics.PointsAccrued.Add(credited.Float64())
 	}
-	logger.Sugar.Infof("Got orders for user %s: %v", userID, orders)
+	logger.Sugar.Infof("Order %s moved to %s", orderNumber, status)
+	return nil
+}
+
+func (s *OrderService) CheckOtherUserHasOrders(ctx context.Context, orderNumber string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.CheckOtherUserHasOrders")
+	defer span.End()
 
+	return s.store.Orders().Exists(ctx, orderNumber)
+}
+
+func (s *OrderService) GetOrders(ctx context.Context, userID int64) ([]models.Order, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.GetOrders")
+	defer span.End()
+
+	orders, err := s.store.Orders().ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	logger.Sugar.Infof("Got orders for user %d: %v", userID, orders)
 	return orders, nil
 }
 
-func (s *OrderService) GetBalance(userID int64) (models.Balance, error) {
+// GetBalance derives the balance from the ledger and verifies it against
+// the user_balance projection. Held points are subtracted from Available
+// only. Points expiring within ExpiryNotice are listed so users can be
+// warned.
+func (s *OrderService) GetBalance(ctx context.Context, userID int64) (models.Balance, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.GetBalance")
+	defer span.End()
+
 	var balance models.Balance
-	tx, err := s.db.Begin()
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		var err error
+		if balance.Current, err = tx.Balances().Balance(ctx, userID); err != nil {
+			logger.Sugar.Infof("Failed to get current balance for user %d: %v", userID, err)
+			return err
+		}
+		if balance.Withdrawn, err = tx.Balances().Withdrawn(ctx, userID); err != nil {
+			logger.Sugar.Infof("Failed to get withdrawal for user %d: %v", userID, err)
+			return err
+		}
+		if balance.Held, err = tx.Holds().Held(ctx, userID, time.Now()); err != nil {
+			logger.Sugar.Infof("Failed to get held points for user %d: %v", userID, err)
+			return err
+		}
+		balance.Available = balance.Current - balance.Held
+		if balance.Expiring, err = s.expiring(ctx, tx, userID); err != nil {
+			return err
+		}
+		projected, err := tx.Balances().Current(ctx, userID)
+		if err != nil {
+			logger.Sugar.Infof("Failed to get current balance for user %d: %v", userID, err)
+			return err
+		}
+		if projected != balance.Current {
+			logger.Sugar.Warnf("Balance of user %d is %s in ledger but %s in user_balance", userID, balance.Current, projected)
+		}
+		return nil
+	})
 	if err != nil {
-		return balance, err
+		tracing.Error(span, err)
+		return models.Balance{}, err
 	}
-	defer tx.Rollback()
+	logger.Sugar.Infof("Got balance for user %d: %v", userID, balance)
+	return balance, nil
+}
+
+// GetHistory lists every balance change of the user, oldest first.
+func (s *OrderService) GetHistory(ctx context.Context, userID int64) ([]models.HistoryItem, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.GetHistory")
+	defer span.End()
 
-	if err := tx.QueryRow("SELECT current_balance FROM user_balance WHERE user_id = $1", userID).Scan(&balance.Current); err != nil {
-		logger.Sugar.Infof("Failed to get current balance for user %d: %v", userID, err)
-		return balance, err
+	entries, err := s.store.Balances().Entries(ctx, userID)
+	if err != nil {
+		tracing.Error(span, err)
+		return nil, err
 	}
-	if err := tx.QueryRow("SELECT SUM(withdrawal) FROM orders WHERE user_id = $1", userID).Scan(&balance.Withdrawn); err != nil {
-		logger.Sugar.Infof("Failed to get withdrawal for user %d: %v", userID, err)
-		return balance, err
+	account := ledger.UserAccount(userID)
+	history := make([]models.HistoryItem, 0, len(entries))
+	for _, e := range entries {
+		item := models.HistoryItem{
+			ID:          e.ID,
+			Kind:        string(e.Kind),
+			Reference:   e.Reference,
+			Description: e.Description,
+			CreatedAt:   e.CreatedAt,
+		}
+		for _, p := range e.Postings {
+			if p.Account == account {
+				item.Amount += p.Amount
+			}
+		}
+		history = append(history, item)
 	}
-	logger.Sugar.Infof("Got balance for user %d: %v", userID, balance)
-	return balance, nil
+	return history, nil
 }
 
 func (s *OrderService) WithdrawRequest(
+	ctx context.Context,
 	userID int64,
 	orderID string,
-	sum float32,
+	sum models.Money,
 	accrualInfo models.AccrualInfo,
 ) int {
-	tx, err := s.db.Begin()
-	if err != nil {
-		logger.Sugar.Errorf("Failed to begin transaction: %v", err)
-		return http.StatusInternalServerError
-	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
+	ctx, span := tracing.Start(ctx, "OrderService.WithdrawRequest")
+	defer span.End()
 
-	var balance float32
-	if err := tx.QueryRow("SELECT current_balance FROM user_balance WHERE user_id = $1", userID).Scan(&balance); err != nil {
-		logger.Sugar.Errorf("Failed to get balance for user %d: %v", userID, err)
-		return http.StatusInternalServerError
+	status := accrualInfo.OrderStatus()
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		return s.withdraw(ctx, tx, userID, orderID, sum, accrualInfo)
+	})
+	if errors.Is(err, repository.ErrConflict) {
+		logger.Sugar.Infof("Withdrawal %s already exists", orderID)
+		metrics.Withdrawals.WithLabelValues(strconv.Itoa(http.StatusConflict)).Inc()
+		return http.StatusConflict
 	}
-	balance += accrualInfo.Accrual
-	if balance < sum {
+	if errors.Is(err, errNotEnoughMoney) {
 		logger.Sugar.Errorf("Not enough money for user %d", userID)
+		metrics.Withdrawals.WithLabelValues(strconv.Itoa(http.StatusPaymentRequired)).Inc()
 		return http.StatusPaymentRequired
 	}
-
-	_, err = tx.Exec(
-		"INSERT INTO orders (user_id, order_id, withdrawal, status, accrual) VALUES ($1, $2, $3, $4, $5)",
-		userID,
-		orderID,
-		sum,
-		accrualInfo.Status,
-		accrualInfo.Accrual,
-	)
-	if err != nil {
-		logger.Sugar.Errorf("Failed to insert order: %v", err)
-		return http.StatusInternalServerError
-	}
-	_, err = tx.Exec(
-		"UPDATE user_balance SET current_balance = current_balance + $1 - $2 WHERE user_id = $3",
-		accrualInfo.Accrual,
-		sum,
-		userID,
-	)
 	if err != nil {
-		logger.Sugar.Errorf("Failed to update user balance: %v", err)
+		tracing.Error(span, err)
+		logger.Sugar.Errorf("Failed to withdraw for user %d: %v", userID, err)
+		metrics.Withdrawals.WithLabelValues(strconv.Itoa(http.StatusInternalServerError)).Inc()
 		return http.StatusInternalServerError
 	}
-
-	if err = tx.Commit(); err != nil {
-		logger.Sugar.Errorf("Failed to commit transaction: %v", err)
-		return http.StatusInternalServerError
+	metrics.Withdrawals.WithLabelValues(strconv.Itoa(http.StatusOK)).Inc()
+	metrics.PointsWithdrawn.Add(sum.Float64())
+	if status == models.OrderStatusProcessed {
+		metrics.PointsAccrued.Add(accrualInfo.Accrual.Float64())
 	}
 
-	logger.Sugar.Infof("Withdraw %d for user %d", sum, userID)
+	logger.Sugar.Infof("Withdraw %s for user %d", sum, userID)
 	return http.StatusOK
 }
 
-func (s *OrderService) GetUserWithdrawls(userID int64) ([]models.WithdrawResponse, error) {
-	rows, err := s.db.Query(
-		"SELECT order_id, withdrawal, upload_time FROM orders WHERE user_id = $1 AND withdrawal > 0",
-		userID,
-	)
+// withdraw debits sum for the order in tx, taking it from the oldest lots.
+// Points reserved by holds can not be spent.
+func (s *OrderService) withdraw(
+	ctx context.Context,
+	tx repository.Store,
+	userID int64,
+	orderID string,
+	sum models.Money,
+	accrualInfo models.AccrualInfo,
+) error {
+	status := accrualInfo.OrderStatus()
+	balance, err := tx.Balances().CurrentForUpdate(ctx, userID)
 	if err != nil {
-		return nil, err
+		logger.Sugar.Errorf("Failed to get balance for user %d: %v", userID, err)
+		return err
+	}
+	held, err := tx.Holds().Held(ctx, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if status == models.OrderStatusProcessed {
+		balance += accrualInfo.Accrual
+	}
+	if balance-held < sum {
+		return errNotEnoughMoney
 	}
-	defer rows.Close()
 
-	var withdrawls []models.WithdrawResponse
-	for rows.Next() {
-		var withdrawl models.WithdrawResponse
-		if err := rows.Scan(&withdrawl.OrderID, &withdrawl.Sum, &withdrawl.ProcessedAt); err != nil {
-			return nil, err
+	if err := tx.Withdrawals().Create(ctx, userID, orderID, sum, accrualInfo); err != nil {
+		return err
+	}
+	if status == models.OrderStatusProcessed && accrualInfo.Accrual > 0 {
+		if err := s.accrue(ctx, tx, userID, withdrawalReference(orderID), accrualInfo.Accrual); err != nil {
+			return err
 		}
-		withdrawls = append(withdrawls, withdrawl)
 	}
-	if err = rows.Err(); err != nil {
-		logger.Sugar.Errorf("Failed to iterate over rows: %v", err)
+	if _, err := tx.Balances().Post(ctx, ledger.Withdrawal(userID, orderID, sum)); err != nil {
+		return err
+	}
+	return tx.Lots().Consume(ctx, userID, "", sum)
+}
+
+// withdrawalReference is the ledger reference of the accrual credited for
+// the number of a withdrawal. The same number may also be uploaded as an
+// order, which is credited under the bare number.
+func withdrawalReference(orderNumber string) string {
+	return "withdrawal:" + orderNumber
+}
+
+func (s *OrderService) GetUserWithdrawls(ctx context.Context, userID int64) ([]models.WithdrawResponse, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.GetUserWithdrawls")
+	defer span.End()
+
+	withdrawls, err := s.store.Withdrawals().ListByUser(ctx, userID)
+	if err != nil {
 		return nil, err
 	}
 	logger.Sugar.Infof("Got withdrawls for user %d: %v", userID, withdrawls)
-
 	return withdrawls, nil
 }
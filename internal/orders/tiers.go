@@ -0,0 +1,150 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tracing"
+)
+
+var ErrTiersDisabled = errors.New("loyalty tiers are disabled")
+
+// ParseTiers reads comma separated NAME:threshold:multiplier tiers, for
+// example "BRONZE:0:1,SILVER:1000:1.1,GOLD:5000:1.25". The lowest tier
+// must start at zero so that every user has one. An empty value disables
+// tiers.
+func ParseTiers(value string) ([]models.Tier, error) {
+	var tiers []models.Tier
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("tier %q: want NAME:threshold:multiplier", item)
+		}
+		threshold, err := models.ParseMoney(parts[1])
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("tier %q: invalid threshold", item)
+		}
+		multiplier, err := models.ParseBasisPoints(parts[2])
+		if err != nil || multiplier == 0 {
+			return nil, fmt.Errorf("tier %q: invalid multiplier", item)
+		}
+		tiers = append(tiers, models.Tier{Name: parts[0], Threshold: threshold, Multiplier: multiplier})
+	}
+	if len(tiers) == 0 {
+		return nil, nil
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Threshold < tiers[j].Threshold })
+	if tiers[0].Threshold != 0 {
+		return nil, fmt.Errorf("tier %s: the lowest tier must start at 0", tiers[0].Name)
+	}
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].Threshold == tiers[i-1].Threshold {
+			return nil, fmt.Errorf("tiers %s and %s have the same threshold", tiers[i-1].Name, tiers[i].Name)
+		}
+	}
+	return tiers, nil
+}
+
+// GetTier returns the tier of the user and the progress to the next one.
+func (s *OrderService) GetTier(ctx context.Context, userID int64) (models.TierStatus, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.GetTier")
+	defer span.End()
+
+	if len(s.opts.Tiers) == 0 {
+		return models.TierStatus{}, ErrTiersDisabled
+	}
+	var status models.TierStatus
+	err := s.store.WithinTx(ctx, func(tx repository.Store) error {
+		var err error
+		status, err = s.evaluateTier(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		tracing.Error(span, err)
+		return models.TierStatus{}, err
+	}
+	return status, nil
+}
+
+// GetTierHistory lists tier changes of the user, newest first.
+func (s *OrderService) GetTierHistory(ctx context.Context, userID int64) ([]models.TierChange, error) {
+	ctx, span := tracing.Start(ctx, "OrderService.GetTierHistory")
+	defer span.End()
+
+	if len(s.opts.Tiers) == 0 {
+		return nil, ErrTiersDisabled
+	}
+	return s.store.Tiers().History(ctx, userID)
+}
+
+// multiplied applies the tier multiplier of the user to an accrual.
+func (s *OrderService) multiplied(ctx context.Context, tx repository.Store, userID int64, accrual models.Money) (models.Money, error) {
+	if len(s.opts.Tiers) == 0 {
+		return accrual, nil
+	}
+	status, err := s.evaluateTier(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	return models.Tier{Multiplier: status.Multiplier}.Apply(accrual), nil
+}
+
+// evaluateTier computes the tier from accruals of the last twelve months
+// and records it in the history when it differs from the latest one.
+// Tiers are evaluated whenever an order is credited and whenever the user
+// asks for their tier, so a drop after old accruals leave the window is
+// recorded at one of those.
+func (s *OrderService) evaluateTier(ctx context.Context, tx repository.Store, userID int64) (models.TierStatus, error) {
+	if len(s.opts.Tiers) == 0 {
+		return models.TierStatus{}, nil
+	}
+	now := time.Now()
+	accrued, err := tx.Balances().Accrued(ctx, userID, now.AddDate(-1, 0, 0))
+	if err != nil {
+		return models.TierStatus{}, err
+	}
+	i := s.tierIndex(accrued)
+	tier := s.opts.Tiers[i]
+	status := models.TierStatus{Tier: tier.Name, Multiplier: tier.Multiplier, Accrued: accrued, Progress: 1}
+	if i+1 < len(s.opts.Tiers) {
+		next := s.opts.Tiers[i+1]
+		status.NextTier = next.Name
+		status.NextThreshold = next.Threshold
+		status.Remaining = next.Threshold - accrued
+		status.Progress = float64(max(accrued-tier.Threshold, 0)) / float64(next.Threshold-tier.Threshold)
+	}
+
+	current, err := tx.Tiers().Current(ctx, userID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return models.TierStatus{}, err
+	}
+	if err == nil && current.Tier == tier.Name {
+		return status, nil
+	}
+	change := models.TierChange{UserID: userID, Tier: tier.Name, Accrued: accrued, ChangedAt: now}
+	if _, err := tx.Tiers().Record(ctx, change); err != nil {
+		return models.TierStatus{}, err
+	}
+	logger.Sugar.Infof("User %d moved to tier %s with %s accrued", userID, tier.Name, accrued)
+	return status, nil
+}
+
+// tierIndex returns the highest tier whose threshold is reached.
+func (s *OrderService) tierIndex(accrued models.Money) int {
+	i := 0
+	for i+1 < len(s.opts.Tiers) && accrued >= s.opts.Tiers[i+1].Threshold {
+		i++
+	}
+	return i
+}
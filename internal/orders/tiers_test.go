@@ -0,0 +1,60 @@
+package orders
+
+import (
+	"testing"
+
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+func TestParseTiers(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []models.Tier
+		err   bool
+	}{
+		{value: ""},
+		{value: " , "},
+		{
+			value: "GOLD:5000:1.25, BRONZE:0:1,SILVER:1000:1.1",
+			want: []models.Tier{
+				{Name: "BRONZE", Threshold: 0, Multiplier: 10000},
+				{Name: "SILVER", Threshold: 100000, Multiplier: 11000},
+				{Name: "GOLD", Threshold: 500000, Multiplier: 12500},
+			},
+		},
+		{value: "BRONZE:0:1,SILVER:999.99:1.0505", want: []models.Tier{
+			{Name: "BRONZE", Threshold: 0, Multiplier: 10000},
+			{Name: "SILVER", Threshold: 99999, Multiplier: 10505},
+		}},
+		{value: "SILVER:1000:1.1", err: true},
+		{value: "BRONZE:0:1,SILVER:0:1.1", err: true},
+		{value: "BRONZE:0", err: true},
+		{value: ":0:1", err: true},
+		{value: "BRONZE:-1:1", err: true},
+		{value: "BRONZE:0:0", err: true},
+		{value: "BRONZE:0:1.00001", err: true},
+		{value: "BRONZE:0.001:1", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTiers(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseTiers(%q) = %v, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTiers(%q): %v", tt.value, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseTiers(%q) = %v, want %v", tt.value, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("ParseTiers(%q)[%d] = %+v, want %+v", tt.value, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
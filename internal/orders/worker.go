@@ -0,0 +1,155 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	myErrors "github.com/thalq/gopher_mart/internal/errors"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxPollBackoff caps the delay between polls of an order that the accrual
+// system keeps in PROCESSING.
+const maxPollBackoff = 10 * time.Minute
+
+// AccrualWorker polls the accrual system for orders that are not in a final
+// status yet and moves them forward.
+type AccrualWorker struct {
+	service      *OrderService
+	workers      int
+	pollInterval time.Duration
+
+	// inFlight holds orders that are queued or being fetched, so a claim
+	// that comes around again before they are done does not queue them
+	// twice.
+	inFlight sync.Map
+
+	// heartbeat is the unix time in nanoseconds of the last polling round
+	// or processed order.
+	heartbeat atomic.Int64
+}
+
+func NewAccrualWorker(service *OrderService, workers int, pollInterval time.Duration) *AccrualWorker {
+	if workers < 1 {
+		workers = 1
+	}
+	return &AccrualWorker{
+		service:      service,
+		workers:      workers,
+		pollInterval: pollInterval,
+	}
+}
+
+// Run blocks until ctx is cancelled and all in-flight orders are handled.
+func (w *AccrualWorker) Run(ctx context.Context) {
+	defer w.heartbeat.Store(0)
+	defer logger.Sugar.Info("Accrual worker stopped")
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < w.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for orderNumber := range jobs {
+				w.process(ctx, orderNumber)
+				w.beat()
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+	logger.Sugar.Infof("Accrual worker started with %d workers", w.workers)
+	for {
+		w.beat()
+		w.enqueue(ctx, jobs)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *AccrualWorker) beat() {
+	w.heartbeat.Store(time.Now().UnixNano())
+}
+
+// Healthy reports an error when Run is not running or is stuck.
+func (w *AccrualWorker) Healthy(ctx context.Context) error {
+	last := w.heartbeat.Load()
+	if last == 0 {
+		return fmt.Errorf("accrual worker is not started")
+	}
+	staleAfter := 3*w.pollInterval + time.Minute
+	if since := time.Since(time.Unix(0, last)); since > staleAfter {
+		return fmt.Errorf("accrual worker has not made progress for %s", since.Round(time.Second))
+	}
+	return nil
+}
+
+func (w *AccrualWorker) enqueue(ctx context.Context, jobs chan<- string) {
+	// Orders are left in db while the accrual system is throttled, so the
+	// loop keeps beating instead of blocking here.
+	if w.service.accrualClient.Throttled() {
+		return
+	}
+	orderNumbers, err := w.service.ClaimPendingOrders(ctx, w.workers*10, w.pollInterval)
+	if err != nil {
+		logger.Sugar.Errorf("Failed to get pending orders: %v", err)
+		return
+	}
+	for _, orderNumber := range orderNumbers {
+		if _, queued := w.inFlight.LoadOrStore(orderNumber, struct{}{}); queued {
+			continue
+		}
+		select {
+		case jobs <- orderNumber:
+		case <-ctx.Done():
+			w.inFlight.Delete(orderNumber)
+			return
+		}
+	}
+}
+
+func (w *AccrualWorker) process(ctx context.Context, orderNumber string) {
+	defer w.inFlight.Delete(orderNumber)
+	if err := w.service.accrualClient.Wait(ctx); err != nil {
+		return
+	}
+	// An order that is already being fetched is finished even when Run is
+	// stopped, so the accrual response is not lost.
+	ctx, span := tracing.Start(context.WithoutCancel(ctx), "AccrualWorker.process")
+	defer span.End()
+	span.SetAttributes(attribute.String("order.number", orderNumber))
+	accrualInfo, err := w.service.accrualClient.FetchAccrualInfo(ctx, orderNumber)
+	if err == myErrors.ErrTooManyRequests {
+		logger.Sugar.Infof("Accrual system is throttled, order %s will be polled later", orderNumber)
+		return
+	}
+	if errors.Is(err, myErrors.ErrInvalidAccrual) {
+		// Polling again would get the same answer forever. The order is
+		// closed as INVALID; support can requeue it once the accrual system
+		// is fixed.
+		tracing.Error(span, err)
+		logger.Sugar.Errorf("Rejected accrual response for order %s, marking it %s: %v", orderNumber, models.OrderStatusInvalid, err)
+		accrualInfo = models.AccrualInfo{OrderID: orderNumber, Status: models.OrderStatusInvalid}
+	} else if err != nil {
+		tracing.Error(span, err)
+		logger.Sugar.Errorf("Failed to get accrual info for order %s: %v", orderNumber, err)
+		return
+	}
+	if err := w.service.UpdateOrderAccrual(ctx, orderNumber, accrualInfo); err != nil {
+		logger.Sugar.Errorf("Failed to update accrual for order %s: %v", orderNumber, err)
+	}
+}
@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+// adjustments are kept in id order, adjustment i has id i+1.
+type adjustments struct {
+	s *Store
+}
+
+func (r *adjustments) Create(ctx context.Context, adjustment models.Adjustment) (int64, error) {
+	var adjustmentID int64
+	err := r.s.update(func(d *data) error {
+		adjustmentID = int64(len(d.adjustments)) + 1
+		adjustment.ID = adjustmentID
+		adjustment.Status = models.AdjustmentPending
+		d.adjustments = append(d.adjustments, adjustment)
+		return nil
+	})
+	return adjustmentID, err
+}
+
+func (r *adjustments) Get(ctx context.Context, adjustmentID int64) (models.Adjustment, error) {
+	var adjustment models.Adjustment
+	err := r.s.view(func(d *data) error {
+		if adjustmentID < 1 || adjustmentID > int64(len(d.adjustments)) {
+			return repository.ErrNotFound
+		}
+		adjustment = d.adjustments[adjustmentID-1]
+		return nil
+	})
+	return adjustment, err
+}
+
+func (r *adjustments) List(ctx context.Context, status string) ([]models.Adjustment, error) {
+	adjustments := []models.Adjustment{}
+	err := r.s.view(func(d *data) error {
+		for i := len(d.adjustments) - 1; i >= 0; i-- {
+			if status == "" || d.adjustments[i].Status == status {
+				adjustments = append(adjustments, d.adjustments[i])
+			}
+		}
+		return nil
+	})
+	return adjustments, err
+}
+
+func (r *adjustments) Decide(
+	ctx context.Context,
+	adjustmentID int64,
+	status string,
+	decidedBy int64,
+	at time.Time,
+	entryID int64,
+) error {
+	return r.s.update(func(d *data) error {
+		if adjustmentID < 1 || adjustmentID > int64(len(d.adjustments)) {
+			return repository.ErrNotFound
+		}
+		adjustment := &d.adjustments[adjustmentID-1]
+		if adjustment.Status != models.AdjustmentPending {
+			return repository.ErrConflict
+		}
+		adjustment.Status = status
+		adjustment.DecidedBy = decidedBy
+		adjustment.DecidedAt = &at
+		adjustment.EntryID = entryID
+		return nil
+	})
+}
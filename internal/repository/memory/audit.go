@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+type audit struct {
+	s *Store
+}
+
+func (r *audit) Record(ctx context.Context, event models.AuditEvent) error {
+	return r.s.update(func(d *data) error {
+		event.ID = int64(len(d.audit)) + 1
+		d.audit = append(d.audit, event)
+		return nil
+	})
+}
+
+func (r *audit) List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	err := r.s.view(func(d *data) error {
+		for i := len(d.audit) - 1; i >= 0; i-- {
+			if filter.Limit > 0 && len(events) == filter.Limit {
+				break
+			}
+			event := d.audit[i]
+			if filter.Subject != "" && event.Subject != filter.Subject {
+				continue
+			}
+			if filter.ActorID != 0 && event.ActorID != filter.ActorID {
+				continue
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	return events, err
+}
@@ -0,0 +1,168 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+type balances struct {
+	s *Store
+}
+
+func (r *balances) Create(ctx context.Context, userID int64) error {
+	return r.s.update(func(d *data) error {
+		if _, ok := d.balances[userID]; ok {
+			return repository.ErrConflict
+		}
+		d.balances[userID] = 0
+		return nil
+	})
+}
+
+func (r *balances) Current(ctx context.Context, userID int64) (models.Money, error) {
+	var current models.Money
+	err := r.s.view(func(d *data) error {
+		var ok bool
+		if current, ok = d.balances[userID]; !ok {
+			return repository.ErrNotFound
+		}
+		return nil
+	})
+	return current, err
+}
+
+// CurrentForUpdate needs no extra locking: transactions are serialized.
+func (r *balances) CurrentForUpdate(ctx context.Context, userID int64) (models.Money, error) {
+	return r.Current(ctx, userID)
+}
+
+func (r *balances) Post(ctx context.Context, entry ledger.Entry) (int64, error) {
+	if err := entry.Validate(); err != nil {
+		return 0, err
+	}
+	var entryID int64
+	err := r.s.update(func(d *data) error {
+		for _, e := range d.entries {
+			if entry.Kind == ledger.KindAccrual && e.Kind == ledger.KindAccrual && e.Reference == entry.Reference {
+				return repository.ErrConflict
+			}
+		}
+		if entry.Reverses > int64(len(d.entries)) {
+			return repository.ErrNotFound
+		}
+
+		entryID = int64(len(d.entries)) + 1
+		entry.ID = entryID
+		entry.CreatedAt = time.Now()
+		entry.Postings = append([]ledger.Posting(nil), entry.Postings...)
+		d.entries = append(d.entries, entry)
+		for _, p := range entry.Postings {
+			userID, ok := ledger.ParseUserAccount(p.Account)
+			if !ok {
+				continue
+			}
+			if current, ok := d.balances[userID]; ok {
+				d.balances[userID] = current + p.Amount
+			}
+		}
+		return nil
+	})
+	return entryID, err
+}
+
+func (r *balances) Entry(ctx context.Context, entryID int64) (ledger.Entry, error) {
+	var entry ledger.Entry
+	err := r.s.view(func(d *data) error {
+		if entryID < 1 || entryID > int64(len(d.entries)) {
+			return repository.ErrNotFound
+		}
+		entry = d.entries[entryID-1]
+		return nil
+	})
+	return entry, err
+}
+
+func (r *balances) Entries(ctx context.Context, userID int64) ([]ledger.Entry, error) {
+	account := ledger.UserAccount(userID)
+	entries := []ledger.Entry{}
+	err := r.s.view(func(d *data) error {
+		for _, e := range d.entries {
+			for _, p := range e.Postings {
+				if p.Account == account {
+					entries = append(entries, e)
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// sum adds postings of the user account in entries accepted by filter.
+func (r *balances) sum(userID int64, filter func(e ledger.Entry) bool) (models.Money, error) {
+	account := ledger.UserAccount(userID)
+	var total models.Money
+	err := r.s.view(func(d *data) error {
+		for _, e := range d.entries {
+			if !filter(e) {
+				continue
+			}
+			for _, p := range e.Postings {
+				if p.Account == account {
+					total += p.Amount
+				}
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+func (r *balances) Balance(ctx context.Context, userID int64) (models.Money, error) {
+	return r.sum(userID, func(e ledger.Entry) bool { return true })
+}
+
+func (r *balances) BalanceAt(ctx context.Context, userID int64, at time.Time) (models.Money, error) {
+	return r.sum(userID, func(e ledger.Entry) bool { return !e.CreatedAt.After(at) })
+}
+
+func (r *balances) Accrued(ctx context.Context, userID int64, since time.Time) (models.Money, error) {
+	var entries []ledger.Entry
+	if err := r.s.view(func(d *data) error {
+		entries = d.entries
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return r.sum(userID, func(e ledger.Entry) bool {
+		if e.CreatedAt.Before(since) {
+			return false
+		}
+		if e.Kind == ledger.KindReversal && e.Reverses > 0 {
+			e = entries[e.Reverses-1]
+		}
+		return e.Kind == ledger.KindAccrual
+	})
+}
+
+func (r *balances) Withdrawn(ctx context.Context, userID int64) (models.Money, error) {
+	var entries []ledger.Entry
+	if err := r.s.view(func(d *data) error {
+		entries = d.entries
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	withdrawn, err := r.sum(userID, func(e ledger.Entry) bool {
+		if e.Kind == ledger.KindReversal && e.Reverses > 0 {
+			e = entries[e.Reverses-1]
+		}
+		return e.Kind == ledger.KindWithdrawal
+	})
+	return -withdrawn, err
+}
@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+// campaigns and awards are kept in id order, campaign i has id i+1 and so
+// does award i.
+type campaigns struct {
+	s *Store
+}
+
+func (r *campaigns) Create(ctx context.Context, campaign models.Campaign) (int64, error) {
+	var campaignID int64
+	err := r.s.update(func(d *data) error {
+		campaignID = int64(len(d.campaigns)) + 1
+		campaign.ID = campaignID
+		campaign.Spent = 0
+		d.campaigns = append(d.campaigns, campaign)
+		return nil
+	})
+	return campaignID, err
+}
+
+func (r *campaigns) Get(ctx context.Context, campaignID int64) (models.Campaign, error) {
+	var campaign models.Campaign
+	err := r.s.view(func(d *data) error {
+		if campaignID < 1 || campaignID > int64(len(d.campaigns)) {
+			return repository.ErrNotFound
+		}
+		campaign = d.campaigns[campaignID-1]
+		return nil
+	})
+	return campaign, err
+}
+
+// GetForUpdate needs no extra locking: transactions are serialized.
+func (r *campaigns) GetForUpdate(ctx context.Context, campaignID int64) (models.Campaign, error) {
+	return r.Get(ctx, campaignID)
+}
+
+func (r *campaigns) List(ctx context.Context) ([]models.Campaign, error) {
+	campaigns := []models.Campaign{}
+	err := r.s.view(func(d *data) error {
+		for i := len(d.campaigns) - 1; i >= 0; i-- {
+			campaigns = append(campaigns, d.campaigns[i])
+		}
+		return nil
+	})
+	return campaigns, err
+}
+
+func (r *campaigns) ListRunning(ctx context.Context, at time.Time) ([]models.Campaign, error) {
+	var campaigns []models.Campaign
+	err := r.s.view(func(d *data) error {
+		for _, c := range d.campaigns {
+			if c.Running(at) {
+				campaigns = append(campaigns, c)
+			}
+		}
+		return nil
+	})
+	return campaigns, err
+}
+
+func (r *campaigns) Update(ctx context.Context, campaign models.Campaign) error {
+	return r.s.update(func(d *data) error {
+		if campaign.ID < 1 || campaign.ID > int64(len(d.campaigns)) {
+			return repository.ErrNotFound
+		}
+		old := d.campaigns[campaign.ID-1]
+		campaign.Spent = old.Spent
+		campaign.CreatedBy = old.CreatedBy
+		campaign.CreatedAt = old.CreatedAt
+		d.campaigns[campaign.ID-1] = campaign
+		return nil
+	})
+}
+
+func (r *campaigns) Award(ctx context.Context, award models.CampaignAward) (int64, error) {
+	var awardID int64
+	err := r.s.update(func(d *data) error {
+		if award.CampaignID < 1 || award.CampaignID > int64(len(d.campaigns)) {
+			return repository.ErrNotFound
+		}
+		for _, a := range d.awards {
+			if a.CampaignID == award.CampaignID && a.Order == award.Order {
+				return repository.ErrConflict
+			}
+		}
+		awardID = int64(len(d.awards)) + 1
+		award.ID = awardID
+		d.awards = append(d.awards, award)
+		d.campaigns[award.CampaignID-1].Spent += award.Amount
+		return nil
+	})
+	return awardID, err
+}
+
+func (r *campaigns) Awarded(ctx context.Context, campaignID, userID int64) (models.Money, error) {
+	var awarded models.Money
+	err := r.s.view(func(d *data) error {
+		for _, a := range d.awards {
+			if a.CampaignID == campaignID && a.UserID == userID {
+				awarded += a.Amount
+			}
+		}
+		return nil
+	})
+	return awarded, err
+}
+
+func (r *campaigns) Awards(ctx context.Context, campaignID int64) ([]models.CampaignAward, error) {
+	awards := []models.CampaignAward{}
+	err := r.s.view(func(d *data) error {
+		for i := len(d.awards) - 1; i >= 0; i-- {
+			if d.awards[i].CampaignID == campaignID {
+				awards = append(awards, d.awards[i])
+			}
+		}
+		return nil
+	})
+	return awards, err
+}
+
+func (r *campaigns) OrderAwards(ctx context.Context, orderNumber string) ([]models.CampaignAward, error) {
+	awards := []models.CampaignAward{}
+	err := r.s.view(func(d *data) error {
+		for _, a := range d.awards {
+			if a.Order == orderNumber {
+				awards = append(awards, a)
+			}
+		}
+		return nil
+	})
+	return awards, err
+}
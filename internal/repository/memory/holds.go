@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+// holds are kept in id order, hold i has id i+1.
+type holds struct {
+	s *Store
+}
+
+func (r *holds) Create(ctx context.Context, hold models.Hold) (int64, error) {
+	var holdID int64
+	err := r.s.update(func(d *data) error {
+		for _, h := range d.holds {
+			if h.Order == hold.Order && h.Status == models.HoldActive {
+				return repository.ErrConflict
+			}
+		}
+		holdID = int64(len(d.holds)) + 1
+		hold.ID = holdID
+		hold.Status = models.HoldActive
+		d.holds = append(d.holds, hold)
+		return nil
+	})
+	return holdID, err
+}
+
+func (r *holds) Get(ctx context.Context, holdID int64) (models.Hold, error) {
+	var hold models.Hold
+	err := r.s.view(func(d *data) error {
+		if holdID < 1 || holdID > int64(len(d.holds)) {
+			return repository.ErrNotFound
+		}
+		hold = d.holds[holdID-1]
+		return nil
+	})
+	return hold, err
+}
+
+func (r *holds) ListByUser(ctx context.Context, userID int64) ([]models.Hold, error) {
+	holds := []models.Hold{}
+	err := r.s.view(func(d *data) error {
+		for i := len(d.holds) - 1; i >= 0; i-- {
+			if d.holds[i].UserID == userID {
+				holds = append(holds, d.holds[i])
+			}
+		}
+		return nil
+	})
+	return holds, err
+}
+
+func (r *holds) Held(ctx context.Context, userID int64, at time.Time) (models.Money, error) {
+	var held models.Money
+	err := r.s.view(func(d *data) error {
+		for _, h := range d.holds {
+			if h.UserID == userID && h.Active(at) {
+				held += h.Sum
+			}
+		}
+		return nil
+	})
+	return held, err
+}
+
+func (r *holds) Close(ctx context.Context, holdID int64, status string, at time.Time) error {
+	return r.s.update(func(d *data) error {
+		if holdID < 1 || holdID > int64(len(d.holds)) || !d.holds[holdID-1].Active(at) {
+			return repository.ErrConflict
+		}
+		d.holds[holdID-1].Status = status
+		d.holds[holdID-1].ClosedAt = &at
+		return nil
+	})
+}
+
+func (r *holds) Expire(ctx context.Context, at time.Time) (int64, error) {
+	var expired int64
+	err := r.s.update(func(d *data) error {
+		for i, h := range d.holds {
+			if h.Status == models.HoldActive && !at.Before(h.ExpiresAt) {
+				d.holds[i].Status = models.HoldExpired
+				closedAt := h.ExpiresAt
+				d.holds[i].ClosedAt = &closedAt
+				expired++
+			}
+		}
+		return nil
+	})
+	return expired, err
+}
@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+type idempotencyKey struct {
+	userID int64
+	key    string
+}
+
+type idempotency struct {
+	s *Store
+}
+
+func (r *idempotency) Begin(
+	ctx context.Context,
+	record models.IdempotencyRecord,
+	expiredBefore time.Time,
+) (models.IdempotencyRecord, bool, error) {
+	var stored models.IdempotencyRecord
+	var claimed bool
+	err := r.s.update(func(d *data) error {
+		k := idempotencyKey{userID: record.UserID, key: record.Key}
+		if existing, ok := d.idempotency[k]; ok && !existing.CreatedAt.Before(expiredBefore) {
+			abandoned := !existing.Completed() && existing.LockedUntil.Before(record.CreatedAt)
+			if !abandoned {
+				stored = existing
+				return nil
+			}
+		}
+		d.idempotency[k] = record
+		stored, claimed = record, true
+		return nil
+	})
+	return stored, claimed, err
+}
+
+func (r *idempotency) Complete(
+	ctx context.Context,
+	userID int64,
+	key string,
+	claim string,
+	statusCode int,
+	contentType string,
+	body []byte,
+) error {
+	return r.s.update(func(d *data) error {
+		k := idempotencyKey{userID: userID, key: key}
+		record, ok := d.idempotency[k]
+		if !ok || record.Claim != claim || record.Completed() {
+			return repository.ErrNotFound
+		}
+		record.LockedUntil = time.Time{}
+		record.StatusCode = statusCode
+		record.ContentType = contentType
+		record.Body = append([]byte(nil), body...)
+		d.idempotency[k] = record
+		return nil
+	})
+}
+
+func (r *idempotency) Delete(ctx context.Context, userID int64, key, claim string) error {
+	return r.s.update(func(d *data) error {
+		k := idempotencyKey{userID: userID, key: key}
+		if record, ok := d.idempotency[k]; ok && record.Claim == claim && !record.Completed() {
+			delete(d.idempotency, k)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+type loginAttempts struct {
+	s *Store
+}
+
+func (r *loginAttempts) Get(ctx context.Context, key string) (models.LoginAttempts, error) {
+	var attempts models.LoginAttempts
+	err := r.s.view(func(d *data) error {
+		var ok bool
+		if attempts, ok = d.attempts[key]; !ok {
+			return repository.ErrNotFound
+		}
+		return nil
+	})
+	return attempts, err
+}
+
+func (r *loginAttempts) Attempt(ctx context.Context, key string, at time.Time, limits models.AttemptLimits) (models.LoginAttempts, bool, error) {
+	var attempts models.LoginAttempts
+	allowed := false
+	err := r.s.update(func(d *data) error {
+		attempts = d.attempts[key]
+		if attempts.BlockedUntil(limits).After(at) {
+			return nil
+		}
+		if attempts.LastFailureAt.Before(at.Add(-limits.Lockout)) {
+			attempts.Failures = 0
+		}
+		attempts.Key = key
+		attempts.Failures++
+		attempts.LastFailureAt = at
+		d.attempts[key] = attempts
+		allowed = true
+		return nil
+	})
+	return attempts, allowed, err
+}
+
+func (r *loginAttempts) Forgive(ctx context.Context, key string) error {
+	return r.s.update(func(d *data) error {
+		if attempts, ok := d.attempts[key]; ok && attempts.Failures > 0 {
+			attempts.Failures--
+			d.attempts[key] = attempts
+		}
+		return nil
+	})
+}
+
+func (r *loginAttempts) Lock(ctx context.Context, key string, until time.Time) error {
+	return r.s.update(func(d *data) error {
+		attempts, ok := d.attempts[key]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		attempts.LockedUntil = until
+		d.attempts[key] = attempts
+		return nil
+	})
+}
+
+func (r *loginAttempts) Reset(ctx context.Context, key string) error {
+	return r.s.update(func(d *data) error {
+		delete(d.attempts, key)
+		return nil
+	})
+}
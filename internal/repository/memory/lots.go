@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+// lots are kept in id order, which is the order they were earned in. Lot
+// i has id i+1.
+type lots struct {
+	s *Store
+}
+
+func (r *lots) Create(ctx context.Context, lot models.Lot) (int64, error) {
+	var lotID int64
+	err := r.s.update(func(d *data) error {
+		lotID = int64(len(d.lots)) + 1
+		lot.ID = lotID
+		lot.Remaining = lot.Amount
+		d.lots = append(d.lots, lot)
+		return nil
+	})
+	return lotID, err
+}
+
+func (r *lots) Consume(ctx context.Context, userID int64, orderNumber string, amount models.Money) error {
+	return r.s.update(func(d *data) error {
+		take := func(i int) {
+			taken := min(amount, d.lots[i].Remaining)
+			d.lots[i].Remaining -= taken
+			amount -= taken
+		}
+		if orderNumber != "" {
+			for i, l := range d.lots {
+				if l.UserID == userID && l.Order == orderNumber && l.Remaining > 0 {
+					take(i)
+				}
+			}
+		}
+		for i, l := range d.lots {
+			if amount <= 0 {
+				break
+			}
+			if l.UserID == userID && l.Remaining > 0 {
+				take(i)
+			}
+		}
+		return nil
+	})
+}
+
+func (r *lots) ListActive(ctx context.Context, userID int64) ([]models.Lot, error) {
+	lots := []models.Lot{}
+	err := r.s.view(func(d *data) error {
+		for _, l := range d.lots {
+			if l.UserID == userID && l.Remaining > 0 {
+				lots = append(lots, l)
+			}
+		}
+		return nil
+	})
+	return lots, err
+}
+
+func (r *lots) ListExpired(ctx context.Context, earnedBefore, at time.Time, limit int) ([]models.Lot, error) {
+	var lots []models.Lot
+	err := r.s.view(func(d *data) error {
+		holding := map[int64]bool{}
+		for _, h := range d.holds {
+			if h.Active(at) {
+				holding[h.UserID] = true
+			}
+		}
+		for _, l := range d.lots {
+			if len(lots) == limit {
+				break
+			}
+			if l.Remaining > 0 && l.EarnedAt.Before(earnedBefore) && !holding[l.UserID] {
+				lots = append(lots, l)
+			}
+		}
+		return nil
+	})
+	return lots, err
+}
+
+func (r *lots) GetForUpdate(ctx context.Context, lotID int64) (models.Lot, error) {
+	var lot models.Lot
+	err := r.s.view(func(d *data) error {
+		if lotID < 1 || lotID > int64(len(d.lots)) {
+			return repository.ErrNotFound
+		}
+		lot = d.lots[lotID-1]
+		return nil
+	})
+	return lot, err
+}
+
+func (r *lots) Expire(ctx context.Context, lotID int64, keep models.Money, at time.Time) error {
+	return r.s.update(func(d *data) error {
+		if lotID < 1 || lotID > int64(len(d.lots)) || d.lots[lotID-1].Remaining <= keep {
+			return repository.ErrConflict
+		}
+		d.lots[lotID-1].Remaining = keep
+		if keep == 0 {
+			d.lots[lotID-1].ExpiredAt = &at
+		}
+		return nil
+	})
+}
@@ -0,0 +1,194 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+type orders struct {
+	s *Store
+}
+
+func (r *orders) Create(ctx context.Context, userID int64, orderNumber string) error {
+	return r.s.update(func(d *data) error {
+		for _, o := range d.orders {
+			if o.number == orderNumber && o.withdrawal == 0 {
+				return repository.ErrConflict
+			}
+		}
+		d.orders = append(d.orders, order{
+			userID:     userID,
+			number:     orderNumber,
+			status:     models.OrderStatusNew,
+			uploadedAt: time.Now(),
+		})
+		return nil
+	})
+}
+
+func (r *orders) Exists(ctx context.Context, orderNumber string) (bool, error) {
+	var orderExists bool
+	err := r.s.view(func(d *data) error {
+		for _, o := range d.orders {
+			if o.number == orderNumber {
+				orderExists = true
+				break
+			}
+		}
+		return nil
+	})
+	return orderExists, err
+}
+
+func (r *orders) ExistsForUser(ctx context.Context, userID int64, orderNumber string) (bool, error) {
+	var orderExists bool
+	err := r.s.view(func(d *data) error {
+		for _, o := range d.orders {
+			if o.userID == userID && o.number == orderNumber {
+				orderExists = true
+				break
+			}
+		}
+		return nil
+	})
+	return orderExists, err
+}
+
+func (r *orders) ListByUser(ctx context.Context, userID int64) ([]models.Order, error) {
+	var result []models.Order
+	err := r.s.view(func(d *data) error {
+		for _, o := range d.orders {
+			if o.userID != userID {
+				continue
+			}
+			result = append(result, o.toModel())
+		}
+		return nil
+	})
+	sort.SliceStable(result, func(i, j int) bool { return result[i].UploadedAt.After(result[j].UploadedAt) })
+	return result, err
+}
+
+func (r *orders) ClaimPending(ctx context.Context, limit int, backoff, maxBackoff time.Duration) ([]string, error) {
+	var orderNumbers []string
+	err := r.s.update(func(d *data) error {
+		now := time.Now()
+		var due []int
+		for i, o := range d.orders {
+			if o.withdrawal > 0 || o.nextPollAt.After(now) {
+				continue
+			}
+			if o.status == models.OrderStatusNew || o.status == models.OrderStatusProcessing {
+				due = append(due, i)
+			}
+		}
+		sort.SliceStable(due, func(i, j int) bool { return d.orders[due[i]].nextPollAt.Before(d.orders[due[j]].nextPollAt) })
+		if len(due) > limit {
+			due = due[:limit]
+		}
+		for _, i := range due {
+			o := &d.orders[i]
+			delay := backoff
+			for n := 0; n < o.pollAttempts && delay < maxBackoff; n++ {
+				delay *= 2
+			}
+			o.nextPollAt = now.Add(min(delay, maxBackoff))
+			o.pollAttempts++
+			orderNumbers = append(orderNumbers, o.number)
+		}
+		return nil
+	})
+	return orderNumbers, err
+}
+
+func (r *orders) UpdateAccrual(ctx context.Context, orderNumber, status string, accrual models.Money) (int64, error) {
+	var userID int64
+	err := r.s.update(func(d *data) error {
+		for i, o := range d.orders {
+			if o.number != orderNumber || o.withdrawal > 0 {
+				continue
+			}
+			if o.status != models.OrderStatusNew && o.status != models.OrderStatusProcessing {
+				continue
+			}
+			d.orders[i].status = status
+			d.orders[i].accrual = accrual
+			userID = o.userID
+			return nil
+		}
+		return repository.ErrNotFound
+	})
+	return userID, err
+}
+
+func (r *orders) Requeue(ctx context.Context, orderNumber string) (int64, error) {
+	var userID int64
+	err := r.s.update(func(d *data) error {
+		for i, o := range d.orders {
+			if o.number != orderNumber || o.withdrawal > 0 {
+				continue
+			}
+			if o.status == models.OrderStatusProcessed {
+				return repository.ErrConflict
+			}
+			d.orders[i].status = models.OrderStatusNew
+			d.orders[i].nextPollAt = time.Time{}
+			d.orders[i].pollAttempts = 0
+			userID = o.userID
+			return nil
+		}
+		return repository.ErrNotFound
+	})
+	return userID, err
+}
+
+func (r *orders) Get(ctx context.Context, orderNumber string) (models.Order, error) {
+	var order models.Order
+	err := r.s.view(func(d *data) error {
+		for _, o := range d.orders {
+			if o.number == orderNumber && o.withdrawal == 0 {
+				order = o.toModel()
+				return nil
+			}
+		}
+		return repository.ErrNotFound
+	})
+	return order, err
+}
+
+func (r *orders) Reverse(ctx context.Context, orderNumber string, amount models.Money) error {
+	return r.s.update(func(d *data) error {
+		for i, o := range d.orders {
+			if o.number != orderNumber || o.withdrawal > 0 {
+				continue
+			}
+			if o.status != models.OrderStatusProcessed || o.reversed+amount > o.accrual {
+				return repository.ErrConflict
+			}
+			d.orders[i].reversed += amount
+			return nil
+		}
+		return repository.ErrNotFound
+	})
+}
+
+// toModel describes the row as an order. Refunds of withdrawal rows are
+// not reversals of their accrual.
+func (o order) toModel() models.Order {
+	order := models.Order{
+		UserID:     o.userID,
+		Number:     o.number,
+		Status:     o.status,
+		UploadedAt: o.uploadedAt,
+		Accrual:    o.accrual,
+	}
+	if o.withdrawal == 0 {
+		order.Reversed = o.reversed
+		order.ReversalStatus = models.ReversalStatus(o.accrual, o.reversed)
+	}
+	return order
+}
@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+type passwordResets struct {
+	s *Store
+}
+
+func (r *passwordResets) Create(ctx context.Context, token models.PasswordResetToken) error {
+	return r.s.update(func(d *data) error {
+		if _, ok := d.resets[token.Hash]; ok {
+			return repository.ErrConflict
+		}
+		d.resets[token.Hash] = token
+		return nil
+	})
+}
+
+func (r *passwordResets) Use(ctx context.Context, hash string, at time.Time) (models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	err := r.s.update(func(d *data) error {
+		var ok bool
+		token, ok = d.resets[hash]
+		if !ok || !token.UsedAt.IsZero() || !at.Before(token.ExpiresAt) {
+			return repository.ErrNotFound
+		}
+		token.UsedAt = at
+		d.resets[hash] = token
+		return nil
+	})
+	return token, err
+}
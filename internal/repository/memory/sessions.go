@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+type sessions struct {
+	s *Store
+}
+
+func (r *sessions) Create(ctx context.Context, session models.Session) error {
+	return r.s.update(func(d *data) error {
+		if _, ok := d.sessions[session.ID]; ok {
+			return repository.ErrConflict
+		}
+		d.sessions[session.ID] = session
+		return nil
+	})
+}
+
+func (r *sessions) Get(ctx context.Context, sessionID string) (models.Session, error) {
+	var session models.Session
+	err := r.s.view(func(d *data) error {
+		var ok bool
+		if session, ok = d.sessions[sessionID]; !ok {
+			return repository.ErrNotFound
+		}
+		return nil
+	})
+	return session, err
+}
+
+func (r *sessions) Revoke(ctx context.Context, sessionID, reason string) error {
+	return r.s.update(func(d *data) error {
+		session, ok := d.sessions[sessionID]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		if !session.Revoked() {
+			session.RevokedAt = time.Now()
+			session.RevokeReason = reason
+			d.sessions[sessionID] = session
+		}
+		return nil
+	})
+}
+
+func (r *sessions) RevokeUser(ctx context.Context, userID int64, except, reason string) error {
+	return r.s.update(func(d *data) error {
+		now := time.Now()
+		for id, session := range d.sessions {
+			if session.UserID == userID && id != except && !session.Revoked() {
+				session.RevokedAt = now
+				session.RevokeReason = reason
+				d.sessions[id] = session
+			}
+		}
+		return nil
+	})
+}
+
+func (r *sessions) AddRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	return r.s.update(func(d *data) error {
+		if _, ok := d.refresh[token.Hash]; ok {
+			return repository.ErrConflict
+		}
+		d.refresh[token.Hash] = token
+		return nil
+	})
+}
+
+func (r *sessions) GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.s.view(func(d *data) error {
+		var ok bool
+		if token, ok = d.refresh[hash]; !ok {
+			return repository.ErrNotFound
+		}
+		return nil
+	})
+	return token, err
+}
+
+func (r *sessions) UseRefreshToken(ctx context.Context, hash string, at time.Time) (bool, error) {
+	var used bool
+	err := r.s.update(func(d *data) error {
+		token, ok := d.refresh[hash]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		if !token.UsedAt.IsZero() {
+			return nil
+		}
+		token.UsedAt = at
+		d.refresh[hash] = token
+		used = true
+		return nil
+	})
+	return used, err
+}
@@ -0,0 +1,219 @@
+// Package memory implements repository.Store in process memory. It keeps
+// the same transactional semantics as postgres: WithinTx works on a copy of
+// the data that replaces the original only when fn succeeds, and
+// transactions are serialized by a single lock.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+type order struct {
+	userID     int64
+	number     string
+	status     string
+	uploadedAt time.Time
+	withdrawal models.Money
+	accrual    models.Money
+	reversed   models.Money
+
+	nextPollAt   time.Time
+	pollAttempts int
+}
+
+type data struct {
+	users       map[int64]models.User
+	logins      map[string]int64
+	nextUserID  int64
+	orders      []order
+	balances    map[int64]models.Money
+	entries     []ledger.Entry
+	sessions    map[string]models.Session
+	refresh     map[string]models.RefreshToken
+	attempts    map[string]models.LoginAttempts
+	audit       []models.AuditEvent
+	resets      map[string]models.PasswordResetToken
+	recovery    map[recoveryCode]time.Time
+	adjustments []models.Adjustment
+	idempotency map[idempotencyKey]models.IdempotencyRecord
+	holds       []models.Hold
+	lots        []models.Lot
+	tiers       []models.TierChange
+	campaigns   []models.Campaign
+	awards      []models.CampaignAward
+}
+
+func newData() *data {
+	return &data{
+		users:    make(map[int64]models.User),
+		logins:   make(map[string]int64),
+		balances: make(map[int64]models.Money),
+		sessions: make(map[string]models.Session),
+		refresh:  make(map[string]models.RefreshToken),
+		attempts: make(map[string]models.LoginAttempts),
+		resets:   make(map[string]models.PasswordResetToken),
+		recovery: make(map[recoveryCode]time.Time),
+
+		idempotency: make(map[idempotencyKey]models.IdempotencyRecord),
+	}
+}
+
+// clone copies everything that can be changed in place. Ledger entries and
+// audit events are immutable, so they are shared.
+func (d *data) clone() *data {
+	c := *d
+	c.users = cloneMap(d.users)
+	c.logins = cloneMap(d.logins)
+	c.orders = append([]order(nil), d.orders...)
+	c.balances = cloneMap(d.balances)
+	c.entries = append([]ledger.Entry(nil), d.entries...)
+	c.sessions = cloneMap(d.sessions)
+	c.refresh = cloneMap(d.refresh)
+	c.attempts = cloneMap(d.attempts)
+	c.audit = append([]models.AuditEvent(nil), d.audit...)
+	c.resets = cloneMap(d.resets)
+	c.recovery = cloneMap(d.recovery)
+	c.adjustments = append([]models.Adjustment(nil), d.adjustments...)
+	c.idempotency = cloneMap(d.idempotency)
+	c.holds = append([]models.Hold(nil), d.holds...)
+	c.lots = append([]models.Lot(nil), d.lots...)
+	c.tiers = append([]models.TierChange(nil), d.tiers...)
+	c.campaigns = append([]models.Campaign(nil), d.campaigns...)
+	c.awards = append([]models.CampaignAward(nil), d.awards...)
+	return &c
+}
+
+func cloneMap[K comparable, V any](m map[K]V) map[K]V {
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+type root struct {
+	mu   sync.Mutex
+	data *data
+}
+
+// Store must not be used from the root store inside WithinTx: the lock is
+// held for the whole transaction.
+type Store struct {
+	root *root
+	tx   *data
+}
+
+func New() *Store {
+	return &Store{root: &root{data: newData()}}
+}
+
+func (s *Store) Users() repository.UserRepository {
+	return &users{s: s}
+}
+
+func (s *Store) Orders() repository.OrderRepository {
+	return &orders{s: s}
+}
+
+func (s *Store) Balances() repository.BalanceRepository {
+	return &balances{s: s}
+}
+
+func (s *Store) Withdrawals() repository.WithdrawalRepository {
+	return &withdrawals{s: s}
+}
+
+func (s *Store) Sessions() repository.SessionRepository {
+	return &sessions{s: s}
+}
+
+func (s *Store) LoginAttempts() repository.LoginAttemptRepository {
+	return &loginAttempts{s: s}
+}
+
+func (s *Store) Audit() repository.AuditRepository {
+	return &audit{s: s}
+}
+
+func (s *Store) PasswordResets() repository.PasswordResetRepository {
+	return &passwordResets{s: s}
+}
+
+func (s *Store) TwoFactor() repository.TwoFactorRepository {
+	return &twoFactor{s: s}
+}
+
+func (s *Store) Adjustments() repository.AdjustmentRepository {
+	return &adjustments{s: s}
+}
+
+func (s *Store) Idempotency() repository.IdempotencyRepository {
+	return &idempotency{s: s}
+}
+
+func (s *Store) Holds() repository.HoldRepository {
+	return &holds{s: s}
+}
+
+func (s *Store) Lots() repository.LotRepository {
+	return &lots{s: s}
+}
+
+func (s *Store) Tiers() repository.TierRepository {
+	return &tiers{s: s}
+}
+
+func (s *Store) Campaigns() repository.CampaignRepository {
+	return &campaigns{s: s}
+}
+
+func (s *Store) WithinTx(ctx context.Context, fn func(tx repository.Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	s.root.mu.Lock()
+	defer s.root.mu.Unlock()
+
+	tx := s.root.data.clone()
+	if err := fn(&Store{root: s.root, tx: tx}); err != nil {
+		return err
+	}
+	s.root.data = tx
+	return nil
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return nil
+}
+
+// view runs a read-only fn.
+func (s *Store) view(fn func(d *data) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	s.root.mu.Lock()
+	defer s.root.mu.Unlock()
+	return fn(s.root.data)
+}
+
+// update runs fn atomically: outside of a transaction it works on a copy.
+func (s *Store) update(fn func(d *data) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	s.root.mu.Lock()
+	defer s.root.mu.Unlock()
+
+	d := s.root.data.clone()
+	if err := fn(d); err != nil {
+		return err
+	}
+	s.root.data = d
+	return nil
+}
@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+// tiers are kept in id order, change i has id i+1.
+type tiers struct {
+	s *Store
+}
+
+func (r *tiers) Record(ctx context.Context, change models.TierChange) (int64, error) {
+	var changeID int64
+	err := r.s.update(func(d *data) error {
+		changeID = int64(len(d.tiers)) + 1
+		change.ID = changeID
+		d.tiers = append(d.tiers, change)
+		return nil
+	})
+	return changeID, err
+}
+
+func (r *tiers) Current(ctx context.Context, userID int64) (models.TierChange, error) {
+	var change models.TierChange
+	err := r.s.view(func(d *data) error {
+		for i := len(d.tiers) - 1; i >= 0; i-- {
+			if d.tiers[i].UserID == userID {
+				change = d.tiers[i]
+				return nil
+			}
+		}
+		return repository.ErrNotFound
+	})
+	return change, err
+}
+
+func (r *tiers) History(ctx context.Context, userID int64) ([]models.TierChange, error) {
+	changes := []models.TierChange{}
+	err := r.s.view(func(d *data) error {
+		for i := len(d.tiers) - 1; i >= 0; i-- {
+			if d.tiers[i].UserID == userID {
+				changes = append(changes, d.tiers[i])
+			}
+		}
+		return nil
+	})
+	return changes, err
+}
@@ -0,0 +1,103 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+type recoveryCode struct {
+	userID int64
+	hash   string
+}
+
+type twoFactor struct {
+	s *Store
+}
+
+func (r *twoFactor) SetPending(ctx context.Context, userID int64, secret string) error {
+	return r.s.update(func(d *data) error {
+		user, ok := d.users[userID]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		user.TOTPSecret = secret
+		user.TOTPEnabled = false
+		user.TOTPLastStep = 0
+		d.users[userID] = user
+		return nil
+	})
+}
+
+func (r *twoFactor) Enable(ctx context.Context, userID int64, recoveryHashes []string) error {
+	return r.s.update(func(d *data) error {
+		user, ok := d.users[userID]
+		if !ok || user.TOTPSecret == "" {
+			return repository.ErrNotFound
+		}
+		user.TOTPEnabled = true
+		d.users[userID] = user
+		deleteRecoveryCodes(d, userID)
+		for _, hash := range recoveryHashes {
+			d.recovery[recoveryCode{userID: userID, hash: hash}] = time.Time{}
+		}
+		return nil
+	})
+}
+
+func (r *twoFactor) Disable(ctx context.Context, userID int64) error {
+	return r.s.update(func(d *data) error {
+		user, ok := d.users[userID]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		user.TOTPSecret = ""
+		user.TOTPEnabled = false
+		user.TOTPLastStep = 0
+		d.users[userID] = user
+		deleteRecoveryCodes(d, userID)
+		return nil
+	})
+}
+
+func (r *twoFactor) UseStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	var used bool
+	err := r.s.update(func(d *data) error {
+		user, ok := d.users[userID]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		if user.TOTPLastStep >= step {
+			return nil
+		}
+		user.TOTPLastStep = step
+		d.users[userID] = user
+		used = true
+		return nil
+	})
+	return used, err
+}
+
+func (r *twoFactor) UseRecoveryCode(ctx context.Context, userID int64, hash string, at time.Time) (bool, error) {
+	var used bool
+	err := r.s.update(func(d *data) error {
+		key := recoveryCode{userID: userID, hash: hash}
+		usedAt, ok := d.recovery[key]
+		if !ok || !usedAt.IsZero() {
+			return nil
+		}
+		d.recovery[key] = at
+		used = true
+		return nil
+	})
+	return used, err
+}
+
+func deleteRecoveryCodes(d *data, userID int64) {
+	for key := range d.recovery {
+		if key.userID == userID {
+			delete(d.recovery, key)
+		}
+	}
+}
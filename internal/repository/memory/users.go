@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+type users struct {
+	s *Store
+}
+
+func (r *users) Exists(ctx context.Context, login string) (bool, error) {
+	var userExists bool
+	err := r.s.view(func(d *data) error {
+		_, userExists = d.logins[login]
+		return nil
+	})
+	return userExists, err
+}
+
+func (r *users) Create(ctx context.Context, login, passwordHash string) (int64, error) {
+	var userID int64
+	err := r.s.update(func(d *data) error {
+		if _, ok := d.logins[login]; ok {
+			return repository.ErrConflict
+		}
+		d.nextUserID++
+		userID = d.nextUserID
+		d.users[userID] = models.User{ID: userID, Login: login, PasswordHash: passwordHash, Role: models.RoleUser}
+		d.logins[login] = userID
+		return nil
+	})
+	return userID, err
+}
+
+func (r *users) GetByLogin(ctx context.Context, login string) (models.User, error) {
+	var user models.User
+	err := r.s.view(func(d *data) error {
+		userID, ok := d.logins[login]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		user = d.users[userID]
+		return nil
+	})
+	return user, err
+}
+
+func (r *users) GetByID(ctx context.Context, userID int64) (models.User, error) {
+	var user models.User
+	err := r.s.view(func(d *data) error {
+		var ok bool
+		if user, ok = d.users[userID]; !ok {
+			return repository.ErrNotFound
+		}
+		return nil
+	})
+	return user, err
+}
+
+func (r *users) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
+	return r.change(userID, func(user *models.User) {
+		user.PasswordHash = passwordHash
+	})
+}
+
+func (r *users) SetRole(ctx context.Context, userID int64, role string) error {
+	return r.change(userID, func(user *models.User) {
+		user.Role = role
+	})
+}
+
+func (r *users) Lock(ctx context.Context, userID int64, reason string, at time.Time) error {
+	return r.change(userID, func(user *models.User) {
+		user.LockedAt = at
+		user.LockReason = reason
+	})
+}
+
+func (r *users) Unlock(ctx context.Context, userID int64) error {
+	return r.change(userID, func(user *models.User) {
+		user.LockedAt = time.Time{}
+		user.LockReason = ""
+	})
+}
+
+func (r *users) change(userID int64, fn func(user *models.User)) error {
+	return r.s.update(func(d *data) error {
+		user, ok := d.users[userID]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		fn(&user)
+		d.users[userID] = user
+		return nil
+	})
+}
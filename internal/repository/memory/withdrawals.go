@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+// withdrawals are stored as orders with a positive withdrawal, like in
+// postgres.
+type withdrawals struct {
+	s *Store
+}
+
+func (r *withdrawals) Create(
+	ctx context.Context,
+	userID int64,
+	orderNumber string,
+	sum models.Money,
+	accrualInfo models.AccrualInfo,
+) error {
+	return r.s.update(func(d *data) error {
+		for _, o := range d.orders {
+			if o.number == orderNumber && o.withdrawal > 0 {
+				return repository.ErrConflict
+			}
+		}
+		d.orders = append(d.orders, order{
+			userID:     userID,
+			number:     orderNumber,
+			status:     accrualInfo.OrderStatus(),
+			uploadedAt: time.Now(),
+			withdrawal: sum,
+			accrual:    accrualInfo.Accrual,
+		})
+		return nil
+	})
+}
+
+func (r *withdrawals) ListByUser(ctx context.Context, userID int64) ([]models.WithdrawResponse, error) {
+	var withdrawls []models.WithdrawResponse
+	err := r.s.view(func(d *data) error {
+		for _, o := range d.orders {
+			if o.userID == userID && o.withdrawal > 0 {
+				withdrawls = append(withdrawls, o.toWithdrawal())
+			}
+		}
+		return nil
+	})
+	return withdrawls, err
+}
+
+func (r *withdrawals) Get(ctx context.Context, orderNumber string) (models.WithdrawResponse, error) {
+	var withdrawal models.WithdrawResponse
+	err := r.s.view(func(d *data) error {
+		for _, o := range d.orders {
+			if o.number == orderNumber && o.withdrawal > 0 {
+				withdrawal = o.toWithdrawal()
+				return nil
+			}
+		}
+		return repository.ErrNotFound
+	})
+	return withdrawal, err
+}
+
+func (r *withdrawals) Refund(ctx context.Context, orderNumber string, amount models.Money) error {
+	return r.s.update(func(d *data) error {
+		for i, o := range d.orders {
+			if o.number != orderNumber || o.withdrawal == 0 {
+				continue
+			}
+			if o.reversed+amount > o.withdrawal {
+				return repository.ErrConflict
+			}
+			d.orders[i].reversed += amount
+			return nil
+		}
+		return repository.ErrNotFound
+	})
+}
+
+func (o order) toWithdrawal() models.WithdrawResponse {
+	return models.WithdrawResponse{
+		UserID:       o.userID,
+		OrderID:      o.number,
+		Sum:          o.withdrawal,
+		ProcessedAt:  o.uploadedAt,
+		Refunded:     o.reversed,
+		RefundStatus: models.ReversalStatus(o.withdrawal, o.reversed),
+	}
+}
@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+const adjustmentColumns = `id, user_id, amount, reason_code, comment, status, proposed_by, proposed_at,
+	COALESCE(decided_by, 0), decided_at, COALESCE(entry_id, 0)`
+
+type adjustments struct {
+	q querier
+}
+
+func (r *adjustments) Create(ctx context.Context, adjustment models.Adjustment) (int64, error) {
+	var adjustmentID int64
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO balance_adjustments (user_id, amount, reason_code, comment, proposed_by, proposed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`,
+		adjustment.UserID,
+		adjustment.Amount,
+		adjustment.ReasonCode,
+		adjustment.Comment,
+		adjustment.ProposedBy,
+		adjustment.ProposedAt,
+	).Scan(&adjustmentID)
+	if err != nil {
+		logger.Sugar.Errorf("Error insert balance adjustment: %s", err)
+		return 0, mapError(err)
+	}
+	return adjustmentID, nil
+}
+
+func (r *adjustments) Get(ctx context.Context, adjustmentID int64) (models.Adjustment, error) {
+	row := r.q.QueryRowContext(ctx, "SELECT "+adjustmentColumns+" FROM balance_adjustments WHERE id = $1", adjustmentID)
+	adjustment, err := scanAdjustment(row)
+	return adjustment, mapError(err)
+}
+
+func (r *adjustments) List(ctx context.Context, status string) ([]models.Adjustment, error) {
+	rows, err := r.q.QueryContext(ctx,
+		"SELECT "+adjustmentColumns+" FROM balance_adjustments WHERE ($1 = '' OR status = $1) ORDER BY id DESC",
+		status,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	adjustments := []models.Adjustment{}
+	for rows.Next() {
+		adjustment, err := scanAdjustment(rows)
+		if err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, adjustment)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Sugar.Errorf("Failed to iterate over rows: %v", err)
+		return nil, err
+	}
+	return adjustments, nil
+}
+
+func (r *adjustments) Decide(
+	ctx context.Context,
+	adjustmentID int64,
+	status string,
+	decidedBy int64,
+	at time.Time,
+	entryID int64,
+) error {
+	entry := sql.NullInt64{Int64: entryID, Valid: entryID != 0}
+	var id int64
+	err := r.q.QueryRowContext(ctx, `
+		UPDATE balance_adjustments SET status = $2, decided_by = $3, decided_at = $4, entry_id = $5
+		WHERE id = $1 AND status = $6
+		RETURNING id
+	`, adjustmentID, status, decidedBy, at, entry, models.AdjustmentPending).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrConflict
+	}
+	return mapError(err)
+}
+
+func scanAdjustment(row interface{ Scan(dest ...any) error }) (models.Adjustment, error) {
+	var adjustment models.Adjustment
+	var decidedAt sql.NullTime
+	err := row.Scan(
+		&adjustment.ID,
+		&adjustment.UserID,
+		&adjustment.Amount,
+		&adjustment.ReasonCode,
+		&adjustment.Comment,
+		&adjustment.Status,
+		&adjustment.ProposedBy,
+		&adjustment.ProposedAt,
+		&adjustment.DecidedBy,
+		&decidedAt,
+		&adjustment.EntryID,
+	)
+	if decidedAt.Valid {
+		adjustment.DecidedAt = &decidedAt.Time
+	}
+	return adjustment, err
+}
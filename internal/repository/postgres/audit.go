@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+type audit struct {
+	q querier
+}
+
+func (r *audit) Record(ctx context.Context, event models.AuditEvent) error {
+	actorID := sql.NullInt64{Int64: event.ActorID, Valid: event.ActorID != 0}
+	_, err := r.q.ExecContext(ctx,
+		"INSERT INTO audit_events (created_at, actor_id, action, subject, ip, details) VALUES ($1, $2, $3, $4, $5, $6)",
+		event.CreatedAt, actorID, event.Action, event.Subject, event.IP, event.Details,
+	)
+	if err != nil {
+		logger.Sugar.Errorf("Error insert audit event: %s", err)
+	}
+	return err
+}
+
+func (r *audit) List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := r.q.QueryContext(ctx,
+		`SELECT id, created_at, COALESCE(actor_id, 0), action, subject, ip, details FROM audit_events
+		WHERE ($1 = '' OR subject = $1) AND ($2 = 0 OR actor_id = $2)
+		ORDER BY id DESC LIMIT $3`,
+		filter.Subject, filter.ActorID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		if err := rows.Scan(&event.ID, &event.CreatedAt, &event.ActorID, &event.Action, &event.Subject, &event.IP, &event.Details); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Sugar.Errorf("Failed to iterate over rows: %v", err)
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+// BackfillLedger opens ledger accounts for users created before the ledger
+// existed. Their history is restored from user_balance and withdrawals so
+// that the ledger matches the projection; the projection itself is left
+// untouched. Run it under storage.WithMigrationLock; each user is also
+// locked and checked again, so a user is never backfilled twice.
+func (s *Store) BackfillLedger(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT b.user_id
+		FROM user_balance b
+		WHERE NOT EXISTS (SELECT 1 FROM ledger_accounts a WHERE a.user_id = b.user_id)
+	`)
+	if err != nil {
+		return err
+	}
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		if err := backfillUser(ctx, s.db, userID); err != nil {
+			logger.Sugar.Errorf("Failed to backfill ledger for user %d: %v", userID, err)
+			return err
+		}
+	}
+	if len(userIDs) > 0 {
+		logger.Sugar.Infof("Ledger backfilled for %d users", len(userIDs))
+	}
+	return nil
+}
+
+func backfillUser(ctx context.Context, db *sql.DB, userID int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current models.Money
+	var done bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT current_balance,
+			EXISTS (SELECT 1 FROM ledger_accounts a WHERE a.user_id = b.user_id)
+		FROM user_balance b WHERE b.user_id = $1 FOR UPDATE
+	`, userID).Scan(&current, &done)
+	if err != nil || done {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT order_id, withdrawal FROM orders WHERE user_id = $1 AND withdrawal > 0 ORDER BY upload_time",
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	var withdrawals []ledger.Entry
+	var withdrawn models.Money
+	for rows.Next() {
+		var orderNumber string
+		var sum models.Money
+		if err := rows.Scan(&orderNumber, &sum); err != nil {
+			rows.Close()
+			return err
+		}
+		withdrawn += sum
+		withdrawals = append(withdrawals, ledger.Withdrawal(userID, orderNumber, sum))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := ensureAccount(ctx, tx, ledger.UserAccount(userID)); err != nil {
+		return err
+	}
+	if opening := current + withdrawn; opening != 0 {
+		entry := ledger.Adjustment(userID, "", "Opening balance", opening)
+		if _, err := postEntry(ctx, tx, entry, false); err != nil {
+			return err
+		}
+	}
+	for _, entry := range withdrawals {
+		if _, err := postEntry(ctx, tx, entry, false); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
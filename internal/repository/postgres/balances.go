@@ -0,0 +1,243 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+// balances keeps the ledger tables and the user_balance projection, which
+// is updated in the same transaction as the postings.
+type balances struct {
+	q querier
+}
+
+func (r *balances) Create(ctx context.Context, userID int64) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO user_balance (user_id, current_balance)
+		VALUES ($1, 0)
+	`, userID)
+	if err != nil {
+		logger.Sugar.Errorf("Error insert user balance to db: %s", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+func (r *balances) Current(ctx context.Context, userID int64) (models.Money, error) {
+	var current models.Money
+	err := r.q.QueryRowContext(ctx, "SELECT current_balance FROM user_balance WHERE user_id = $1", userID).Scan(&current)
+	return current, mapError(err)
+}
+
+func (r *balances) CurrentForUpdate(ctx context.Context, userID int64) (models.Money, error) {
+	var current models.Money
+	err := r.q.QueryRowContext(ctx, "SELECT current_balance FROM user_balance WHERE user_id = $1 FOR UPDATE", userID).Scan(&current)
+	return current, mapError(err)
+}
+
+func (r *balances) Post(ctx context.Context, entry ledger.Entry) (int64, error) {
+	return postEntry(ctx, r.q, entry, true)
+}
+
+func postEntry(ctx context.Context, q querier, entry ledger.Entry, project bool) (int64, error) {
+	if err := entry.Validate(); err != nil {
+		return 0, err
+	}
+	var reverses sql.NullInt64
+	if entry.Reverses != 0 {
+		reverses = sql.NullInt64{Int64: entry.Reverses, Valid: true}
+	}
+
+	var entryID int64
+	err := q.QueryRowContext(ctx,
+		"INSERT INTO ledger_entries (kind, reference, description, reverses) VALUES ($1, $2, $3, $4) RETURNING id",
+		entry.Kind,
+		entry.Reference,
+		entry.Description,
+		reverses,
+	).Scan(&entryID)
+	if err != nil {
+		logger.Sugar.Errorf("Failed to insert ledger entry: %v", err)
+		return 0, mapError(err)
+	}
+	for _, p := range entry.Postings {
+		accountID, err := ensureAccount(ctx, q, p.Account)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := q.ExecContext(ctx,
+			"INSERT INTO ledger_postings (entry_id, account_id, amount) VALUES ($1, $2, $3)",
+			entryID,
+			accountID,
+			p.Amount,
+		); err != nil {
+			logger.Sugar.Errorf("Failed to insert ledger posting: %v", err)
+			return 0, err
+		}
+		userID, ok := ledger.ParseUserAccount(p.Account)
+		if !ok || !project {
+			continue
+		}
+		if _, err := q.ExecContext(ctx,
+			"UPDATE user_balance SET current_balance = current_balance + $1 WHERE user_id = $2",
+			p.Amount,
+			userID,
+		); err != nil {
+			logger.Sugar.Errorf("Failed to update user balance: %v", err)
+			return 0, err
+		}
+	}
+	logger.Sugar.Infof("Ledger entry %d (%s %s) posted", entryID, entry.Kind, entry.Reference)
+	return entryID, nil
+}
+
+// ensureAccount returns the id of the account, creating it on first use.
+// Existing accounts are only read: system accounts take part in almost
+// every posting, and writing their row would serialize all of them.
+func ensureAccount(ctx context.Context, q querier, code string) (int64, error) {
+	var userID sql.NullInt64
+	if id, ok := ledger.ParseUserAccount(code); ok {
+		userID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	const lookup = "SELECT id FROM ledger_accounts WHERE code = $1"
+	var accountID int64
+	err := q.QueryRowContext(ctx, lookup, code).Scan(&accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = q.QueryRowContext(ctx,
+			"INSERT INTO ledger_accounts (code, user_id) VALUES ($1, $2) ON CONFLICT (code) DO NOTHING RETURNING id",
+			code, userID,
+		).Scan(&accountID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Created by a concurrent transaction, which has committed
+			// by the time the insert returns.
+			err = q.QueryRowContext(ctx, lookup, code).Scan(&accountID)
+		}
+	}
+	if err != nil {
+		logger.Sugar.Errorf("Failed to get ledger account %s: %v", code, err)
+		return 0, err
+	}
+	return accountID, nil
+}
+
+func (r *balances) Entry(ctx context.Context, entryID int64) (ledger.Entry, error) {
+	var entry ledger.Entry
+	var reverses sql.NullInt64
+	err := r.q.QueryRowContext(ctx,
+		"SELECT id, kind, reference, description, reverses, created_at FROM ledger_entries WHERE id = $1",
+		entryID,
+	).Scan(&entry.ID, &entry.Kind, &entry.Reference, &entry.Description, &reverses, &entry.CreatedAt)
+	if err != nil {
+		return ledger.Entry{}, mapError(err)
+	}
+	entry.Reverses = reverses.Int64
+
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT a.code, p.amount
+		FROM ledger_postings p JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE p.entry_id = $1 ORDER BY p.id
+	`, entryID)
+	if err != nil {
+		return ledger.Entry{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p ledger.Posting
+		if err := rows.Scan(&p.Account, &p.Amount); err != nil {
+			return ledger.Entry{}, err
+		}
+		entry.Postings = append(entry.Postings, p)
+	}
+	return entry, rows.Err()
+}
+
+func (r *balances) Entries(ctx context.Context, userID int64) ([]ledger.Entry, error) {
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT DISTINCT e.id
+		FROM ledger_entries e
+		JOIN ledger_postings p ON p.entry_id = e.id
+		JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE a.code = $1
+		ORDER BY e.id
+	`, ledger.UserAccount(userID))
+	if err != nil {
+		return nil, err
+	}
+	var entryIDs []int64
+	for rows.Next() {
+		var entryID int64
+		if err := rows.Scan(&entryID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		entryIDs = append(entryIDs, entryID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]ledger.Entry, 0, len(entryIDs))
+	for _, entryID := range entryIDs {
+		entry, err := r.Entry(ctx, entryID)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (r *balances) Balance(ctx context.Context, userID int64) (models.Money, error) {
+	var balance models.Money
+	err := r.q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(p.amount), 0)
+		FROM ledger_postings p JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE a.code = $1
+	`, ledger.UserAccount(userID)).Scan(&balance)
+	return balance, err
+}
+
+func (r *balances) BalanceAt(ctx context.Context, userID int64, at time.Time) (models.Money, error) {
+	var balance models.Money
+	err := r.q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(p.amount), 0)
+		FROM ledger_postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		JOIN ledger_entries e ON e.id = p.entry_id
+		WHERE a.code = $1 AND e.created_at <= $2
+	`, ledger.UserAccount(userID), at).Scan(&balance)
+	return balance, err
+}
+
+func (r *balances) Accrued(ctx context.Context, userID int64, since time.Time) (models.Money, error) {
+	var accrued models.Money
+	err := r.q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(p.amount), 0)
+		FROM ledger_postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		JOIN ledger_entries e ON e.id = p.entry_id
+		LEFT JOIN ledger_entries o ON o.id = e.reverses
+		WHERE a.code = $1 AND (e.kind = $2 OR o.kind = $2) AND e.created_at >= $3
+	`, ledger.UserAccount(userID), ledger.KindAccrual, since).Scan(&accrued)
+	return accrued, err
+}
+
+func (r *balances) Withdrawn(ctx context.Context, userID int64) (models.Money, error) {
+	var withdrawn models.Money
+	err := r.q.QueryRowContext(ctx, `
+		SELECT COALESCE(-SUM(p.amount), 0)
+		FROM ledger_postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		JOIN ledger_entries e ON e.id = p.entry_id
+		LEFT JOIN ledger_entries o ON o.id = e.reverses
+		WHERE a.code = $1 AND (e.kind = $2 OR o.kind = $2)
+	`, ledger.UserAccount(userID), ledger.KindWithdrawal).Scan(&withdrawn)
+	return withdrawn, err
+}
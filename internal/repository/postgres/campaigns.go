@@ -0,0 +1,216 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+const campaignColumns = `id, name, active, starts_at, ends_at, min_accrual, first_order, fixed, rate_bp,
+	user_budget, budget, spent, created_by, created_at`
+
+const awardColumns = "id, campaign_id, user_id, order_id, amount, entry_id, created_at"
+
+type campaigns struct {
+	q querier
+}
+
+func (r *campaigns) Create(ctx context.Context, campaign models.Campaign) (int64, error) {
+	var campaignID int64
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO campaigns (name, active, starts_at, ends_at, min_accrual, first_order, fixed, rate_bp,
+			user_budget, budget, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id
+	`,
+		campaign.Name,
+		campaign.Active,
+		campaign.StartsAt,
+		campaign.EndsAt,
+		campaign.MinAccrual,
+		campaign.FirstOrder,
+		campaign.Fixed,
+		campaign.Rate,
+		campaign.UserBudget,
+		campaign.Budget,
+		campaign.CreatedBy,
+		campaign.CreatedAt,
+	).Scan(&campaignID)
+	if err != nil {
+		logger.Sugar.Errorf("Error insert campaign: %s", err)
+		return 0, mapError(err)
+	}
+	return campaignID, nil
+}
+
+func (r *campaigns) Get(ctx context.Context, campaignID int64) (models.Campaign, error) {
+	campaign, err := scanCampaign(r.q.QueryRowContext(ctx, "SELECT "+campaignColumns+" FROM campaigns WHERE id = $1", campaignID))
+	return campaign, mapError(err)
+}
+
+func (r *campaigns) GetForUpdate(ctx context.Context, campaignID int64) (models.Campaign, error) {
+	campaign, err := scanCampaign(r.q.QueryRowContext(ctx, "SELECT "+campaignColumns+" FROM campaigns WHERE id = $1 FOR UPDATE", campaignID))
+	return campaign, mapError(err)
+}
+
+func (r *campaigns) List(ctx context.Context) ([]models.Campaign, error) {
+	return r.list(ctx, "SELECT "+campaignColumns+" FROM campaigns ORDER BY id DESC")
+}
+
+func (r *campaigns) ListRunning(ctx context.Context, at time.Time) ([]models.Campaign, error) {
+	return r.list(ctx,
+		"SELECT "+campaignColumns+" FROM campaigns WHERE active AND starts_at <= $1 AND ends_at > $1 ORDER BY id",
+		at,
+	)
+}
+
+func (r *campaigns) Update(ctx context.Context, campaign models.Campaign) error {
+	var id int64
+	err := r.q.QueryRowContext(ctx, `
+		UPDATE campaigns SET name = $2, active = $3, starts_at = $4, ends_at = $5, min_accrual = $6,
+			first_order = $7, fixed = $8, rate_bp = $9, user_budget = $10, budget = $11
+		WHERE id = $1
+		RETURNING id
+	`,
+		campaign.ID,
+		campaign.Name,
+		campaign.Active,
+		campaign.StartsAt,
+		campaign.EndsAt,
+		campaign.MinAccrual,
+		campaign.FirstOrder,
+		campaign.Fixed,
+		campaign.Rate,
+		campaign.UserBudget,
+		campaign.Budget,
+	).Scan(&id)
+	return mapError(err)
+}
+
+func (r *campaigns) Award(ctx context.Context, award models.CampaignAward) (int64, error) {
+	var awardID int64
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO campaign_awards (campaign_id, user_id, order_id, amount, entry_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`,
+		award.CampaignID,
+		award.UserID,
+		award.Order,
+		award.Amount,
+		award.EntryID,
+		award.CreatedAt,
+	).Scan(&awardID)
+	if err != nil {
+		logger.Sugar.Errorf("Error insert campaign award: %s", err)
+		return 0, mapError(err)
+	}
+	if _, err := r.q.ExecContext(ctx,
+		"UPDATE campaigns SET spent = spent + $2 WHERE id = $1",
+		award.CampaignID,
+		award.Amount,
+	); err != nil {
+		logger.Sugar.Errorf("Failed to update campaign spending: %v", err)
+		return 0, err
+	}
+	return awardID, nil
+}
+
+func (r *campaigns) Awarded(ctx context.Context, campaignID, userID int64) (models.Money, error) {
+	var awarded models.Money
+	err := r.q.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(amount), 0) FROM campaign_awards WHERE campaign_id = $1 AND user_id = $2",
+		campaignID,
+		userID,
+	).Scan(&awarded)
+	return awarded, err
+}
+
+func (r *campaigns) Awards(ctx context.Context, campaignID int64) ([]models.CampaignAward, error) {
+	return r.awards(ctx,
+		"SELECT "+awardColumns+" FROM campaign_awards WHERE campaign_id = $1 ORDER BY id DESC",
+		campaignID,
+	)
+}
+
+func (r *campaigns) OrderAwards(ctx context.Context, orderNumber string) ([]models.CampaignAward, error) {
+	return r.awards(ctx,
+		"SELECT "+awardColumns+" FROM campaign_awards WHERE order_id = $1 ORDER BY id",
+		orderNumber,
+	)
+}
+
+func (r *campaigns) awards(ctx context.Context, query string, args ...any) ([]models.CampaignAward, error) {
+	rows, err := r.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	awards := []models.CampaignAward{}
+	for rows.Next() {
+		var award models.CampaignAward
+		if err := rows.Scan(
+			&award.ID,
+			&award.CampaignID,
+			&award.UserID,
+			&award.Order,
+			&award.Amount,
+			&award.EntryID,
+			&award.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		awards = append(awards, award)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Sugar.Errorf("Failed to iterate over rows: %v", err)
+		return nil, err
+	}
+	return awards, nil
+}
+
+func (r *campaigns) list(ctx context.Context, query string, args ...any) ([]models.Campaign, error) {
+	rows, err := r.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	campaigns := []models.Campaign{}
+	for rows.Next() {
+		campaign, err := scanCampaign(rows)
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, campaign)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Sugar.Errorf("Failed to iterate over rows: %v", err)
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+func scanCampaign(row interface{ Scan(dest ...any) error }) (models.Campaign, error) {
+	var campaign models.Campaign
+	err := row.Scan(
+		&campaign.ID,
+		&campaign.Name,
+		&campaign.Active,
+		&campaign.StartsAt,
+		&campaign.EndsAt,
+		&campaign.MinAccrual,
+		&campaign.FirstOrder,
+		&campaign.Fixed,
+		&campaign.Rate,
+		&campaign.UserBudget,
+		&campaign.Budget,
+		&campaign.Spent,
+		&campaign.CreatedBy,
+		&campaign.CreatedAt,
+	)
+	return campaign, err
+}
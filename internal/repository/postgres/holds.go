@@ -0,0 +1,113 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+const holdColumns = "id, user_id, order_id, amount, status, created_at, expires_at, closed_at"
+
+type holds struct {
+	q querier
+}
+
+func (r *holds) Create(ctx context.Context, hold models.Hold) (int64, error) {
+	var holdID int64
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO balance_holds (user_id, order_id, amount, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, hold.UserID, hold.Order, hold.Sum, hold.CreatedAt, hold.ExpiresAt).Scan(&holdID)
+	if err != nil {
+		logger.Sugar.Errorf("Error insert balance hold: %s", err)
+		return 0, mapError(err)
+	}
+	return holdID, nil
+}
+
+func (r *holds) Get(ctx context.Context, holdID int64) (models.Hold, error) {
+	hold, err := scanHold(r.q.QueryRowContext(ctx, "SELECT "+holdColumns+" FROM balance_holds WHERE id = $1", holdID))
+	return hold, mapError(err)
+}
+
+func (r *holds) ListByUser(ctx context.Context, userID int64) ([]models.Hold, error) {
+	rows, err := r.q.QueryContext(ctx, "SELECT "+holdColumns+" FROM balance_holds WHERE user_id = $1 ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []models.Hold{}
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Sugar.Errorf("Failed to iterate over rows: %v", err)
+		return nil, err
+	}
+	return holds, nil
+}
+
+func (r *holds) Held(ctx context.Context, userID int64, at time.Time) (models.Money, error) {
+	var held models.Money
+	err := r.q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM balance_holds
+		WHERE user_id = $1 AND status = $2 AND expires_at > $3
+	`, userID, models.HoldActive, at).Scan(&held)
+	return held, err
+}
+
+func (r *holds) Close(ctx context.Context, holdID int64, status string, at time.Time) error {
+	var id int64
+	err := r.q.QueryRowContext(ctx, `
+		UPDATE balance_holds SET status = $2, closed_at = $3
+		WHERE id = $1 AND status = $4 AND expires_at > $3
+		RETURNING id
+	`, holdID, status, at, models.HoldActive).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrConflict
+	}
+	return mapError(err)
+}
+
+func (r *holds) Expire(ctx context.Context, at time.Time) (int64, error) {
+	var expired int64
+	err := r.q.QueryRowContext(ctx, `
+		WITH expired AS (
+			UPDATE balance_holds SET status = $1, closed_at = expires_at
+			WHERE status = $2 AND expires_at <= $3
+			RETURNING id
+		)
+		SELECT COUNT(*) FROM expired
+	`, models.HoldExpired, models.HoldActive, at).Scan(&expired)
+	return expired, err
+}
+
+func scanHold(row interface{ Scan(dest ...any) error }) (models.Hold, error) {
+	var hold models.Hold
+	var closedAt sql.NullTime
+	err := row.Scan(
+		&hold.ID,
+		&hold.UserID,
+		&hold.Order,
+		&hold.Sum,
+		&hold.Status,
+		&hold.CreatedAt,
+		&hold.ExpiresAt,
+		&closedAt,
+	)
+	if closedAt.Valid {
+		hold.ClosedAt = &closedAt.Time
+	}
+	return hold, err
+}
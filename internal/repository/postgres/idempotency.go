@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+type idempotency struct {
+	q querier
+}
+
+// Begin inserts the key, or takes over an expired or abandoned one, in one
+// statement so that concurrent requests with the same key can not both
+// claim it.
+func (r *idempotency) Begin(
+	ctx context.Context,
+	record models.IdempotencyRecord,
+	expiredBefore time.Time,
+) (models.IdempotencyRecord, bool, error) {
+	var userID int64
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint, created_at, claim, locked_until)
+		VALUES ($1, $2, $3, $4, $6, $7)
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status_code = 0, content_type = '', body = NULL,
+			created_at = EXCLUDED.created_at, claim = EXCLUDED.claim, locked_until = EXCLUDED.locked_until
+		WHERE idempotency_keys.created_at < $5
+			OR (idempotency_keys.status_code = 0 AND COALESCE(idempotency_keys.locked_until < $4, TRUE))
+		RETURNING user_id
+	`, record.UserID, record.Key, record.Fingerprint, record.CreatedAt, expiredBefore, record.Claim, record.LockedUntil).Scan(&userID)
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		logger.Sugar.Errorf("Error claim idempotency key: %s", err)
+		return models.IdempotencyRecord{}, false, err
+	}
+
+	stored := models.IdempotencyRecord{UserID: record.UserID, Key: record.Key}
+	var lockedUntil sql.NullTime
+	err = r.q.QueryRowContext(ctx, `
+		SELECT fingerprint, status_code, content_type, COALESCE(body, ''::bytea), created_at, claim, locked_until
+		FROM idempotency_keys WHERE user_id = $1 AND key = $2
+	`, record.UserID, record.Key).Scan(
+		&stored.Fingerprint, &stored.StatusCode, &stored.ContentType, &stored.Body, &stored.CreatedAt, &stored.Claim, &lockedUntil,
+	)
+	if err != nil {
+		return models.IdempotencyRecord{}, false, mapError(err)
+	}
+	stored.LockedUntil = lockedUntil.Time
+	return stored, false, nil
+}
+
+func (r *idempotency) Complete(
+	ctx context.Context,
+	userID int64,
+	key string,
+	claim string,
+	statusCode int,
+	contentType string,
+	body []byte,
+) error {
+	var id int64
+	err := r.q.QueryRowContext(ctx, `
+		UPDATE idempotency_keys SET status_code = $4, content_type = $5, body = $6, locked_until = NULL
+		WHERE user_id = $1 AND key = $2 AND claim = $3 AND status_code = 0
+		RETURNING user_id
+	`, userID, key, claim, statusCode, contentType, body).Scan(&id)
+	return mapError(err)
+}
+
+func (r *idempotency) Delete(ctx context.Context, userID int64, key, claim string) error {
+	_, err := r.q.ExecContext(ctx,
+		"DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND claim = $3 AND status_code = 0",
+		userID, key, claim,
+	)
+	return err
+}
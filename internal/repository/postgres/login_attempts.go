@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+type loginAttempts struct {
+	q querier
+}
+
+func (r *loginAttempts) Get(ctx context.Context, key string) (models.LoginAttempts, error) {
+	return r.scan(r.q.QueryRowContext(ctx,
+		"SELECT key, failures, last_failure_at, locked_until FROM login_attempts WHERE key = $1",
+		key,
+	))
+}
+
+// Attempt is a single upsert whose update only happens when the key is
+// not blocked; the blocking rules are models.LoginAttempts.BlockedUntil.
+// Concurrent attempts are serialized on the row, so each one sees the
+// failures counted before it.
+func (r *loginAttempts) Attempt(ctx context.Context, key string, at time.Time, limits models.AttemptLimits) (models.LoginAttempts, bool, error) {
+	attempts, err := r.scan(r.q.QueryRowContext(ctx,
+		`INSERT INTO login_attempts (key, failures, last_failure_at) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < $2 - make_interval(secs => $5)
+				THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = $2
+		WHERE NOT (
+			COALESCE(login_attempts.locked_until > $2, FALSE)
+			OR ($3 > 0 AND login_attempts.failures >= $3
+				AND login_attempts.last_failure_at + make_interval(secs => $5) > $2)
+			OR ($4 > 0 AND login_attempts.failures > 0
+				AND login_attempts.last_failure_at + make_interval(secs =>
+					LEAST($4 * power(2, LEAST(login_attempts.failures - 1, 30)), $5)) > $2)
+		)
+		RETURNING key, failures, last_failure_at, locked_until`,
+		key, at, limits.MaxFailures, limits.Backoff.Seconds(), limits.Lockout.Seconds(),
+	))
+	if errors.Is(err, repository.ErrNotFound) {
+		attempts, err = r.Get(ctx, key)
+		return attempts, false, err
+	}
+	if err != nil {
+		logger.Sugar.Errorf("Error record login attempt: %s", err)
+		return models.LoginAttempts{}, false, err
+	}
+	return attempts, true, nil
+}
+
+func (r *loginAttempts) Forgive(ctx context.Context, key string) error {
+	_, err := r.q.ExecContext(ctx,
+		"UPDATE login_attempts SET failures = GREATEST(failures - 1, 0) WHERE key = $1",
+		key,
+	)
+	if err != nil {
+		logger.Sugar.Errorf("Error forgive login attempt: %s", err)
+	}
+	return err
+}
+
+func (r *loginAttempts) Lock(ctx context.Context, key string, until time.Time) error {
+	var locked string
+	err := r.q.QueryRowContext(ctx,
+		"UPDATE login_attempts SET locked_until = $2 WHERE key = $1 RETURNING key",
+		key, until,
+	).Scan(&locked)
+	if err != nil {
+		logger.Sugar.Errorf("Error lock login: %s", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+func (r *loginAttempts) Reset(ctx context.Context, key string) error {
+	_, err := r.q.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	if err != nil {
+		logger.Sugar.Errorf("Error reset login attempts: %s", err)
+	}
+	return err
+}
+
+func (r *loginAttempts) scan(row *sql.Row) (models.LoginAttempts, error) {
+	var attempts models.LoginAttempts
+	var lockedUntil sql.NullTime
+	if err := row.Scan(&attempts.Key, &attempts.Failures, &attempts.LastFailureAt, &lockedUntil); err != nil {
+		return models.LoginAttempts{}, mapError(err)
+	}
+	attempts.LockedUntil = lockedUntil.Time
+	return attempts, nil
+}
@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+const lotColumns = "id, user_id, order_id, amount, remaining, earned_at, expired_at"
+
+type lots struct {
+	q querier
+}
+
+func (r *lots) Create(ctx context.Context, lot models.Lot) (int64, error) {
+	var lotID int64
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO point_lots (user_id, order_id, amount, remaining, earned_at)
+		VALUES ($1, $2, $3, $3, $4)
+		RETURNING id
+	`, lot.UserID, lot.Order, lot.Amount, lot.EarnedAt).Scan(&lotID)
+	if err != nil {
+		logger.Sugar.Errorf("Error insert point lot: %s", err)
+		return 0, mapError(err)
+	}
+	return lotID, nil
+}
+
+// Consume spreads amount over the lots with a running total, so the whole
+// FIFO pass is a single statement.
+func (r *lots) Consume(ctx context.Context, userID int64, orderNumber string, amount models.Money) error {
+	_, err := r.q.ExecContext(ctx, `
+		WITH ordered AS (
+			SELECT id, remaining,
+				SUM(remaining) OVER (ORDER BY ($2 <> '' AND order_id = $2) DESC, earned_at, id) - remaining AS before
+			FROM point_lots
+			WHERE user_id = $1 AND remaining > 0
+		)
+		UPDATE point_lots l
+		SET remaining = o.remaining - LEAST(o.remaining, $3 - o.before)
+		FROM ordered o
+		WHERE l.id = o.id AND o.before < $3
+	`, userID, orderNumber, amount)
+	if err != nil {
+		logger.Sugar.Errorf("Failed to consume point lots: %v", err)
+	}
+	return err
+}
+
+func (r *lots) ListActive(ctx context.Context, userID int64) ([]models.Lot, error) {
+	return r.list(ctx,
+		"SELECT "+lotColumns+" FROM point_lots WHERE user_id = $1 AND remaining > 0 ORDER BY earned_at, id",
+		userID,
+	)
+}
+
+func (r *lots) ListExpired(ctx context.Context, earnedBefore, at time.Time, limit int) ([]models.Lot, error) {
+	return r.list(ctx, `
+		SELECT `+lotColumns+` FROM point_lots l
+		WHERE remaining > 0 AND earned_at < $1
+			AND NOT EXISTS (
+				SELECT 1 FROM balance_holds h
+				WHERE h.user_id = l.user_id AND h.status = $2 AND h.expires_at > $3
+			)
+		ORDER BY earned_at, id LIMIT $4
+	`,
+		earnedBefore,
+		models.HoldActive,
+		at,
+		limit,
+	)
+}
+
+func (r *lots) GetForUpdate(ctx context.Context, lotID int64) (models.Lot, error) {
+	lot, err := scanLot(r.q.QueryRowContext(ctx,
+		"SELECT "+lotColumns+" FROM point_lots WHERE id = $1 FOR UPDATE",
+		lotID,
+	))
+	if err != nil {
+		return models.Lot{}, mapError(err)
+	}
+	return lot, nil
+}
+
+func (r *lots) Expire(ctx context.Context, lotID int64, keep models.Money, at time.Time) error {
+	var id int64
+	err := r.q.QueryRowContext(ctx, `
+		UPDATE point_lots SET remaining = $2, expired_at = CASE WHEN $2 = 0 THEN $3::timestamp END
+		WHERE id = $1 AND remaining > $2
+		RETURNING id
+	`, lotID, keep, at).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *lots) list(ctx context.Context, query string, args ...any) ([]models.Lot, error) {
+	rows, err := r.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lots := []models.Lot{}
+	for rows.Next() {
+		lot, err := scanLot(rows)
+		if err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Sugar.Errorf("Failed to iterate over rows: %v", err)
+		return nil, err
+	}
+	return lots, nil
+}
+
+func scanLot(row interface{ Scan(dest ...any) error }) (models.Lot, error) {
+	var lot models.Lot
+	var expiredAt sql.NullTime
+	err := row.Scan(
+		&lot.ID,
+		&lot.UserID,
+		&lot.Order,
+		&lot.Amount,
+		&lot.Remaining,
+		&lot.EarnedAt,
+		&expiredAt,
+	)
+	if expiredAt.Valid {
+		lot.ExpiredAt = &expiredAt.Time
+	}
+	return lot, err
+}
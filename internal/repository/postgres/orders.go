@@ -0,0 +1,188 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+type orders struct {
+	q querier
+}
+
+func (r *orders) Create(ctx context.Context, userID int64, orderNumber string) error {
+	_, err := r.q.ExecContext(ctx,
+		"INSERT INTO orders (user_id, order_id, status) VALUES ($1, $2, $3)",
+		userID,
+		orderNumber,
+		models.OrderStatusNew,
+	)
+	if err != nil {
+		logger.Sugar.Errorf("Failed to insert order: %v", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+func (r *orders) Exists(ctx context.Context, orderNumber string) (bool, error) {
+	var orderExists bool
+	if err := r.q.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM orders WHERE order_id = $1)", orderNumber).Scan(&orderExists); err != nil {
+		return false, err
+	}
+	return orderExists, nil
+}
+
+func (r *orders) ExistsForUser(ctx context.Context, userID int64, orderNumber string) (bool, error) {
+	var orderExists bool
+	if err := r.q.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM orders WHERE user_id = $1 AND order_id = $2)", userID, orderNumber).Scan(&orderExists); err != nil {
+		return false, err
+	}
+	return orderExists, nil
+}
+
+func (r *orders) ListByUser(ctx context.Context, userID int64) ([]models.Order, error) {
+	rows, err := r.q.QueryContext(ctx,
+		"SELECT "+orderColumns+" FROM orders WHERE user_id = $1 ORDER BY upload_time DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []models.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Sugar.Errorf("Failed to iterate over rows: %v", err)
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (r *orders) ClaimPending(ctx context.Context, limit int, backoff, maxBackoff time.Duration) ([]string, error) {
+	rows, err := r.q.QueryContext(ctx, `
+		UPDATE orders SET
+			next_poll_at = CURRENT_TIMESTAMP + make_interval(secs => LEAST($4 * power(2, LEAST(poll_attempts, 30)), $5)),
+			poll_attempts = poll_attempts + 1
+		WHERE withdrawal = 0 AND order_id IN (
+			SELECT order_id FROM orders
+			WHERE status IN ($1, $2) AND withdrawal = 0 AND next_poll_at <= CURRENT_TIMESTAMP
+			ORDER BY next_poll_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING order_id
+	`,
+		models.OrderStatusNew,
+		models.OrderStatusProcessing,
+		limit,
+		backoff.Seconds(),
+		maxBackoff.Seconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orderNumbers []string
+	for rows.Next() {
+		var orderNumber string
+		if err := rows.Scan(&orderNumber); err != nil {
+			return nil, err
+		}
+		orderNumbers = append(orderNumbers, orderNumber)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Sugar.Errorf("Failed to iterate over rows: %v", err)
+		return nil, err
+	}
+	return orderNumbers, nil
+}
+
+func (r *orders) UpdateAccrual(ctx context.Context, orderNumber, status string, accrual models.Money) (int64, error) {
+	var userID int64
+	err := r.q.QueryRowContext(ctx,
+		"UPDATE orders SET status = $1, accrual = $2 WHERE order_id = $3 AND status IN ($4, $5) AND withdrawal = 0 RETURNING user_id",
+		status,
+		accrual,
+		orderNumber,
+		models.OrderStatusNew,
+		models.OrderStatusProcessing,
+	).Scan(&userID)
+	if err != nil {
+		return 0, mapError(err)
+	}
+	return userID, nil
+}
+
+func (r *orders) Requeue(ctx context.Context, orderNumber string) (int64, error) {
+	var userID int64
+	var status string
+	err := r.q.QueryRowContext(ctx,
+		"SELECT user_id, status FROM orders WHERE order_id = $1 AND withdrawal = 0 FOR UPDATE",
+		orderNumber,
+	).Scan(&userID, &status)
+	if err != nil {
+		return 0, mapError(err)
+	}
+	if status == models.OrderStatusProcessed {
+		return 0, repository.ErrConflict
+	}
+	_, err = r.q.ExecContext(ctx,
+		"UPDATE orders SET status = $1, next_poll_at = CURRENT_TIMESTAMP, poll_attempts = 0 WHERE order_id = $2 AND withdrawal = 0",
+		models.OrderStatusNew,
+		orderNumber,
+	)
+	if err != nil {
+		logger.Sugar.Errorf("Failed to requeue order: %v", err)
+		return 0, err
+	}
+	return userID, nil
+}
+
+func (r *orders) Get(ctx context.Context, orderNumber string) (models.Order, error) {
+	order, err := scanOrder(r.q.QueryRowContext(ctx,
+		"SELECT "+orderColumns+" FROM orders WHERE order_id = $1 AND withdrawal = 0",
+		orderNumber,
+	))
+	if err != nil {
+		return models.Order{}, mapError(err)
+	}
+	return order, nil
+}
+
+func (r *orders) Reverse(ctx context.Context, orderNumber string, amount models.Money) error {
+	var userID int64
+	err := r.q.QueryRowContext(ctx, `
+		UPDATE orders SET reversed = reversed + $2
+		WHERE order_id = $1 AND withdrawal = 0 AND status = $3 AND reversed + $2 <= accrual
+		RETURNING user_id
+	`, orderNumber, amount, models.OrderStatusProcessed).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+// Refunds of withdrawal rows are not reversals of their accrual.
+const orderColumns = "user_id, order_id, status, upload_time, accrual, CASE WHEN withdrawal = 0 THEN reversed ELSE 0 END"
+
+func scanOrder(row interface{ Scan(dest ...any) error }) (models.Order, error) {
+	var order models.Order
+	if err := row.Scan(&order.UserID, &order.Number, &order.Status, &order.UploadedAt, &order.Accrual, &order.Reversed); err != nil {
+		return models.Order{}, err
+	}
+	order.ReversalStatus = models.ReversalStatus(order.Accrual, order.Reversed)
+	return order, nil
+}
@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+type passwordResets struct {
+	q querier
+}
+
+func (r *passwordResets) Create(ctx context.Context, token models.PasswordResetToken) error {
+	_, err := r.q.ExecContext(ctx,
+		"INSERT INTO password_reset_tokens (token_hash, user_id, created_at, expires_at) VALUES ($1, $2, $3, $4)",
+		token.Hash, token.UserID, token.CreatedAt, token.ExpiresAt,
+	)
+	if err != nil {
+		logger.Sugar.Errorf("Error insert password reset token: %s", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+func (r *passwordResets) Use(ctx context.Context, hash string, at time.Time) (models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	var usedAt sql.NullTime
+	err := r.q.QueryRowContext(ctx,
+		`UPDATE password_reset_tokens SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		RETURNING token_hash, user_id, created_at, expires_at, used_at`,
+		hash, at,
+	).Scan(&token.Hash, &token.UserID, &token.CreatedAt, &token.ExpiresAt, &usedAt)
+	if err != nil {
+		return models.PasswordResetToken{}, mapError(err)
+	}
+	token.UsedAt = usedAt.Time
+	return token, nil
+}
@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+type sessions struct {
+	q querier
+}
+
+func (r *sessions) Create(ctx context.Context, session models.Session) error {
+	_, err := r.q.ExecContext(ctx,
+		"INSERT INTO sessions (id, user_id, created_at) VALUES ($1, $2, $3)",
+		session.ID, session.UserID, session.CreatedAt,
+	)
+	if err != nil {
+		logger.Sugar.Errorf("Error insert session: %s", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+func (r *sessions) Get(ctx context.Context, sessionID string) (models.Session, error) {
+	var session models.Session
+	var revokedAt sql.NullTime
+	err := r.q.QueryRowContext(ctx,
+		"SELECT id, user_id, created_at, revoked_at, revoke_reason FROM sessions WHERE id = $1",
+		sessionID,
+	).Scan(&session.ID, &session.UserID, &session.CreatedAt, &revokedAt, &session.RevokeReason)
+	if err != nil {
+		return models.Session{}, mapError(err)
+	}
+	session.RevokedAt = revokedAt.Time
+	return session, nil
+}
+
+func (r *sessions) Revoke(ctx context.Context, sessionID, reason string) error {
+	var id string
+	err := r.q.QueryRowContext(ctx,
+		`UPDATE sessions SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP),
+			revoke_reason = CASE WHEN revoked_at IS NULL THEN $2 ELSE revoke_reason END
+		WHERE id = $1 RETURNING id`,
+		sessionID, reason,
+	).Scan(&id)
+	if err != nil {
+		logger.Sugar.Errorf("Error revoke session: %s", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+func (r *sessions) RevokeUser(ctx context.Context, userID int64, except, reason string) error {
+	_, err := r.q.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = $3
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`,
+		userID, except, reason,
+	)
+	if err != nil {
+		logger.Sugar.Errorf("Error revoke user sessions: %s", err)
+	}
+	return err
+}
+
+func (r *sessions) AddRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	_, err := r.q.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (token_hash, session_id, user_id, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		token.Hash, token.SessionID, token.UserID, token.CreatedAt, token.ExpiresAt,
+	)
+	if err != nil {
+		logger.Sugar.Errorf("Error insert refresh token: %s", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+func (r *sessions) GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	var usedAt sql.NullTime
+	err := r.q.QueryRowContext(ctx,
+		`SELECT token_hash, session_id, user_id, created_at, expires_at, used_at
+		FROM refresh_tokens WHERE token_hash = $1`,
+		hash,
+	).Scan(&token.Hash, &token.SessionID, &token.UserID, &token.CreatedAt, &token.ExpiresAt, &usedAt)
+	if err != nil {
+		return models.RefreshToken{}, mapError(err)
+	}
+	token.UsedAt = usedAt.Time
+	return token, nil
+}
+
+// UseRefreshToken relies on the conditional update, so two concurrent
+// refreshes with the same token can not both succeed.
+func (r *sessions) UseRefreshToken(ctx context.Context, hash string, at time.Time) (bool, error) {
+	var usedHash string
+	err := r.q.QueryRowContext(ctx,
+		"UPDATE refresh_tokens SET used_at = $2 WHERE token_hash = $1 AND used_at IS NULL RETURNING token_hash",
+		hash, at,
+	).Scan(&usedHash)
+	if err == nil {
+		return true, nil
+	}
+	if err != sql.ErrNoRows {
+		logger.Sugar.Errorf("Error use refresh token: %s", err)
+		return false, err
+	}
+	if _, err := r.GetRefreshToken(ctx, hash); err != nil {
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,129 @@
+// Package postgres implements repository.Store on top of database/sql with
+// the pgx driver.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+const uniqueViolation = "23505"
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type Store struct {
+	db *sql.DB
+	q  querier
+}
+
+func New(db *sql.DB) *Store {
+	return &Store{db: db, q: db}
+}
+
+func (s *Store) Users() repository.UserRepository {
+	return &users{q: s.q}
+}
+
+func (s *Store) Orders() repository.OrderRepository {
+	return &orders{q: s.q}
+}
+
+func (s *Store) Balances() repository.BalanceRepository {
+	return &balances{q: s.q}
+}
+
+func (s *Store) Withdrawals() repository.WithdrawalRepository {
+	return &withdrawals{q: s.q}
+}
+
+func (s *Store) Sessions() repository.SessionRepository {
+	return &sessions{q: s.q}
+}
+
+func (s *Store) LoginAttempts() repository.LoginAttemptRepository {
+	return &loginAttempts{q: s.q}
+}
+
+func (s *Store) Audit() repository.AuditRepository {
+	return &audit{q: s.q}
+}
+
+func (s *Store) PasswordResets() repository.PasswordResetRepository {
+	return &passwordResets{q: s.q}
+}
+
+func (s *Store) TwoFactor() repository.TwoFactorRepository {
+	return &twoFactor{q: s.q}
+}
+
+func (s *Store) Adjustments() repository.AdjustmentRepository {
+	return &adjustments{q: s.q}
+}
+
+func (s *Store) Idempotency() repository.IdempotencyRepository {
+	return &idempotency{q: s.q}
+}
+
+func (s *Store) Holds() repository.HoldRepository {
+	return &holds{q: s.q}
+}
+
+func (s *Store) Lots() repository.LotRepository {
+	return &lots{q: s.q}
+}
+
+func (s *Store) Tiers() repository.TierRepository {
+	return &tiers{q: s.q}
+}
+
+func (s *Store) Campaigns() repository.CampaignRepository {
+	return &campaigns{q: s.q}
+}
+
+// WithinTx runs fn in a transaction. Nested calls join the outer one.
+func (s *Store) WithinTx(ctx context.Context, fn func(tx repository.Store) error) error {
+	if _, ok := s.q.(*sql.Tx); ok {
+		return fn(s)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Sugar.Errorf("Failed to begin transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&Store{db: s.db, q: tx}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Sugar.Errorf("Failed to commit transaction: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// mapError translates driver errors into repository errors.
+func mapError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return repository.ErrConflict
+	}
+	return err
+}
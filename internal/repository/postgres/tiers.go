@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"context"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+const tierColumns = "id, user_id, tier, accrued, changed_at"
+
+type tiers struct {
+	q querier
+}
+
+func (r *tiers) Record(ctx context.Context, change models.TierChange) (int64, error) {
+	var changeID int64
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO user_tiers (user_id, tier, accrued, changed_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, change.UserID, change.Tier, change.Accrued, change.ChangedAt).Scan(&changeID)
+	if err != nil {
+		logger.Sugar.Errorf("Error insert user tier: %s", err)
+		return 0, mapError(err)
+	}
+	return changeID, nil
+}
+
+func (r *tiers) Current(ctx context.Context, userID int64) (models.TierChange, error) {
+	change, err := scanTierChange(r.q.QueryRowContext(ctx,
+		"SELECT "+tierColumns+" FROM user_tiers WHERE user_id = $1 ORDER BY id DESC LIMIT 1",
+		userID,
+	))
+	return change, mapError(err)
+}
+
+func (r *tiers) History(ctx context.Context, userID int64) ([]models.TierChange, error) {
+	rows, err := r.q.QueryContext(ctx, "SELECT "+tierColumns+" FROM user_tiers WHERE user_id = $1 ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []models.TierChange{}
+	for rows.Next() {
+		change, err := scanTierChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Sugar.Errorf("Failed to iterate over rows: %v", err)
+		return nil, err
+	}
+	return changes, nil
+}
+
+func scanTierChange(row interface{ Scan(dest ...any) error }) (models.TierChange, error) {
+	var change models.TierChange
+	err := row.Scan(&change.ID, &change.UserID, &change.Tier, &change.Accrued, &change.ChangedAt)
+	return change, err
+}
@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+)
+
+type twoFactor struct {
+	q querier
+}
+
+func (r *twoFactor) SetPending(ctx context.Context, userID int64, secret string) error {
+	var id int64
+	err := r.q.QueryRowContext(ctx,
+		"UPDATE users SET totp_secret = $2, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $1 RETURNING id",
+		userID, secret,
+	).Scan(&id)
+	if err != nil {
+		logger.Sugar.Errorf("Error set totp secret: %s", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+func (r *twoFactor) Enable(ctx context.Context, userID int64, recoveryHashes []string) error {
+	var id int64
+	err := r.q.QueryRowContext(ctx,
+		"UPDATE users SET totp_enabled = TRUE WHERE id = $1 AND totp_secret <> '' RETURNING id",
+		userID,
+	).Scan(&id)
+	if err != nil {
+		logger.Sugar.Errorf("Error enable totp: %s", err)
+		return mapError(err)
+	}
+	if _, err := r.q.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		_, err := r.q.ExecContext(ctx,
+			"INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, hash,
+		)
+		if err != nil {
+			logger.Sugar.Errorf("Error insert recovery code: %s", err)
+			return mapError(err)
+		}
+	}
+	return nil
+}
+
+func (r *twoFactor) Disable(ctx context.Context, userID int64) error {
+	var id int64
+	err := r.q.QueryRowContext(ctx,
+		"UPDATE users SET totp_secret = '', totp_enabled = FALSE, totp_last_step = 0 WHERE id = $1 RETURNING id",
+		userID,
+	).Scan(&id)
+	if err != nil {
+		logger.Sugar.Errorf("Error disable totp: %s", err)
+		return mapError(err)
+	}
+	_, err = r.q.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID)
+	return err
+}
+
+func (r *twoFactor) UseStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	var id int64
+	err := r.q.QueryRowContext(ctx,
+		"UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2 RETURNING id",
+		userID, step,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		logger.Sugar.Errorf("Error use totp step: %s", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *twoFactor) UseRecoveryCode(ctx context.Context, userID int64, hash string, at time.Time) (bool, error) {
+	var id int64
+	err := r.q.QueryRowContext(ctx,
+		"UPDATE recovery_codes SET used_at = $3 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL RETURNING user_id",
+		userID, hash, at,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		logger.Sugar.Errorf("Error use recovery code: %s", err)
+		return false, err
+	}
+	return true, nil
+}
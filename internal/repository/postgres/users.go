@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+type users struct {
+	q querier
+}
+
+const userColumns = "id, username, password, totp_secret, totp_enabled, totp_last_step, role, locked_at, lock_reason"
+
+func (r *users) Exists(ctx context.Context, login string) (bool, error) {
+	var userExists bool
+	if err := r.q.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", login).Scan(&userExists); err != nil {
+		logger.Sugar.Errorf("Error check user exists: %s", err)
+		return false, err
+	}
+	return userExists, nil
+}
+
+func (r *users) Create(ctx context.Context, login, passwordHash string) (int64, error) {
+	var userID int64
+	err := r.q.QueryRowContext(ctx,
+		"INSERT INTO users (username, password) VALUES ($1, $2) RETURNING id",
+		login, passwordHash,
+	).Scan(&userID)
+	if err != nil {
+		logger.Sugar.Errorf("Error insert user to db: %s", err)
+		return 0, mapError(err)
+	}
+	return userID, nil
+}
+
+func (r *users) GetByLogin(ctx context.Context, login string) (models.User, error) {
+	user, err := r.scan(r.q.QueryRowContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE username = $1",
+		login,
+	))
+	if err != nil {
+		logger.Sugar.Errorf("Error get user from db: %s", err)
+	}
+	return user, err
+}
+
+func (r *users) GetByID(ctx context.Context, userID int64) (models.User, error) {
+	return r.scan(r.q.QueryRowContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE id = $1",
+		userID,
+	))
+}
+
+func (r *users) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
+	var id int64
+	err := r.q.QueryRowContext(ctx,
+		"UPDATE users SET password = $2 WHERE id = $1 RETURNING id",
+		userID, passwordHash,
+	).Scan(&id)
+	if err != nil {
+		logger.Sugar.Errorf("Error update password: %s", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+func (r *users) scan(row *sql.Row) (models.User, error) {
+	var user models.User
+	var lockedAt sql.NullTime
+	err := row.Scan(
+		&user.ID, &user.Login, &user.PasswordHash,
+		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep,
+		&user.Role, &lockedAt, &user.LockReason,
+	)
+	if err != nil {
+		return models.User{}, mapError(err)
+	}
+	user.LockedAt = lockedAt.Time
+	return user, nil
+}
+
+func (r *users) SetRole(ctx context.Context, userID int64, role string) error {
+	return r.updateOne(ctx, "UPDATE users SET role = $2 WHERE id = $1 RETURNING id", userID, role)
+}
+
+func (r *users) Lock(ctx context.Context, userID int64, reason string, at time.Time) error {
+	return r.updateOne(ctx, "UPDATE users SET locked_at = $2, lock_reason = $3 WHERE id = $1 RETURNING id", userID, at, reason)
+}
+
+func (r *users) Unlock(ctx context.Context, userID int64) error {
+	return r.updateOne(ctx, "UPDATE users SET locked_at = NULL, lock_reason = '' WHERE id = $1 RETURNING id", userID)
+}
+
+// updateOne runs an UPDATE ... RETURNING id of one user.
+func (r *users) updateOne(ctx context.Context, query string, args ...any) error {
+	var id int64
+	if err := r.q.QueryRowContext(ctx, query, args...).Scan(&id); err != nil {
+		logger.Sugar.Errorf("Error update user: %s", err)
+		return mapError(err)
+	}
+	return nil
+}
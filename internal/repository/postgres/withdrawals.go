@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/repository"
+)
+
+// withdrawals are stored as orders rows with a positive withdrawal column.
+type withdrawals struct {
+	q querier
+}
+
+func (r *withdrawals) Create(
+	ctx context.Context,
+	userID int64,
+	orderNumber string,
+	sum models.Money,
+	accrualInfo models.AccrualInfo,
+) error {
+	_, err := r.q.ExecContext(ctx,
+		"INSERT INTO orders (user_id, order_id, withdrawal, status, accrual) VALUES ($1, $2, $3, $4, $5)",
+		userID,
+		orderNumber,
+		sum,
+		accrualInfo.OrderStatus(),
+		accrualInfo.Accrual,
+	)
+	if err != nil {
+		logger.Sugar.Errorf("Failed to insert order: %v", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+func (r *withdrawals) ListByUser(ctx context.Context, userID int64) ([]models.WithdrawResponse, error) {
+	rows, err := r.q.QueryContext(ctx,
+		"SELECT "+withdrawalColumns+" FROM orders WHERE user_id = $1 AND withdrawal > 0",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var withdrawls []models.WithdrawResponse
+	for rows.Next() {
+		withdrawl, err := scanWithdrawal(rows)
+		if err != nil {
+			return nil, err
+		}
+		withdrawls = append(withdrawls, withdrawl)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Sugar.Errorf("Failed to iterate over rows: %v", err)
+		return nil, err
+	}
+	return withdrawls, nil
+}
+
+func (r *withdrawals) Get(ctx context.Context, orderNumber string) (models.WithdrawResponse, error) {
+	withdrawal, err := scanWithdrawal(r.q.QueryRowContext(ctx,
+		"SELECT "+withdrawalColumns+" FROM orders WHERE order_id = $1 AND withdrawal > 0",
+		orderNumber,
+	))
+	if err != nil {
+		return models.WithdrawResponse{}, mapError(err)
+	}
+	return withdrawal, nil
+}
+
+func (r *withdrawals) Refund(ctx context.Context, orderNumber string, amount models.Money) error {
+	var userID int64
+	err := r.q.QueryRowContext(ctx, `
+		UPDATE orders SET reversed = reversed + $2
+		WHERE order_id = $1 AND withdrawal > 0 AND reversed + $2 <= withdrawal
+		RETURNING user_id
+	`, orderNumber, amount).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+const withdrawalColumns = "user_id, order_id, withdrawal, upload_time, reversed"
+
+func scanWithdrawal(row interface{ Scan(dest ...any) error }) (models.WithdrawResponse, error) {
+	var withdrawal models.WithdrawResponse
+	if err := row.Scan(&withdrawal.UserID, &withdrawal.OrderID, &withdrawal.Sum, &withdrawal.ProcessedAt, &withdrawal.Refunded); err != nil {
+		return models.WithdrawResponse{}, err
+	}
+	withdrawal.RefundStatus = models.ReversalStatus(withdrawal.Sum, withdrawal.Refunded)
+	return withdrawal, nil
+}
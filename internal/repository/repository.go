@@ -0,0 +1,261 @@
+// Package repository describes persistence used by the services. postgres
+// is the production implementation, memory keeps everything in process
+// for tests and demos.
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/ledger"
+	"github.com/thalq/gopher_mart/internal/models"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("already exists")
+)
+
+// Store gives access to repositories. Repositories of the Store passed to
+// WithinTx share one transaction: either every change made by fn is
+// applied or none of them.
+type Store interface {
+	Users() UserRepository
+	Orders() OrderRepository
+	Balances() BalanceRepository
+	Withdrawals() WithdrawalRepository
+	Sessions() SessionRepository
+	LoginAttempts() LoginAttemptRepository
+	Audit() AuditRepository
+	PasswordResets() PasswordResetRepository
+	TwoFactor() TwoFactorRepository
+	Adjustments() AdjustmentRepository
+	Idempotency() IdempotencyRepository
+	Holds() HoldRepository
+	Lots() LotRepository
+	Tiers() TierRepository
+	Campaigns() CampaignRepository
+
+	WithinTx(ctx context.Context, fn func(tx Store) error) error
+	// Ping checks that the storage is reachable.
+	Ping(ctx context.Context) error
+}
+
+type UserRepository interface {
+	Exists(ctx context.Context, login string) (bool, error)
+	Create(ctx context.Context, login, passwordHash string) (int64, error)
+	GetByLogin(ctx context.Context, login string) (models.User, error)
+	GetByID(ctx context.Context, userID int64) (models.User, error)
+	UpdatePassword(ctx context.Context, userID int64, passwordHash string) error
+	SetRole(ctx context.Context, userID int64, role string) error
+	Lock(ctx context.Context, userID int64, reason string, at time.Time) error
+	Unlock(ctx context.Context, userID int64) error
+}
+
+type OrderRepository interface {
+	Create(ctx context.Context, userID int64, orderNumber string) error
+	Exists(ctx context.Context, orderNumber string) (bool, error)
+	ExistsForUser(ctx context.Context, userID int64, orderNumber string) (bool, error)
+	ListByUser(ctx context.Context, userID int64) ([]models.Order, error)
+	// ClaimPending returns numbers of orders in NEW or PROCESSING status
+	// that are due to be polled, and moves their next poll forward by
+	// backoff doubled for every earlier poll, up to maxBackoff. Orders
+	// claimed by another transaction are skipped.
+	ClaimPending(ctx context.Context, limit int, backoff, maxBackoff time.Duration) ([]string, error)
+	// UpdateAccrual changes an order that is not in a final status yet and
+	// returns its owner. ErrNotFound is returned for final orders.
+	UpdateAccrual(ctx context.Context, orderNumber, status string, accrual models.Money) (int64, error)
+	// Requeue moves an order that has not been credited back to NEW, so
+	// the accrual worker asks about it again, and returns its owner.
+	// Processed orders are ErrConflict.
+	Requeue(ctx context.Context, orderNumber string) (int64, error)
+	// Get returns an uploaded order.
+	Get(ctx context.Context, orderNumber string) (models.Order, error)
+	// Reverse adds amount to the reversed part of a processed order.
+	// ErrConflict is returned when it would exceed the accrual.
+	Reverse(ctx context.Context, orderNumber string, amount models.Money) error
+}
+
+// BalanceRepository keeps the ledger and the user_balance projection.
+type BalanceRepository interface {
+	Create(ctx context.Context, userID int64) error
+	// Current returns the projected balance.
+	Current(ctx context.Context, userID int64) (models.Money, error)
+	// CurrentForUpdate returns the projected balance and locks it until the
+	// end of the transaction.
+	CurrentForUpdate(ctx context.Context, userID int64) (models.Money, error)
+
+	// Post appends the entry and updates the projection of user accounts.
+	Post(ctx context.Context, entry ledger.Entry) (int64, error)
+	Entry(ctx context.Context, entryID int64) (ledger.Entry, error)
+	Entries(ctx context.Context, userID int64) ([]ledger.Entry, error)
+	// Balance sums every posting of the user account.
+	Balance(ctx context.Context, userID int64) (models.Money, error)
+	BalanceAt(ctx context.Context, userID int64, at time.Time) (models.Money, error)
+	// Withdrawn sums withdrawals of the user net of refunds.
+	Withdrawn(ctx context.Context, userID int64) (models.Money, error)
+	// Accrued sums accruals of the user made since the time, net of their
+	// reversals.
+	Accrued(ctx context.Context, userID int64, since time.Time) (models.Money, error)
+}
+
+type WithdrawalRepository interface {
+	Create(ctx context.Context, userID int64, orderNumber string, sum models.Money, accrualInfo models.AccrualInfo) error
+	ListByUser(ctx context.Context, userID int64) ([]models.WithdrawResponse, error)
+	Get(ctx context.Context, orderNumber string) (models.WithdrawResponse, error)
+	// Refund adds amount to the refunded part of a withdrawal. ErrConflict
+	// is returned when it would exceed the sum.
+	Refund(ctx context.Context, orderNumber string, amount models.Money) error
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, session models.Session) error
+	Get(ctx context.Context, sessionID string) (models.Session, error)
+	// Revoke marks the session revoked. The first reason is kept.
+	Revoke(ctx context.Context, sessionID, reason string) error
+	// RevokeUser revokes every active session of the user except the
+	// given one.
+	RevokeUser(ctx context.Context, userID int64, except, reason string) error
+
+	AddRefreshToken(ctx context.Context, token models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error)
+	// UseRefreshToken marks the token used and reports false if it had
+	// already been used.
+	UseRefreshToken(ctx context.Context, hash string, at time.Time) (bool, error)
+}
+
+type LoginAttemptRepository interface {
+	Get(ctx context.Context, key string) (models.LoginAttempts, error)
+	// Attempt checks the key and counts an attempt as a failure in one
+	// step, so parallel attempts can not all pass the check before any of
+	// them is counted. A key blocked at the time is not counted and false
+	// is returned with its current state.
+	Attempt(ctx context.Context, key string, at time.Time, limits models.AttemptLimits) (models.LoginAttempts, bool, error)
+	// Forgive takes back one attempt that turned out to be good.
+	Forgive(ctx context.Context, key string) error
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+}
+
+type AuditRepository interface {
+	Record(ctx context.Context, event models.AuditEvent) error
+	// List returns matching events, newest first.
+	List(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+}
+
+type PasswordResetRepository interface {
+	Create(ctx context.Context, token models.PasswordResetToken) error
+	// Use marks an unused token that has not expired at the given time as
+	// used and returns it. Any other token is ErrNotFound.
+	Use(ctx context.Context, hash string, at time.Time) (models.PasswordResetToken, error)
+}
+
+// TwoFactorRepository changes TOTP columns of users and recovery codes.
+// The TOTP state is read with the user.
+type TwoFactorRepository interface {
+	// SetPending stores a secret that is not enabled until confirmed.
+	SetPending(ctx context.Context, userID int64, secret string) error
+	// Enable turns TOTP on and replaces recovery codes with the hashes.
+	Enable(ctx context.Context, userID int64, recoveryHashes []string) error
+	Disable(ctx context.Context, userID int64) error
+	// UseStep records the time step of an accepted code and reports false
+	// if that or a later step was already used, so codes can not be
+	// replayed.
+	UseStep(ctx context.Context, userID int64, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int64, hash string, at time.Time) (bool, error)
+}
+
+// AdjustmentRepository tracks approval of manual balance adjustments. The
+// balance itself is changed by posting the ledger entry.
+type AdjustmentRepository interface {
+	Create(ctx context.Context, adjustment models.Adjustment) (int64, error)
+	Get(ctx context.Context, adjustmentID int64) (models.Adjustment, error)
+	// List returns adjustments in the status, or all when it is empty,
+	// newest first.
+	List(ctx context.Context, status string) ([]models.Adjustment, error)
+	// Decide moves a pending adjustment to the status. ErrConflict is
+	// returned when it has already been decided.
+	Decide(ctx context.Context, adjustmentID int64, status string, decidedBy int64, at time.Time, entryID int64) error
+}
+
+type IdempotencyRepository interface {
+	// Begin claims the key of the record. A key taken before expiredBefore,
+	// or left unfinished past its LockedUntil, is claimed again. Otherwise
+	// the stored record is returned with false.
+	Begin(ctx context.Context, record models.IdempotencyRecord, expiredBefore time.Time) (models.IdempotencyRecord, bool, error)
+	// Complete stores the response of a key that is still held by claim.
+	Complete(ctx context.Context, userID int64, key, claim string, statusCode int, contentType string, body []byte) error
+	// Delete releases a key held by claim, so the request can be retried.
+	Delete(ctx context.Context, userID int64, key, claim string) error
+}
+
+type HoldRepository interface {
+	Create(ctx context.Context, hold models.Hold) (int64, error)
+	Get(ctx context.Context, holdID int64) (models.Hold, error)
+	// ListByUser returns holds of the user, newest first.
+	ListByUser(ctx context.Context, userID int64) ([]models.Hold, error)
+	// Held sums holds of the user that are active at the time.
+	Held(ctx context.Context, userID int64, at time.Time) (models.Money, error)
+	// Close moves a hold that is active at the time to the status.
+	// ErrConflict is returned for any other hold.
+	Close(ctx context.Context, holdID int64, status string, at time.Time) error
+	// Expire marks holds that expired by the time EXPIRED and returns how
+	// many there were.
+	Expire(ctx context.Context, at time.Time) (int64, error)
+}
+
+// LotRepository tracks where the points of a balance came from. The
+// balance itself is changed by posting ledger entries.
+type LotRepository interface {
+	Create(ctx context.Context, lot models.Lot) (int64, error)
+	// Consume takes amount from lots of the user that have points left,
+	// oldest first. The lot of orderNumber, when given, is taken from
+	// before the others. Whatever the lots do not cover is not tracked.
+	Consume(ctx context.Context, userID int64, orderNumber string, amount models.Money) error
+	// ListActive returns lots of the user that have points left, oldest
+	// first.
+	ListActive(ctx context.Context, userID int64) ([]models.Lot, error)
+	// ListExpired returns up to limit lots earned before earnedBefore that
+	// have points left, oldest first. Lots of users with a hold active at
+	// the time are left out: their points wait until the holds settle.
+	ListExpired(ctx context.Context, earnedBefore, at time.Time, limit int) ([]models.Lot, error)
+	// GetForUpdate returns the lot and locks it until the transaction ends.
+	GetForUpdate(ctx context.Context, lotID int64) (models.Lot, error)
+	// Expire takes everything but keep out of the lot; the lot is closed
+	// when nothing is kept. ErrConflict is returned when the lot does not
+	// have more than keep left.
+	Expire(ctx context.Context, lotID int64, keep models.Money, at time.Time) error
+}
+
+type TierRepository interface {
+	Record(ctx context.Context, change models.TierChange) (int64, error)
+	// Current returns the latest change of the user. ErrNotFound is
+	// returned when the user has never had a tier.
+	Current(ctx context.Context, userID int64) (models.TierChange, error)
+	// History returns changes of the user, newest first.
+	History(ctx context.Context, userID int64) ([]models.TierChange, error)
+}
+
+type CampaignRepository interface {
+	Create(ctx context.Context, campaign models.Campaign) (int64, error)
+	Get(ctx context.Context, campaignID int64) (models.Campaign, error)
+	// GetForUpdate returns the campaign and locks it until the end of the
+	// transaction, so concurrent awards see each other's spending.
+	GetForUpdate(ctx context.Context, campaignID int64) (models.Campaign, error)
+	// List returns every campaign, newest first.
+	List(ctx context.Context) ([]models.Campaign, error)
+	// ListRunning returns active campaigns whose window contains the time.
+	ListRunning(ctx context.Context, at time.Time) ([]models.Campaign, error)
+	// Update changes the rules of the campaign. Spent is kept.
+	Update(ctx context.Context, campaign models.Campaign) error
+	// Award records the award and adds it to the spending of the campaign.
+	// ErrConflict is returned when the order has already been awarded.
+	Award(ctx context.Context, award models.CampaignAward) (int64, error)
+	// Awarded sums awards of the campaign to the user.
+	Awarded(ctx context.Context, campaignID, userID int64) (models.Money, error)
+	// Awards returns awards of the campaign, newest first.
+	Awards(ctx context.Context, campaignID int64) ([]models.CampaignAward, error)
+	// OrderAwards returns awards for the order, oldest first.
+	OrderAwards(ctx context.Context, orderNumber string) ([]models.CampaignAward, error)
+}
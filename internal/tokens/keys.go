@@ -0,0 +1,232 @@
+// Package tokens signs and verifies JWTs. A KeySet has one signing key and
+// any number of verification keys identified by the kid header, so keys
+// can be rotated without invalidating tokens issued with the previous one.
+package tokens
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Key is a single signing or verification key.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+func (k *Key) canSign() bool {
+	return k.signKey != nil
+}
+
+// NewHMACKey creates an HS256 key. HMAC keys are never published in JWKS.
+func NewHMACKey(id, secret string) *Key {
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+}
+
+// NewPrivateKey wraps an RSA, ECDSA or Ed25519 private key. The algorithm
+// is derived from the key: RS256, ES256/ES384/ES512 by curve or EdDSA. An
+// empty id is replaced by a fingerprint of the public key.
+func NewPrivateKey(id string, private crypto.Signer) (*Key, error) {
+	key, err := NewPublicKey(id, private.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.signKey = private
+	return key, nil
+}
+
+// NewPublicKey wraps a public key that is only used for verification.
+func NewPublicKey(id string, public crypto.PublicKey) (*Key, error) {
+	method, err := methodFor(public)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		if id, err = fingerprint(public); err != nil {
+			return nil, err
+		}
+	}
+	return &Key{ID: id, Method: method, verifyKey: public}, nil
+}
+
+func methodFor(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported elliptic curve %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", public)
+}
+
+func fingerprint(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// LoadKeyFile reads a PEM file with either a private key (PKCS#8, PKCS#1
+// or SEC 1) or a public key (PKIX or PKCS#1).
+func LoadKeyFile(id, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", path)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+		private, err := parsePrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return NewPrivateKey(id, private)
+	case "PUBLIC KEY", "RSA PUBLIC KEY":
+		public, err := parsePublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return NewPublicKey(id, public)
+	}
+	return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("can not parse private key")
+}
+
+func parsePublicKey(der []byte) (crypto.PublicKey, error) {
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("can not parse public key")
+}
+
+// KeyConfig is how keys are configured from env and flags.
+type KeyConfig struct {
+	// Secret is the HS256 secret used when SigningKeyFile is empty.
+	Secret string
+	// SigningKeyFile is a PEM private key used to sign new tokens.
+	SigningKeyFile string
+	// KeyID is the kid of the signing key.
+	KeyID string
+	// VerificationKeys is a comma separated list of kid=path PEM files
+	// that are still accepted, e.g. keys rotated out.
+	VerificationKeys string
+	// PreviousSecrets is a comma separated list of kid=secret HS256 keys
+	// that are still accepted.
+	PreviousSecrets string
+}
+
+const defaultHMACKeyID = "default"
+
+func LoadKeySet(cfg KeyConfig) (*KeySet, error) {
+	var signing *Key
+	if cfg.SigningKeyFile != "" {
+		key, err := LoadKeyFile(cfg.KeyID, cfg.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if !key.canSign() {
+			return nil, fmt.Errorf("%s: signing key must be a private key", cfg.SigningKeyFile)
+		}
+		signing = key
+	} else {
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("neither JWT_SECRET nor JWT_SIGNING_KEY_FILE is configured")
+		}
+		id := cfg.KeyID
+		if id == "" {
+			id = defaultHMACKeyID
+		}
+		signing = NewHMACKey(id, cfg.Secret)
+	}
+
+	var verification []*Key
+	pairs, err := parsePairs(cfg.VerificationKeys)
+	if err != nil {
+		return nil, fmt.Errorf("verification keys: %w", err)
+	}
+	for _, pair := range pairs {
+		key, err := LoadKeyFile(pair[0], pair[1])
+		if err != nil {
+			return nil, err
+		}
+		verification = append(verification, key)
+	}
+	pairs, err = parsePairs(cfg.PreviousSecrets)
+	if err != nil {
+		return nil, fmt.Errorf("previous secrets: %w", err)
+	}
+	for _, pair := range pairs {
+		verification = append(verification, NewHMACKey(pair[0], pair[1]))
+	}
+	return NewKeySet(signing, verification...)
+}
+
+func parsePairs(value string) ([][2]string, error) {
+	var pairs [][2]string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, rest, ok := strings.Cut(item, "=")
+		if !ok || id == "" || rest == "" {
+			return nil, fmt.Errorf("expected kid=value, got %q", item)
+		}
+		pairs = append(pairs, [2]string{id, rest})
+	}
+	return pairs, nil
+}
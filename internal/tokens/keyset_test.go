@@ -0,0 +1,281 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func sign(t *testing.T, ks *KeySet, subject string) string {
+	t.Helper()
+	token, err := ks.Sign(jwt.StandardClaims{Subject: subject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func parse(ks *KeySet, token string) (string, error) {
+	var claims jwt.StandardClaims
+	if _, err := ks.Parse(token, &claims); err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+func newKeySet(t *testing.T, signing *Key, verification ...*Key) *KeySet {
+	t.Helper()
+	ks, err := NewKeySet(signing, verification...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func newRSAKey(t *testing.T, id string) *Key {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewPrivateKey(id, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKeySetRotation(t *testing.T) {
+	old := NewHMACKey("2023", "old secret")
+	current := newRSAKey(t, "2024")
+	before := newKeySet(t, old)
+	after := newKeySet(t, current, old)
+
+	issued := sign(t, before, "1")
+	if subject, err := parse(after, issued); err != nil || subject != "1" {
+		t.Fatalf("token signed with the rotated key: got %q, %v", subject, err)
+	}
+
+	fresh := sign(t, after, "2")
+	token, _, err := new(jwt.Parser).ParseUnverified(fresh, &jwt.StandardClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != "2024" || token.Method.Alg() != "RS256" {
+		t.Fatalf("new tokens are signed with kid %v and %s, want 2024 and RS256", token.Header["kid"], token.Method.Alg())
+	}
+	if _, err := parse(before, fresh); err == nil {
+		t.Fatal("a key set without the new key accepted its token")
+	}
+
+	// Once the old key is dropped its tokens are refused.
+	if _, err := parse(newKeySet(t, current), issued); err == nil {
+		t.Fatal("token signed with a dropped key was accepted")
+	}
+}
+
+func TestKeySetWithoutKid(t *testing.T) {
+	ks := newKeySet(t, NewHMACKey("current", "secret"), NewHMACKey("old", "old secret"))
+
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "1"}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, err := parse(ks, legacy); err != nil || subject != "1" {
+		t.Fatalf("token without kid: got %q, %v", subject, err)
+	}
+
+	// Without kid only the signing key is tried.
+	legacy, err = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "1"}).SignedString([]byte("old secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(ks, legacy); err == nil {
+		t.Fatal("token without kid was checked with a verification key")
+	}
+}
+
+func TestKeySetRejectsAlgorithmConfusion(t *testing.T) {
+	key := newRSAKey(t, "rsa")
+	ks := newKeySet(t, key)
+
+	// An attacker who knows the public key signs an HS256 token with it.
+	der, err := x509.MarshalPKIXPublicKey(key.verifyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "1"})
+	forged.Header["kid"] = "rsa"
+	token, err := forged.SignedString(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(ks, token); err == nil {
+		t.Fatal("HS256 token signed with the RSA public key was accepted")
+	}
+}
+
+func TestNewKeySetDuplicateKid(t *testing.T) {
+	if _, err := NewKeySet(NewHMACKey("a", "one"), NewHMACKey("a", "two")); err == nil {
+		t.Fatal("duplicate kid was accepted")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey := newRSAKey(t, "rsa")
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := NewPublicKey("ec", &ecPrivate.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, err := NewPublicKey("", edPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := newKeySet(t, rsaKey, NewHMACKey("hmac", "secret"), ecKey, edKey)
+
+	recorder := httptest.NewRecorder()
+	ks.ServeJWKS(recorder, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if ct := recorder.Header().Get("content-type"); ct != "application/json" {
+		t.Fatalf("got content-type %q", ct)
+	}
+	var set JWKS
+	if err := json.Unmarshal(recorder.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 3 {
+		t.Fatalf("got %d keys, want 3 without the HMAC secret: %+v", len(set.Keys), set.Keys)
+	}
+
+	signing := set.Keys[0]
+	if signing.KeyID != "rsa" || signing.KeyType != "RSA" || signing.Algorithm != "RS256" || signing.Use != "sig" {
+		t.Fatalf("signing key is not listed first: %+v", signing)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(signing.N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := rsaKey.verifyKey.(*rsa.PublicKey)
+	if new(big.Int).SetBytes(n).Cmp(public.N) != 0 || signing.E != "AQAB" {
+		t.Fatalf("RSA modulus or exponent do not match: %+v", signing)
+	}
+
+	ec := set.Keys[1]
+	if ec.KeyType != "EC" || ec.Curve != "P-256" || ec.Algorithm != "ES256" || len(ec.X) != 43 || len(ec.Y) != 43 {
+		t.Fatalf("unexpected EC key %+v", ec)
+	}
+	ed := set.Keys[2]
+	if ed.KeyType != "OKP" || ed.Curve != "Ed25519" || ed.Algorithm != "EdDSA" || ed.KeyID != edKey.ID {
+		t.Fatalf("unexpected Ed25519 key %+v", ed)
+	}
+	if len(edKey.ID) != 16 {
+		t.Fatalf("got kid %q, want a 16 digit fingerprint", edKey.ID)
+	}
+}
+
+func writeKey(t *testing.T, block *pem.Block) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), strings.ToLower(strings.ReplaceAll(block.Type, " ", "_"))+".pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadKeySet(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingFile := writeKey(t, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedFile := writeKey(t, &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rotated.PublicKey)})
+
+	ks, err := LoadKeySet(KeyConfig{
+		SigningKeyFile:   signingFile,
+		KeyID:            "2024",
+		VerificationKeys: "2023=" + rotatedFile,
+		PreviousSecrets:  " 2022=old secret, ",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks.signing.ID != "2024" || ks.signing.Method.Alg() != "ES384" {
+		t.Fatalf("got signing key %s/%s, want 2024/ES384", ks.signing.ID, ks.signing.Method.Alg())
+	}
+	if subject, err := parse(ks, sign(t, ks, "1")); err != nil || subject != "1" {
+		t.Fatalf("got %q, %v", subject, err)
+	}
+	previous := newKeySet(t, NewHMACKey("2022", "old secret"))
+	if subject, err := parse(ks, sign(t, previous, "2")); err != nil || subject != "2" {
+		t.Fatalf("token signed with a previous secret: got %q, %v", subject, err)
+	}
+	rotatedKey, err := NewPrivateKey("2023", rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, err := parse(ks, sign(t, newKeySet(t, rotatedKey), "3")); err != nil || subject != "3" {
+		t.Fatalf("token signed with a rotated key: got %q, %v", subject, err)
+	}
+
+	ks, err = LoadKeySet(KeyConfig{Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks.signing.ID != defaultHMACKeyID {
+		t.Fatalf("got kid %q for JWT_SECRET, want %q", ks.signing.ID, defaultHMACKeyID)
+	}
+
+	for name, cfg := range map[string]KeyConfig{
+		"no key":               {},
+		"public signing key":   {SigningKeyFile: rotatedFile},
+		"missing file":         {SigningKeyFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"verification no kid":  {Secret: "secret", VerificationKeys: rotatedFile},
+		"secret no kid":        {Secret: "secret", PreviousSecrets: "old secret"},
+		"duplicate kid":        {Secret: "secret", PreviousSecrets: "default=old secret"},
+		"not a PEM file":       {Secret: "secret", VerificationKeys: "2023=" + writeFile(t, "key")},
+		"unsupported PEM type": {Secret: "secret", VerificationKeys: "2023=" + writeKey(t, &pem.Block{Type: "CERTIFICATE", Bytes: der})},
+	} {
+		if _, err := LoadKeySet(cfg); err == nil {
+			t.Errorf("%s: LoadKeySet succeeded", name)
+		}
+	}
+}
@@ -5,33 +5,125 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/thalq/gopher_mart/internal/admin"
 	"github.com/thalq/gopher_mart/internal/auth"
-	"github.com/thalq/gopher_mart/internal/constants"
+	"github.com/thalq/gopher_mart/internal/health"
+	"github.com/thalq/gopher_mart/internal/metrics"
 	myMiddleware "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/notify"
 	"github.com/thalq/gopher_mart/internal/orders"
+	"github.com/thalq/gopher_mart/internal/repository"
+	"github.com/thalq/gopher_mart/internal/tokens"
 	"github.com/thalq/gopher_mart/pkg/config"
-	"github.com/thalq/gopher_mart/pkg/storage"
 )
 
-func NewRouter(cfg *config.Config) http.Handler {
+func NewRouter(
+	cfg *config.Config,
+	store repository.Store,
+	orderService *orders.OrderService,
+	checker *health.Checker,
+	keys *tokens.KeySet,
+) (http.Handler, error) {
+	passwordPolicy, err := auth.LoadPasswordPolicy(cfg.PasswordMinLength, cfg.PasswordBreachedFile)
+	if err != nil {
+		return nil, err
+	}
+	notifier, err := notify.New(cfg.Notifier, cfg.NotifierFile)
+	if err != nil {
+		return nil, err
+	}
+
 	r := chi.NewRouter()
 	r.Use(middleware.Recoverer)
+	if cfg.TrustProxyHeaders {
+		r.Use(middleware.RealIP)
+	}
+	r.Use(myMiddleware.Tracing)
 	r.Use(myMiddleware.Logging)
-	r.Use(myMiddleware.AuthMiddleware(constants.JWTSecret))
+	r.Use(myMiddleware.Metrics)
+
+	authService := auth.NewAuthService(store, keys, auth.Options{
+		AccessTokenTTL:  cfg.AccessTokenTTL,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+
+		MaxLoginFailures:   cfg.LoginMaxFailures,
+		MaxIPLoginFailures: cfg.LoginIPMaxFailures,
+		LoginBackoff:       cfg.LoginBackoff,
+		LoginLockout:       cfg.LoginLockout,
+
+		PasswordPolicy:   passwordPolicy,
+		PasswordResetTTL: cfg.PasswordResetTTL,
+		Notifier:         notifier,
+	})
+	r.Use(myMiddleware.AuthMiddleware(keys, authService))
 
-	db := storage.GetDB()
-	authService := auth.NewAuthService(db, constants.JWTSecret)
 	authHandler := auth.NewAuthHandler(authService)
-	orderService := orders.NewOrderService(db)
-	orderHandler := orders.NewOrderHandler(orderService, cfg.AccrualSystemAddress)
+	orderHandler := orders.NewOrderHandler(orderService)
+	adminHandler := admin.NewAdminHandler(admin.NewAdminService(store, orderService, admin.Options{
+		AllowDebt: cfg.AllowDebt,
+	}))
+	idempotent := myMiddleware.Idempotency(store, cfg.IdempotencyKeyTTL, cfg.IdempotencyLease)
+	r.Get("/healthz", checker.Liveness)
+	r.Get("/readyz", checker.Readiness)
+	r.Method(http.MethodGet, "/metrics", metrics.Handler())
+	r.Get("/.well-known/jwks.json", keys.ServeJWKS)
 	r.Route("/api/user", func(r chi.Router) {
 		r.Post("/register", authHandler.Register)
 		r.Post("/login", authHandler.Login)
-		r.Post("/orders", orderHandler.UploadOrder)
-		r.Get("/orders", orderHandler.GetOrders)
-		r.Get("/balance", orderHandler.GetBalance)
-		r.Post("/balance/withdraw", orderHandler.WithdrawRequest)
-		r.Get("/withdrawals", orderHandler.UserWithdrawls)
+		r.Post("/token/refresh", authHandler.Refresh)
+		r.Post("/password/reset/request", authHandler.RequestPasswordReset)
+		r.Post("/password/reset", authHandler.ResetPassword)
+		r.Post("/2fa/verify", authHandler.VerifyTwoFactor)
+		r.Group(func(r chi.Router) {
+			r.Use(myMiddleware.RequireAuth)
+			r.Post("/logout", authHandler.Logout)
+			r.Post("/password", authHandler.ChangePassword)
+			r.Post("/2fa/enroll", authHandler.EnrollTwoFactor)
+			r.Post("/2fa/disable", authHandler.DisableTwoFactor)
+			r.With(idempotent).Post("/orders", orderHandler.UploadOrder)
+			r.Get("/orders", orderHandler.GetOrders)
+			r.Get("/balance", orderHandler.GetBalance)
+			r.With(idempotent).Post("/balance/withdraw", orderHandler.WithdrawRequest)
+			r.Get("/balance/holds", orderHandler.GetHolds)
+			r.With(idempotent).Post("/balance/holds", orderHandler.AuthorizeHold)
+			r.With(idempotent).Post("/balance/holds/{id}/capture", orderHandler.CaptureHold)
+			r.Post("/balance/holds/{id}/void", orderHandler.VoidHold)
+			r.Get("/withdrawals", orderHandler.UserWithdrawls)
+			r.Get("/history", orderHandler.GetHistory)
+			r.Get("/tier", orderHandler.GetTier)
+			r.Get("/tier/history", orderHandler.GetTierHistory)
+		})
+	})
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(myMiddleware.RequireAuth)
+		r.Use(myMiddleware.RequireRole(models.RoleSupport, models.RoleAdmin))
+		r.Get("/users", adminHandler.FindUser)
+		r.Get("/users/{id}", adminHandler.GetUser)
+		r.Get("/users/{id}/orders", adminHandler.UserOrders)
+		r.Get("/users/{id}/withdrawals", adminHandler.UserWithdrawals)
+		r.Get("/users/{id}/balance", adminHandler.UserBalance)
+		r.Get("/users/{id}/history", adminHandler.UserHistory)
+		r.Post("/users/{id}/lock", adminHandler.LockUser)
+		r.Post("/users/{id}/unlock", adminHandler.UnlockUser)
+		r.Post("/orders/{number}/recheck", adminHandler.RecheckOrder)
+		r.Group(func(r chi.Router) {
+			r.Use(myMiddleware.RequireRole(models.RoleAdmin))
+			r.Put("/users/{id}/role", adminHandler.SetRole)
+			r.Get("/audit", adminHandler.AuditTrail)
+			r.Get("/adjustments", adminHandler.ListAdjustments)
+			r.Post("/adjustments", adminHandler.ProposeAdjustment)
+			r.Get("/adjustments/{id}", adminHandler.GetAdjustment)
+			r.Post("/adjustments/{id}/approve", adminHandler.ApproveAdjustment)
+			r.Post("/adjustments/{id}/reject", adminHandler.RejectAdjustment)
+			r.Post("/orders/{number}/reverse", adminHandler.ReverseOrder)
+			r.Post("/withdrawals/{number}/refund", adminHandler.RefundWithdrawal)
+			r.Get("/campaigns", adminHandler.ListCampaigns)
+			r.Post("/campaigns", adminHandler.CreateCampaign)
+			r.Get("/campaigns/{id}", adminHandler.GetCampaign)
+			r.Put("/campaigns/{id}", adminHandler.UpdateCampaign)
+			r.Get("/campaigns/{id}/awards", adminHandler.CampaignAwards)
+		})
 	})
-	return r
+	return r, nil
 }
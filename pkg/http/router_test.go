@@ -0,0 +1,356 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thalq/gopher_mart/internal/accrual"
+	"github.com/thalq/gopher_mart/internal/accrual/fake"
+	"github.com/thalq/gopher_mart/internal/health"
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/models"
+	"github.com/thalq/gopher_mart/internal/orders"
+	"github.com/thalq/gopher_mart/internal/repository/memory"
+	"github.com/thalq/gopher_mart/internal/tokens"
+	"github.com/thalq/gopher_mart/pkg/config"
+	router "github.com/thalq/gopher_mart/pkg/http"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger()
+	os.Exit(m.Run())
+}
+
+// testServer runs the whole API on memory storage against the fake
+// accrual system, with the accrual worker polling every few milliseconds.
+func testServer(t *testing.T, accrualSystem *fake.Server) *httptest.Server {
+	t.Helper()
+	cfg := &config.Config{
+		AccessTokenTTL:     time.Minute,
+		RefreshTokenTTL:    time.Hour,
+		LoginMaxFailures:   5,
+		LoginIPMaxFailures: 20,
+		LoginBackoff:       time.Minute,
+		LoginLockout:       time.Hour,
+		PasswordMinLength:  8,
+		PasswordResetTTL:   time.Minute,
+		Notifier:           "log",
+		IdempotencyKeyTTL:  time.Hour,
+		IdempotencyLease:   time.Minute,
+		HoldTTL:            time.Minute,
+	}
+	keys, err := tokens.LoadKeySet(tokens.KeyConfig{Secret: "test-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := memory.New()
+	orderService := orders.NewOrderService(store, accrual.NewClient(accrualSystem.URL()), orders.Options{HoldTTL: cfg.HoldTTL})
+	worker := orders.NewAccrualWorker(orderService, 2, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		worker.Run(ctx)
+	}()
+
+	handler, err := router.NewRouter(cfg, store, orderService, health.NewChecker(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		server.Close()
+		cancel()
+		<-done
+	})
+	return server
+}
+
+type client struct {
+	t      *testing.T
+	server *httptest.Server
+	token  string
+}
+
+func (c *client) do(method, path, contentType, body string, header map[string]string) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", c.token)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := c.server.Client().Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func (c *client) expect(resp *http.Response, statusCode int) {
+	c.t.Helper()
+	if resp.StatusCode != statusCode {
+		c.t.Fatalf("%s %s: got status %d, want %d", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, statusCode)
+	}
+}
+
+func (c *client) decode(resp *http.Response, v any) {
+	c.t.Helper()
+	c.expect(resp, http.StatusOK)
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func register(t *testing.T, server *httptest.Server, login string) *client {
+	t.Helper()
+	c := &client{t: t, server: server}
+	resp := c.do(http.MethodPost, "/api/user/register", "application/json",
+		`{"login":"`+login+`","password":"correct-horse-battery"}`, nil)
+	c.expect(resp, http.StatusOK)
+	c.token = resp.Header.Get("Authorization")
+	if c.token == "" {
+		t.Fatal("register: no Authorization header")
+	}
+	return c
+}
+
+// waitProcessed waits until the only order of c is PROCESSED.
+func waitProcessed(c *client, number string) models.Order {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var list []models.Order
+		c.decode(c.do(http.MethodGet, "/api/user/orders", "", "", nil), &list)
+		if len(list) != 1 || list[0].Number != number {
+			c.t.Fatalf("got orders %+v, want only %s", list, number)
+		}
+		if list[0].Status == models.OrderStatusProcessed {
+			return list[0]
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("order is still %s", list[0].Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOrderFlow(t *testing.T) {
+	accrualSystem := fake.NewServer()
+	defer accrualSystem.Close()
+	accrualSystem.Script("12345678903",
+		fake.Response{Status: "REGISTERED"},
+		fake.Response{Status: models.OrderStatusProcessing},
+		fake.Response{Status: models.OrderStatusProcessed, Accrual: 72998},
+	)
+	server := testServer(t, accrualSystem)
+
+	alice := register(t, server, "alice")
+	bob := register(t, server, "bob")
+
+	alice.expect(alice.do(http.MethodPost, "/api/user/orders", "text/plain", "12345678903", nil), http.StatusAccepted)
+	alice.expect(alice.do(http.MethodPost, "/api/user/orders", "text/plain", "12345678903", nil), http.StatusOK)
+	bob.expect(bob.do(http.MethodPost, "/api/user/orders", "text/plain", "12345678903", nil), http.StatusConflict)
+	alice.expect(alice.do(http.MethodPost, "/api/user/orders", "text/plain", "12345678904", nil), http.StatusUnprocessableEntity)
+
+	order := waitProcessed(alice, "12345678903")
+	if order.Accrual != 72998 {
+		t.Fatalf("got accrual %s, want 729.98", order.Accrual)
+	}
+
+	var balance models.Balance
+	alice.decode(alice.do(http.MethodGet, "/api/user/balance", "", "", nil), &balance)
+	if balance.Current != 72998 || balance.Withdrawn != 0 {
+		t.Fatalf("got balance %+v, want 729.98 current", balance)
+	}
+
+	alice.expect(alice.do(http.MethodPost, "/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":751}`, nil), http.StatusPaymentRequired)
+	withdraw := map[string]string{"Idempotency-Key": "withdraw-1"}
+	alice.expect(alice.do(http.MethodPost, "/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":700.5}`, withdraw), http.StatusOK)
+	replayed := alice.do(http.MethodPost, "/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":700.5}`, withdraw)
+	alice.expect(replayed, http.StatusOK)
+	if replayed.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatal("repeated withdrawal was not replayed")
+	}
+	alice.expect(alice.do(http.MethodPost, "/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":1}`, nil), http.StatusConflict)
+
+	alice.decode(alice.do(http.MethodGet, "/api/user/balance", "", "", nil), &balance)
+	if balance.Current != 2948 || balance.Withdrawn != 70050 {
+		t.Fatalf("got balance %+v, want 29.48 current and 700.5 withdrawn", balance)
+	}
+	var withdrawals []models.WithdrawResponse
+	alice.decode(alice.do(http.MethodGet, "/api/user/withdrawals", "", "", nil), &withdrawals)
+	if len(withdrawals) != 1 || withdrawals[0].OrderID != "2377225624" || withdrawals[0].Sum != 70050 {
+		t.Fatalf("got withdrawals %+v", withdrawals)
+	}
+
+	bob.decode(bob.do(http.MethodGet, "/api/user/balance", "", "", nil), &balance)
+	if balance.Current != 0 {
+		t.Fatalf("bob got balance %+v, want zero", balance)
+	}
+}
+
+func TestWithdrawWhileAccrualThrottled(t *testing.T) {
+	accrualSystem := fake.NewServer()
+	defer accrualSystem.Close()
+	accrualSystem.Script("12345678903", fake.Response{Status: models.OrderStatusProcessed, Accrual: 10000})
+	server := testServer(t, accrualSystem)
+
+	alice := register(t, server, "alice")
+	alice.expect(alice.do(http.MethodPost, "/api/user/orders", "text/plain", "12345678903", nil), http.StatusAccepted)
+	waitProcessed(alice, "12345678903")
+	resp := alice.do(http.MethodPost, "/api/user/balance/holds", "application/json",
+		`{"order":"79927398713","sum":10}`, nil)
+	alice.expect(resp, http.StatusCreated)
+	var hold models.Hold
+	if err := json.NewDecoder(resp.Body).Decode(&hold); err != nil {
+		t.Fatal(err)
+	}
+	capture := "/api/user/balance/holds/" + strconv.FormatInt(hold.ID, 10) + "/capture"
+
+	// The withdrawal number is unknown to the accrual system, but it has to
+	// be asked before the withdrawal is written.
+	accrualSystem.Script("2377225624",
+		fake.Response{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Second},
+		fake.Response{StatusCode: http.StatusNoContent},
+	)
+	withdraw := map[string]string{"Idempotency-Key": "withdraw-1"}
+	refused := alice.do(http.MethodPost, "/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":40}`, withdraw)
+	alice.expect(refused, http.StatusServiceUnavailable)
+	if refused.Header.Get("Retry-After") == "" {
+		t.Fatal("refused withdrawal has no Retry-After header")
+	}
+	// Calls stay paused, the accrual system is not asked again.
+	alice.expect(alice.do(http.MethodPost, "/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":40}`, withdraw), http.StatusServiceUnavailable)
+	alice.expect(alice.do(http.MethodPost, capture, "", "", nil), http.StatusServiceUnavailable)
+	if calls := accrualSystem.Calls("2377225624"); calls != 1 {
+		t.Fatalf("accrual system was asked %d times, want 1", calls)
+	}
+
+	var balance models.Balance
+	alice.decode(alice.do(http.MethodGet, "/api/user/balance", "", "", nil), &balance)
+	if balance.Current != 10000 || balance.Withdrawn != 0 {
+		t.Fatalf("got balance %+v, want 100 current", balance)
+	}
+
+	time.Sleep(time.Second)
+	retried := alice.do(http.MethodPost, "/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":40}`, withdraw)
+	alice.expect(retried, http.StatusOK)
+	if retried.Header.Get("Idempotent-Replayed") != "" {
+		t.Fatal("a refused withdrawal was replayed")
+	}
+	alice.expect(alice.do(http.MethodPost, capture, "", "", nil), http.StatusOK)
+	alice.decode(alice.do(http.MethodGet, "/api/user/balance", "", "", nil), &balance)
+	if balance.Current != 5000 || balance.Withdrawn != 5000 {
+		t.Fatalf("got balance %+v, want 50 current and 50 withdrawn", balance)
+	}
+}
+
+func TestSameNumberUploadedAndWithdrawn(t *testing.T) {
+	accrualSystem := fake.NewServer()
+	defer accrualSystem.Close()
+	accrualSystem.Script("12345678903", fake.Response{Status: models.OrderStatusProcessed, Accrual: 10000})
+	server := testServer(t, accrualSystem)
+
+	alice := register(t, server, "alice")
+	alice.expect(alice.do(http.MethodPost, "/api/user/orders", "text/plain", "12345678903", nil), http.StatusAccepted)
+	waitProcessed(alice, "12345678903")
+
+	// The withdrawal is credited with the accrual of its number as well,
+	// next to the order.
+	alice.expect(alice.do(http.MethodPost, "/api/user/balance/withdraw", "application/json",
+		`{"order":"12345678903","sum":50}`, nil), http.StatusOK)
+	var balance models.Balance
+	alice.decode(alice.do(http.MethodGet, "/api/user/balance", "", "", nil), &balance)
+	if balance.Current != 15000 || balance.Withdrawn != 5000 {
+		t.Fatalf("got balance %+v, want 150 current and 50 withdrawn", balance)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	accrualSystem := fake.NewServer()
+	defer accrualSystem.Close()
+	server := testServer(t, accrualSystem)
+
+	anonymous := &client{t: t, server: server}
+	anonymous.expect(anonymous.do(http.MethodGet, "/api/user/balance", "", "", nil), http.StatusUnauthorized)
+	anonymous.expect(anonymous.do(http.MethodPost, "/api/user/orders", "text/plain", "12345678903", nil), http.StatusUnauthorized)
+
+	register(t, server, "alice")
+	anonymous.expect(anonymous.do(http.MethodPost, "/api/user/register", "application/json",
+		`{"login":"alice","password":"correct-horse-battery"}`, nil), http.StatusConflict)
+	anonymous.expect(anonymous.do(http.MethodPost, "/api/user/login", "application/json",
+		`{"login":"alice","password":"wrong-horse-battery"}`, nil), http.StatusUnauthorized)
+	// A failed login delays the next attempt, even with the right password.
+	throttled := anonymous.do(http.MethodPost, "/api/user/login", "application/json",
+		`{"login":"alice","password":"correct-horse-battery"}`, nil)
+	anonymous.expect(throttled, http.StatusTooManyRequests)
+	if throttled.Header.Get("Retry-After") == "" {
+		t.Fatal("throttled login has no Retry-After header")
+	}
+}
+
+func TestPasswordChangeThrottled(t *testing.T) {
+	accrualSystem := fake.NewServer()
+	defer accrualSystem.Close()
+	server := testServer(t, accrualSystem)
+
+	alice := register(t, server, "alice")
+	alice.expect(alice.do(http.MethodPost, "/api/user/password", "application/json",
+		`{"current_password":"wrong-horse-battery","new_password":"staple-horse-battery"}`, nil), http.StatusForbidden)
+	throttled := alice.do(http.MethodPost, "/api/user/password", "application/json",
+		`{"current_password":"correct-horse-battery","new_password":"staple-horse-battery"}`, nil)
+	alice.expect(throttled, http.StatusTooManyRequests)
+	if throttled.Header.Get("Retry-After") == "" {
+		t.Fatal("throttled password change has no Retry-After header")
+	}
+	// The wrong current password slows down logins as well.
+	anonymous := &client{t: t, server: server}
+	anonymous.expect(anonymous.do(http.MethodPost, "/api/user/login", "application/json",
+		`{"login":"alice","password":"correct-horse-battery"}`, nil), http.StatusTooManyRequests)
+}
+
+func TestPasswordResetRequestThrottled(t *testing.T) {
+	accrualSystem := fake.NewServer()
+	defer accrualSystem.Close()
+	server := testServer(t, accrualSystem)
+
+	register(t, server, "alice")
+	anonymous := &client{t: t, server: server}
+	for _, login := range []string{"alice", "nobody"} {
+		anonymous.expect(anonymous.do(http.MethodPost, "/api/user/password/reset/request", "application/json",
+			`{"login":"`+login+`"}`, nil), http.StatusAccepted)
+		// Known and unknown logins are throttled alike.
+		throttled := anonymous.do(http.MethodPost, "/api/user/password/reset/request", "application/json",
+			`{"login":"`+login+`"}`, nil)
+		anonymous.expect(throttled, http.StatusTooManyRequests)
+		if throttled.Header.Get("Retry-After") == "" {
+			t.Fatalf("throttled reset request for %s has no Retry-After header", login)
+		}
+	}
+	// Reset requests do not count as failed logins.
+	anonymous.expect(anonymous.do(http.MethodPost, "/api/user/login", "application/json",
+		`{"login":"alice","password":"correct-horse-battery"}`, nil), http.StatusOK)
+}
@@ -1,46 +1,46 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	logger "github.com/thalq/gopher_mart/internal/middleware"
+	"github.com/thalq/gopher_mart/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var db *sql.DB
 
-func InitDB(connectionString string) {
+// Connect opens the pool without touching the schema.
+func Connect(connectionString string) {
 	var err error
-	db, err = sql.Open("pgx", connectionString)
+	db, err = otelsql.Open("pgx", connectionString,
+		otelsql.WithAttributes(attribute.String("db.system", "postgresql")),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return tracing.HasParent(ctx)
+			},
+		}),
+	)
 	if err != nil {
 		logger.Sugar.Fatalf("Error open db: %s", err)
 	}
 	if err := db.Ping(); err != nil {
 		logger.Sugar.Fatalf("Error ping db: %s", err)
 	}
+}
+
+// InitDB connects and applies pending migrations.
+func InitDB(connectionString string) {
+	Connect(connectionString)
 
-	createTables := `
-    CREATE TABLE IF NOT EXISTS users (
-        id SERIAL PRIMARY KEY,
-        username VARCHAR(255) UNIQUE,
-        password VARCHAR(255)
-    );
-    CREATE TABLE IF NOT EXISTS orders (
-        user_id INT REFERENCES users(id),
-        order_id VARCHAR(255),
-        status VARCHAR(10) DEFAULT 'NEW' CHECK (status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
-        upload_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-        withdrawal FLOAT DEFAULT 0.0,
-		accrual FLOAT DEFAULT 0.0
-    );
-    CREATE TABLE IF NOT EXISTS user_balance (
-        user_id INT UNIQUE REFERENCES users(id),
-        current_balance FLOAT DEFAULT 0.0
-    );
-    `
-
-	if _, err := db.Exec(createTables); err != nil {
-		logger.Sugar.Fatalf("Error create tables: %s", err)
+	if _, err := MigrateUp(context.Background(), db); err != nil {
+		logger.Sugar.Fatalf("Error migrate db: %s", err)
 	}
 
 	logger.Sugar.Info("DB connected")
@@ -52,3 +52,11 @@ func GetDB() *sql.DB {
 	}
 	return db
 }
+
+// Close closes the pool; it is safe to call when the pool is not opened.
+func Close() error {
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	logger "github.com/thalq/gopher_mart/internal/middleware"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger()
+	os.Exit(m.Run())
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Fatalf("migration %d has version %d, want %d", i, m.Version, i+1)
+		}
+		if m.Name == "" || m.Up == "" || m.Down == "" {
+			t.Fatalf("migration %04d_%s lacks a name, an up or a down file", m.Version, m.Name)
+		}
+	}
+}
+
+// TestMigrateUpDown applies every migration, rolls all of them back and
+// applies them again. It needs a scratch database in TEST_DATABASE_URI:
+// the schema is dropped at the end.
+func TestMigrateUpDown(t *testing.T) {
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	ctx := context.Background()
+	db, err := sql.Open("pgx", uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateUp(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := MigrateDown(ctx, db, len(migrations)); err != nil {
+			t.Error(err)
+		}
+	})
+	if applied, err := MigrateUp(ctx, db); err != nil || applied != 0 {
+		t.Fatalf("second MigrateUp applied %d migrations: %v", applied, err)
+	}
+
+	if rolledBack, err := MigrateDown(ctx, db, len(migrations)); err != nil || rolledBack != len(migrations) {
+		t.Fatalf("MigrateDown rolled back %d of %d migrations: %v", rolledBack, len(migrations), err)
+	}
+	statuses, err := MigrationsStatus(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			t.Fatalf("migration %04d_%s is still applied", status.Version, status.Name)
+		}
+	}
+
+	if applied, err := MigrateUp(ctx, db); err != nil || applied != len(migrations) {
+		t.Fatalf("MigrateUp applied %d of %d migrations: %v", applied, len(migrations), err)
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_balance;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS users;
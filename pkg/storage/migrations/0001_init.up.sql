@@ -0,0 +1,21 @@
+-- Tables may already exist on deployments created before schema_migrations.
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    username VARCHAR(255) UNIQUE,
+    password VARCHAR(255)
+);
+CREATE TABLE IF NOT EXISTS orders (
+    user_id INT REFERENCES users(id),
+    order_id VARCHAR(255),
+    status VARCHAR(10) DEFAULT 'NEW' CHECK (status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    upload_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    withdrawal NUMERIC(20, 2) DEFAULT 0,
+    accrual NUMERIC(20, 2) DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS user_balance (
+    user_id INT UNIQUE REFERENCES users(id),
+    current_balance NUMERIC(20, 2) DEFAULT 0
+);
+ALTER TABLE orders ALTER COLUMN withdrawal TYPE NUMERIC(20, 2);
+ALTER TABLE orders ALTER COLUMN accrual TYPE NUMERIC(20, 2);
+ALTER TABLE user_balance ALTER COLUMN current_balance TYPE NUMERIC(20, 2);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS audit_events_actor;
+DROP INDEX IF EXISTS audit_events_subject;
+ALTER TABLE users DROP COLUMN IF EXISTS lock_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS locked_at;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
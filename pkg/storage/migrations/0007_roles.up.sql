@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user'
+    CHECK (role IN ('user', 'support', 'admin'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS lock_reason TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS audit_events_subject ON audit_events (subject);
+CREATE INDEX IF NOT EXISTS audit_events_actor ON audit_events (actor_id);
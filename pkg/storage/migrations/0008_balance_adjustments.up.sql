@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount <> 0),
+    reason_code VARCHAR(32) NOT NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'APPROVED', 'REJECTED')),
+    proposed_by INT NOT NULL REFERENCES users(id),
+    proposed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    decided_by INT REFERENCES users(id),
+    decided_at TIMESTAMP,
+    entry_id BIGINT UNIQUE REFERENCES ledger_entries(id),
+    CHECK (status <> 'APPROVED' OR (decided_by <> proposed_by AND entry_id IS NOT NULL))
+);
+CREATE INDEX IF NOT EXISTS balance_adjustments_status ON balance_adjustments (status);
+CREATE INDEX IF NOT EXISTS balance_adjustments_user ON balance_adjustments (user_id);
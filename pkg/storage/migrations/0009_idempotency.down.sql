@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS orders_withdrawal_number;
+DROP INDEX IF EXISTS orders_order_number;
+DROP TABLE IF EXISTS idempotency_keys;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id INT NOT NULL REFERENCES users(id),
+    key VARCHAR(255) NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    status_code INT NOT NULL DEFAULT 0,
+    content_type VARCHAR(255) NOT NULL DEFAULT '',
+    body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at ON idempotency_keys (created_at);
+
+-- Uploads that raced before the constraint existed left duplicate rows;
+-- the accrual is credited once per number, so the first row is kept.
+DELETE FROM orders o
+USING orders d
+WHERE o.withdrawal = 0 AND d.withdrawal = 0
+    AND o.order_id = d.order_id AND o.ctid > d.ctid;
+-- Duplicate withdrawals were debited twice and can not be dropped silently.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM orders WHERE withdrawal > 0 GROUP BY order_id HAVING COUNT(*) > 1) THEN
+        RAISE EXCEPTION 'orders has duplicate withdrawal numbers, resolve them before migrating';
+    END IF;
+END
+$$;
+CREATE UNIQUE INDEX IF NOT EXISTS orders_order_number ON orders (order_id) WHERE withdrawal = 0;
+CREATE UNIQUE INDEX IF NOT EXISTS orders_withdrawal_number ON orders (order_id) WHERE withdrawal > 0;
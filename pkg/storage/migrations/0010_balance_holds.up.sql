@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS balance_holds (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    order_id VARCHAR(255) NOT NULL,
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0),
+    status VARCHAR(16) NOT NULL DEFAULT 'HELD' CHECK (status IN ('HELD', 'CAPTURED', 'VOIDED', 'EXPIRED')),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    closed_at TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS balance_holds_active_order ON balance_holds (order_id) WHERE status = 'HELD';
+CREATE INDEX IF NOT EXISTS balance_holds_user ON balance_holds (user_id);
+CREATE INDEX IF NOT EXISTS balance_holds_expires_at ON balance_holds (expires_at) WHERE status = 'HELD';
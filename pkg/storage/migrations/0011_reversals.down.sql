@@ -0,0 +1,3 @@
+-- Entries reversed several times would violate UNIQUE (reverses), so the
+-- plain index stays in its place.
+ALTER TABLE orders DROP COLUMN IF EXISTS reversed;
@@ -0,0 +1,6 @@
+-- How much of the accrual of an order, or of a withdrawal, has been
+-- reversed or refunded.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS reversed NUMERIC(20, 2) NOT NULL DEFAULT 0 CHECK (reversed >= 0);
+-- Partial reversals reverse the same entry several times.
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_reverses_key;
+CREATE INDEX IF NOT EXISTS ledger_entries_reverses ON ledger_entries (reverses);
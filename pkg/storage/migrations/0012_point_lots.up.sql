@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS point_lots (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    order_id VARCHAR(255) NOT NULL,
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0),
+    remaining NUMERIC(20, 2) NOT NULL CHECK (remaining >= 0 AND remaining <= amount),
+    earned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expired_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS point_lots_user ON point_lots (user_id, earned_at) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS point_lots_earned_at ON point_lots (earned_at) WHERE remaining > 0;
+
+-- Points on hand before lots existed have no provenance: they become one
+-- lot per user, earned now.
+INSERT INTO point_lots (user_id, order_id, amount, remaining)
+SELECT user_id, '', current_balance, current_balance
+FROM user_balance
+WHERE current_balance > 0 AND NOT EXISTS (SELECT 1 FROM point_lots);
+
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_kind_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_kind_check
+    CHECK (kind IN ('ACCRUAL', 'WITHDRAWAL', 'REVERSAL', 'ADJUSTMENT', 'EXPIRY'));
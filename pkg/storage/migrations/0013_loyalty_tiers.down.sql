@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS ledger_entries_created_at;
+DROP TABLE IF EXISTS user_tiers;
@@ -0,0 +1,11 @@
+-- Tier history. The current tier of a user is their latest row.
+CREATE TABLE IF NOT EXISTS user_tiers (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    tier VARCHAR(64) NOT NULL,
+    accrued NUMERIC(20, 2) NOT NULL,
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS user_tiers_user ON user_tiers (user_id, id);
+-- Rolling accruals are summed over recent entries.
+CREATE INDEX IF NOT EXISTS ledger_entries_created_at ON ledger_entries (created_at);
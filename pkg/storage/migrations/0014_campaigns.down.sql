@@ -0,0 +1,5 @@
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_kind_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_kind_check
+    CHECK (kind IN ('ACCRUAL', 'WITHDRAWAL', 'REVERSAL', 'ADJUSTMENT', 'EXPIRY')) NOT VALID;
+DROP TABLE IF EXISTS campaign_awards;
+DROP TABLE IF EXISTS campaigns;
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS campaigns (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL CHECK (ends_at > starts_at),
+    min_accrual NUMERIC(20, 2) NOT NULL DEFAULT 0 CHECK (min_accrual >= 0),
+    first_order BOOLEAN NOT NULL DEFAULT FALSE,
+    fixed NUMERIC(20, 2) NOT NULL DEFAULT 0 CHECK (fixed >= 0),
+    rate DOUBLE PRECISION NOT NULL DEFAULT 0 CHECK (rate >= 0),
+    user_budget NUMERIC(20, 2) NOT NULL DEFAULT 0 CHECK (user_budget >= 0),
+    budget NUMERIC(20, 2) NOT NULL DEFAULT 0 CHECK (budget >= 0),
+    spent NUMERIC(20, 2) NOT NULL DEFAULT 0 CHECK (spent >= 0),
+    created_by INT NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (fixed > 0 OR rate > 0)
+);
+CREATE INDEX IF NOT EXISTS campaigns_running ON campaigns (starts_at, ends_at) WHERE active;
+
+CREATE TABLE IF NOT EXISTS campaign_awards (
+    id BIGSERIAL PRIMARY KEY,
+    campaign_id BIGINT NOT NULL REFERENCES campaigns(id),
+    user_id INT NOT NULL REFERENCES users(id),
+    order_id VARCHAR(255) NOT NULL,
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0),
+    entry_id BIGINT UNIQUE NOT NULL REFERENCES ledger_entries(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (campaign_id, order_id)
+);
+CREATE INDEX IF NOT EXISTS campaign_awards_user ON campaign_awards (campaign_id, user_id);
+
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_kind_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_kind_check
+    CHECK (kind IN ('ACCRUAL', 'WITHDRAWAL', 'REVERSAL', 'ADJUSTMENT', 'EXPIRY', 'BONUS'));
@@ -0,0 +1,7 @@
+-- Pending orders are claimed when next_poll_at is due, and each claim moves
+-- it forward with a backoff, so old orders stuck in PROCESSING do not
+-- starve new ones and replicas do not poll the same order.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS next_poll_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS poll_attempts INT NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS orders_next_poll ON orders (next_poll_at)
+    WHERE status IN ('NEW', 'PROCESSING') AND withdrawal = 0;
@@ -0,0 +1,2 @@
+ALTER TABLE campaigns ALTER COLUMN rate_bp TYPE DOUBLE PRECISION USING rate_bp / 10000.0;
+ALTER TABLE campaigns RENAME COLUMN rate_bp TO rate;
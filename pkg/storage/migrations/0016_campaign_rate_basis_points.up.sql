@@ -0,0 +1,4 @@
+-- Rates are kept in integer basis points, so bonuses are computed without
+-- floating point.
+ALTER TABLE campaigns RENAME COLUMN rate TO rate_bp;
+ALTER TABLE campaigns ALTER COLUMN rate_bp TYPE BIGINT USING round(rate_bp * 10000)::BIGINT;
@@ -0,0 +1,6 @@
+-- An unfinished request holds its key until locked_until, so a claim left
+-- by a crashed process does not block retries for the whole retention TTL.
+-- claim identifies the holder, so a late holder can not overwrite the
+-- response of the request that took the key over.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS claim VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
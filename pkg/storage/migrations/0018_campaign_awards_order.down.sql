@@ -0,0 +1 @@
+DROP INDEX IF EXISTS campaign_awards_order;
@@ -0,0 +1,3 @@
+-- Reversing the accrual of an order reverses the campaign bonuses awarded
+-- for it, which are looked up by order number.
+CREATE INDEX IF NOT EXISTS campaign_awards_order ON campaign_awards (order_id);
@@ -0,0 +1,19 @@
+-- A number credited both as a withdrawal and as an order can not go back
+-- to a single reference.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM ledger_entries w
+        JOIN ledger_entries o ON o.kind = 'ACCRUAL' AND 'withdrawal:' || o.reference = w.reference
+        WHERE w.kind = 'ACCRUAL'
+    ) THEN
+        RAISE EXCEPTION 'ledger_entries has accruals of numbers used as both an order and a withdrawal, resolve them before rolling back';
+    END IF;
+END
+$$;
+UPDATE ledger_entries
+SET reference = substr(reference, length('withdrawal:') + 1)
+WHERE kind = 'ACCRUAL' AND reference LIKE 'withdrawal:%';
+UPDATE point_lots
+SET order_id = substr(order_id, length('withdrawal:') + 1)
+WHERE order_id LIKE 'withdrawal:%';
@@ -0,0 +1,19 @@
+-- Withdrawals credit the accrual of their number under withdrawal:<number>,
+-- so the same number can also be uploaded and credited as an order. An
+-- ACCRUAL entry of a number that is a withdrawal belongs to the withdrawal
+-- unless an uploaded order of that number has been credited.
+UPDATE ledger_entries e
+SET reference = 'withdrawal:' || e.reference
+WHERE e.kind = 'ACCRUAL'
+    AND EXISTS (SELECT 1 FROM orders w WHERE w.order_id = e.reference AND w.withdrawal > 0)
+    AND NOT EXISTS (
+        SELECT 1 FROM orders o
+        WHERE o.order_id = e.reference AND o.withdrawal = 0 AND o.status = 'PROCESSED' AND o.accrual > 0
+    );
+UPDATE point_lots l
+SET order_id = 'withdrawal:' || l.order_id
+WHERE EXISTS (SELECT 1 FROM orders w WHERE w.order_id = l.order_id AND w.user_id = l.user_id AND w.withdrawal > 0)
+    AND NOT EXISTS (
+        SELECT 1 FROM orders o
+        WHERE o.order_id = l.order_id AND o.withdrawal = 0 AND o.status = 'PROCESSED' AND o.accrual > 0
+    );